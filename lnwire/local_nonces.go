@@ -0,0 +1,1297 @@
+package lnwire
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec/v2/schnorr/musig2"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/lightningnetwork/lnd/fn/v2"
+	"github.com/lightningnetwork/lnd/tlv"
+)
+
+const (
+	// localNoncesCountSize is the size of the entry count that prefixes
+	// an encoded LocalNoncesData record.
+	localNoncesCountSize = 2
+
+	// localNonceEntrySize is the encoded size of a single entry within a
+	// LocalNoncesData record: the txid followed by the musig2 public
+	// nonce.
+	localNonceEntrySize = chainhash.HashSize + musig2.PubNonceSize
+
+	// MaxLocalNonces is the maximum number of entries that fit into a
+	// single LocalNoncesData record of a P2P TLV stream.
+	MaxLocalNonces = (tlv.MaxRecordSize - localNoncesCountSize) /
+		localNonceEntrySize
+
+	// MaxLocalNoncesRecordBytes is the encoded size of a LocalNoncesData
+	// record holding MaxLocalNonces entries.
+	MaxLocalNoncesRecordBytes = localNoncesCountSize +
+		MaxLocalNonces*localNonceEntrySize
+)
+
+var (
+	// ErrLocalNoncesRecordTooShort is returned when a LocalNoncesData
+	// record is too short to even hold the entry count.
+	ErrLocalNoncesRecordTooShort = errors.New("record too short for " +
+		"numEntries")
+
+	// ErrLocalNoncesLengthMismatch is returned when the length of a
+	// LocalNoncesData record doesn't match the number of entries it
+	// claims to hold.
+	ErrLocalNoncesLengthMismatch = errors.New("record length mismatch")
+
+	// ErrLocalNoncesZeroCountWithData is returned when a LocalNoncesData
+	// record claims to hold no entries, yet is followed by more data.
+	ErrLocalNoncesZeroCountWithData = errors.New("zero entry count with " +
+		"trailing data")
+
+	// ErrLocalNoncesDuplicateTxid is returned when a LocalNoncesData
+	// record contains more than one entry for the same txid.
+	ErrLocalNoncesDuplicateTxid = errors.New("duplicate txid in local " +
+		"nonces record")
+
+	// ErrUnexpectedLocalNoncesType is returned when a record of a type
+	// other than LocalNoncesRecordTypeT is parsed as a LocalNoncesData.
+	ErrUnexpectedLocalNoncesType = errors.New("unexpected local nonces " +
+		"record type")
+
+	// ErrTooManyLocalNonces is returned when a LocalNoncesData holds more
+	// entries than fit into a single record.
+	ErrTooManyLocalNonces = errors.New("too many local nonces")
+
+	// ErrLocalNoncesRecordTruncated is returned when a record claims to
+	// be longer than the data that's left in the reader.
+	ErrLocalNoncesRecordTruncated = errors.New("local nonces record " +
+		"truncated")
+
+	// ErrLocalNoncesRecordTooLarge is returned when the length prefix of
+	// a standalone LocalNoncesData encoding exceeds
+	// MaxLocalNoncesRecordBytes.
+	ErrLocalNoncesRecordTooLarge = errors.New("local nonces record too " +
+		"large")
+)
+
+// LocalNoncesDecodeError is returned when a LocalNoncesData record is
+// malformed, pointing at the byte offset within the record where decoding
+// failed. This makes it possible to locate the problem in a hex dump of the
+// record. The underlying error can be inspected with errors.Is.
+type LocalNoncesDecodeError struct {
+	// Offset is the offset within the record, starting at the count
+	// field (or the bitmap of a bitmap encoded record), of the first byte
+	// that couldn't be decoded.
+	Offset uint64
+
+	// Reason names the field that couldn't be decoded, such as the count
+	// or the txid of a given entry.
+	Reason string
+
+	// Err is the underlying error.
+	Err error
+}
+
+// Error returns a human readable description of the error.
+func (e *LocalNoncesDecodeError) Error() string {
+	return fmt.Sprintf("invalid local nonces record at offset %d (%s): "+
+		"%v", e.Offset, e.Reason, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *LocalNoncesDecodeError) Unwrap() error {
+	return e.Err
+}
+
+// newLocalNoncesCountError returns a LocalNoncesDecodeError for a problem
+// with the count field of a record.
+func newLocalNoncesCountError(err error) *LocalNoncesDecodeError {
+	return &LocalNoncesDecodeError{
+		Reason: "count",
+		Err:    err,
+	}
+}
+
+// newLocalNoncesEntryError returns a LocalNoncesDecodeError for a problem at
+// the given offset within the entries of a record, which start right after
+// the count field.
+func newLocalNoncesEntryError(bodyOffset uint64,
+	err error) *LocalNoncesDecodeError {
+
+	field := "txid"
+	if bodyOffset%localNonceEntrySize >= chainhash.HashSize {
+		field = "nonce"
+	}
+
+	return &LocalNoncesDecodeError{
+		Offset: localNoncesCountSize + bodyOffset,
+		Reason: fmt.Sprintf("entry %d %s",
+			bodyOffset/localNonceEntrySize, field),
+		Err: err,
+	}
+}
+
+// LocalNoncesRecordTypeT is the TLV type used to encode a set of local musig2
+// nonces, each keyed by the txid of the transaction it'll be used to sign.
+type LocalNoncesRecordTypeT = tlv.TlvType22
+
+// localNoncesRecordType is the TLV (integer) type used to encode a set of
+// local musig2 nonces.
+var localNoncesRecordType tlv.Type = (LocalNoncesRecordTypeT)(nil).TypeVal()
+
+// LocalNoncesData holds a set of musig2 public nonces, each keyed by the txid
+// of the transaction the nonce is meant to sign. On the wire, the set is
+// encoded as a 2-byte entry count followed by the entries sorted by txid,
+// where each entry is the 32-byte txid followed by the 66-byte nonce.
+type LocalNoncesData struct {
+	// NoncesMap maps the txid of a transaction to the local nonce that
+	// will be used to sign it.
+	NoncesMap map[chainhash.Hash]Musig2Nonce
+
+	// sources optionally labels entries with where they came from, such
+	// as the peer that contributed them. This is purely local metadata
+	// that never makes it onto the wire.
+	sources map[chainhash.Hash]string
+
+	// roles optionally tags entries with the role of the transaction they
+	// sign. Roles are only encoded by LocalNoncesEncodingRoleTagged.
+	roles map[chainhash.Hash]LocalNonceRole
+
+	// createdAt optionally records when the nonces of entries were
+	// created, so that stale ones can be refreshed. Just like sources,
+	// this is local metadata only.
+	createdAt map[chainhash.Hash]time.Time
+
+	// charge is what the set was charged against the budget set with
+	// SetLocalNoncesBudget when it was decoded, if anything. It's shared
+	// by all copies of the set, so that it's only given back once.
+	charge *localNoncesCharge
+}
+
+// LocalNoncesView is a read-only view of a set of local nonces. Functions that
+// only inspect a set can accept a LocalNoncesView rather than a
+// *LocalNoncesData, which documents that intent and rules out accidental
+// writes.
+type LocalNoncesView interface {
+	// Get returns the nonce for the given txid, along with a bool that
+	// indicates whether the set holds one.
+	Get(txid chainhash.Hash) (Musig2Nonce, bool)
+
+	// Contains returns true if the set holds a nonce for the given txid.
+	Contains(txid chainhash.Hash) bool
+
+	// Len returns the number of entries in the set.
+	Len() int
+
+	// SortedEntries returns the entries of the set in ascending txid
+	// order.
+	SortedEntries() []LocalNonceEntry
+
+	// EncodedSize returns the size of the record encoding of the set.
+	EncodedSize() int
+}
+
+// A compile time check to ensure LocalNoncesData implements the
+// LocalNoncesView interface.
+var _ LocalNoncesView = (*LocalNoncesData)(nil)
+
+// LocalNonceEntry is a single txid and nonce pair of a LocalNoncesData.
+type LocalNonceEntry struct {
+	// TXID is the txid of the transaction the nonce is used to sign.
+	TXID chainhash.Hash
+
+	// Nonce is the local musig2 public nonce.
+	Nonce Musig2Nonce
+}
+
+// CompareLocalNonceEntries orders two entries the way they're written on the
+// wire, which is by ascending txid. The nonces only serve as a tiebreaker, as
+// the txids of a set are unique. The function can be used with
+// slices.SortFunc to sort entries consistently with the encoder.
+func CompareLocalNonceEntries(a, b LocalNonceEntry) int {
+	if c := bytes.Compare(a.TXID[:], b.TXID[:]); c != 0 {
+		return c
+	}
+
+	return bytes.Compare(a.Nonce[:], b.Nonce[:])
+}
+
+type (
+	// LocalNoncesTLV is a TLV type that can be used to encode/decode a
+	// set of local musig2 nonces.
+	LocalNoncesTLV = tlv.RecordT[LocalNoncesRecordTypeT, LocalNoncesData]
+
+	// OptLocalNonces is a TLV type that can be used to encode/decode an
+	// optional set of local musig2 nonces.
+	OptLocalNonces = tlv.OptionalRecordT[
+		LocalNoncesRecordTypeT, LocalNoncesData,
+	]
+)
+
+// SomeLocalNonces is a helper function that creates an optional local nonces
+// TLV record.
+func SomeLocalNonces(nonces LocalNoncesData) OptLocalNonces {
+	return tlv.SomeRecordT(
+		tlv.NewRecordT[LocalNoncesRecordTypeT, LocalNoncesData](nonces),
+	)
+}
+
+// EmptyLocalNoncesData returns a set without any entries, but with a non-nil
+// map. Wrapped with SomeLocalNonces, it's encoded as a present record with a
+// zero count, which positively signals that the sender holds no nonces, as
+// opposed to omitting the record altogether. See IsPresentEmpty for the
+// receiving side.
+func EmptyLocalNoncesData() LocalNoncesData {
+	return LocalNoncesData{
+		NoncesMap: make(map[chainhash.Hash]Musig2Nonce),
+	}
+}
+
+// IsPresentEmpty returns true if the optional record is present but holds no
+// entries, meaning the sender explicitly signalled that it has no nonces.
+func IsPresentEmpty(nonces OptLocalNonces) bool {
+	return fn.MapOptionZ(nonces.ValOpt(), func(lnd LocalNoncesData) bool {
+		return len(lnd.NoncesMap) == 0
+	})
+}
+
+// SingleLocalNonce returns a set holding only the nonce for the given txid,
+// which covers the common case of signing a single transaction.
+func SingleLocalNonce(txid chainhash.Hash,
+	nonce Musig2Nonce) *LocalNoncesData {
+
+	return &LocalNoncesData{
+		NoncesMap: map[chainhash.Hash]Musig2Nonce{
+			txid: nonce,
+		},
+	}
+}
+
+// ExtractLocalNonces parses the local nonces record out of the given extra
+// opaque data. None is returned if the record isn't present, an error is only
+// returned if the record is present but malformed.
+func ExtractLocalNonces(eod ExtraOpaqueData) (OptLocalNonces, error) {
+	var localNonces OptLocalNonces
+
+	record := localNonces.Zero()
+	typeMap, err := eod.ExtractRecords(&record)
+	if err != nil {
+		return localNonces, err
+	}
+
+	if val, ok := typeMap[localNonces.TlvType()]; ok && val == nil {
+		localNonces = tlv.SomeRecordT(record)
+	}
+
+	return localNonces, nil
+}
+
+// Len returns the number of entries in the set.
+//
+// NOTE: A nil *LocalNoncesData is treated as an empty set by Len, IsEmpty,
+// Contains, Get, EncodedSize and the encoders, so these are safe to call on a
+// set that was never populated.
+func (lnd *LocalNoncesData) Len() int {
+	if lnd == nil {
+		return 0
+	}
+
+	return len(lnd.NoncesMap)
+}
+
+// IsEmpty returns true if the set holds no entries.
+func (lnd *LocalNoncesData) IsEmpty() bool {
+	return lnd.Len() == 0
+}
+
+// Contains returns true if the set holds a nonce for the given txid.
+func (lnd *LocalNoncesData) Contains(txid chainhash.Hash) bool {
+	_, ok := lnd.Get(txid)
+	return ok
+}
+
+// Get returns the nonce for the given txid, along with a bool that indicates
+// whether the set holds one.
+func (lnd *LocalNoncesData) Get(txid chainhash.Hash) (Musig2Nonce, bool) {
+	if lnd == nil {
+		return Musig2Nonce{}, false
+	}
+
+	nonce, ok := lnd.NoncesMap[txid]
+
+	return nonce, ok
+}
+
+// SetSource labels the entry of the given txid with the source it came from,
+// for instance the peer that contributed it, for auditing purposes. Labels are
+// never encoded. They are carried along by Merge and Apply, and dropped once
+// their entry is removed by Apply, the set is zeroized, or it is decoded into.
+func (lnd *LocalNoncesData) SetSource(txid chainhash.Hash, label string) {
+	if lnd.sources == nil {
+		lnd.sources = make(map[chainhash.Hash]string)
+	}
+	lnd.sources[txid] = label
+}
+
+// Source returns the label of the entry of the given txid, along with a bool
+// that indicates whether the entry was labeled at all.
+func (lnd *LocalNoncesData) Source(txid chainhash.Hash) (string, bool) {
+	if lnd == nil {
+		return "", false
+	}
+
+	label, ok := lnd.sources[txid]
+
+	return label, ok
+}
+
+// Zeroize overwrites every nonce in the set with zeroes before removing all
+// entries from the map. Public nonces aren't secret, so this is purely for
+// callers with strict memory hygiene requirements once a signing session is
+// over. As Go may have copied the nonces elsewhere, the wipe is best effort.
+func (lnd *LocalNoncesData) Zeroize() {
+	for txid := range lnd.NoncesMap {
+		lnd.NoncesMap[txid] = Musig2Nonce{}
+	}
+
+	clear(lnd.NoncesMap)
+	lnd.sources = nil
+	lnd.roles = nil
+	lnd.createdAt = nil
+	lnd.ReleaseBudget()
+}
+
+// Redacted returns a copy of the set in which every nonce is replaced with the
+// all-zero placeholder, while the txids are kept. This makes the set safe to
+// include in logs or issue reports that are shared externally.
+func (lnd *LocalNoncesData) Redacted() *LocalNoncesData {
+	redacted := copyLocalNonces(lnd)
+	for txid := range redacted.NoncesMap {
+		redacted.NoncesMap[txid] = Musig2Nonce{}
+	}
+
+	return redacted
+}
+
+// copyLocalNonces returns a copy of lnd, including its source labels, roles
+// and creation times. A nil lnd results in an empty set.
+func copyLocalNonces(lnd *LocalNoncesData) *LocalNoncesData {
+	nonces := &LocalNoncesData{}
+
+	// Applying to an empty set can't conflict.
+	_ = nonces.Apply(lnd, nil)
+
+	return nonces
+}
+
+// Record returns a TLV record that can be used to encode/decode the set of
+// local nonces from a given TLV stream.
+func (lnd *LocalNoncesData) Record() tlv.Record {
+	sizeFunc := func() uint64 {
+		return localNoncesCountSize +
+			uint64(len(lnd.NoncesMap))*localNonceEntrySize
+	}
+
+	return tlv.MakeDynamicRecord(
+		localNoncesRecordType, lnd, sizeFunc, encodeLocalNoncesData,
+		decodeLocalNoncesData,
+	)
+}
+
+// sortedTxids returns the txids of the set in ascending byte order, which is
+// the order the entries are written in on the wire.
+//
+// NOTE: The txids are the keys of NoncesMap, so no two of them are ever
+// equal, and the comparator never needs a tiebreak for the result to be
+// deterministic. If the txids are ever sourced from anything that allows
+// duplicates, such as a slice, they must be rejected before sorting.
+func (lnd *LocalNoncesData) sortedTxids() []chainhash.Hash {
+	if lnd.IsEmpty() {
+		return nil
+	}
+
+	txids := make([]chainhash.Hash, 0, len(lnd.NoncesMap))
+	for txid := range lnd.NoncesMap {
+		txids = append(txids, txid)
+	}
+
+	sort.Slice(txids, func(i, j int) bool {
+		return bytes.Compare(txids[i][:], txids[j][:]) < 0
+	})
+
+	return txids
+}
+
+// ParseLocalNoncesPrefix decodes a single record value from the front of b,
+// using its entry count to determine where it ends. The decoded set is
+// returned along with the number of bytes consumed, which is the offset in b
+// at which any data following the record starts. The bytes after the record
+// are left untouched.
+func ParseLocalNoncesPrefix(b []byte) (*LocalNoncesData, int, error) {
+	if len(b) < localNoncesCountSize {
+		return nil, 0, fmt.Errorf("%w: %d bytes",
+			ErrLocalNoncesRecordTooShort, len(b))
+	}
+
+	numEntries := binary.BigEndian.Uint16(b)
+	recordLen := localNoncesCountSize + int(numEntries)*localNonceEntrySize
+	if len(b) < recordLen {
+		return nil, 0, fmt.Errorf("%w: %d entries need %d bytes, got "+
+			"%d", io.ErrUnexpectedEOF, numEntries, recordLen,
+			len(b))
+	}
+
+	var (
+		lnd LocalNoncesData
+		buf [8]byte
+	)
+	err := decodeLocalNoncesData(
+		bytes.NewReader(b[:recordLen]), &lnd, &buf, uint64(recordLen),
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return &lnd, recordLen, nil
+}
+
+// ParseLocalNoncesRecord decodes the value of a record of the given TLV
+// type, such as an entry of a tlv.TypeMap, as a LocalNoncesData. An error is
+// returned if the type isn't the local nonces type. This catches records that
+// were mistakenly routed here, which would otherwise surface as a confusing
+// length mismatch.
+func ParseLocalNoncesRecord(typ tlv.Type,
+	value []byte) (*LocalNoncesData, error) {
+
+	if typ != localNoncesRecordType {
+		return nil, fmt.Errorf("%w: got type %d, expected %d",
+			ErrUnexpectedLocalNoncesType, typ,
+			localNoncesRecordType)
+	}
+
+	var (
+		lnd LocalNoncesData
+		buf [8]byte
+	)
+	err := decodeLocalNoncesData(
+		bytes.NewReader(value), &lnd, &buf, uint64(len(value)),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &lnd, nil
+}
+
+// DecodeLocalNoncesFlat decodes the legacy flat format, which is simply the
+// concatenation of the entries without a leading count, into a set. The
+// number of entries is implied by the length of b, which must be a multiple
+// of the entry size. This eases migrating blobs persisted in that format.
+func DecodeLocalNoncesFlat(b []byte) (*LocalNoncesData, error) {
+	if len(b)%localNonceEntrySize != 0 {
+		return nil, fmt.Errorf("%w: %d bytes isn't a multiple of the "+
+			"%d byte entry size", ErrLocalNoncesLengthMismatch,
+			len(b), localNonceEntrySize)
+	}
+
+	numEntries := len(b) / localNonceEntrySize
+	if numEntries > MaxLocalNonces {
+		return nil, fmt.Errorf("%w: %d entries, max is %d",
+			ErrTooManyLocalNonces, numEntries, MaxLocalNonces)
+	}
+
+	nonces := make(map[chainhash.Hash]Musig2Nonce, numEntries)
+	err := decodeLocalNoncesBody(
+		bytes.NewReader(b), uint16(numEntries), nonces,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LocalNoncesData{NoncesMap: nonces}, nil
+}
+
+// writeLocalNoncesCount writes the 2-byte entry count that prefixes every
+// encoding of a LocalNoncesData.
+func writeLocalNoncesCount(w io.Writer, numEntries int) error {
+	if numEntries > math.MaxUint16 {
+		return fmt.Errorf("%w: %d entries", ErrTooManyLocalNonces,
+			numEntries)
+	}
+
+	var numEntriesBuf [localNoncesCountSize]byte
+	binary.BigEndian.PutUint16(numEntriesBuf[:], uint16(numEntries))
+	_, err := w.Write(numEntriesBuf[:])
+
+	return err
+}
+
+// readLocalNoncesCount reads the 2-byte entry count that prefixes every
+// encoding of a LocalNoncesData.
+func readLocalNoncesCount(r io.Reader) (uint16, error) {
+	var numEntriesBuf [localNoncesCountSize]byte
+	if _, err := io.ReadFull(r, numEntriesBuf[:]); err != nil {
+		return 0, err
+	}
+
+	return binary.BigEndian.Uint16(numEntriesBuf[:]), nil
+}
+
+// localNoncesLayout describes how the entries of a record encoding are laid
+// out, as far as checking the length of a record against its count goes.
+type localNoncesLayout struct {
+	// entrySize is the size of every entry, or the minimum size of an
+	// entry if variable is set.
+	entrySize uint64
+
+	// variable is true if entries may take up more than entrySize bytes,
+	// in which case the record length only bounds the count.
+	variable bool
+}
+
+// fixedLocalNoncesLayout is the layout of the record encoding.
+var fixedLocalNoncesLayout = localNoncesLayout{entrySize: localNonceEntrySize}
+
+// entryError returns a LocalNoncesDecodeError for a problem at the given
+// offset within the entries of a record, which start right after the count
+// field. The entry is only named if all entries are of the same size, and the
+// field within it only for the record encoding.
+func (l localNoncesLayout) entryError(bodyOffset uint64,
+	err error) *LocalNoncesDecodeError {
+
+	switch {
+	case l == fixedLocalNoncesLayout:
+		return newLocalNoncesEntryError(bodyOffset, err)
+
+	case !l.variable:
+		return &LocalNoncesDecodeError{
+			Offset: localNoncesCountSize + bodyOffset,
+			Reason: fmt.Sprintf("entry %d", bodyOffset/l.entrySize),
+			Err:    err,
+		}
+
+	default:
+		return &LocalNoncesDecodeError{
+			Offset: localNoncesCountSize + bodyOffset,
+			Reason: "entries",
+			Err:    err,
+		}
+	}
+}
+
+// readLocalNoncesHeader reads the entry count of a record of recordLen bytes,
+// checking that the record length matches the number of entries it claims to
+// hold.
+func readLocalNoncesHeader(r io.Reader, recordLen uint64) (uint16, error) {
+	return readLocalNoncesHeaderWith(r, recordLen, fixedLocalNoncesLayout)
+}
+
+// readLocalNoncesHeaderWith reads the entry count of a record of recordLen
+// bytes whose entries are laid out as described by layout, checking that the
+// record length fits the number of entries it claims to hold, and that it
+// doesn't claim more than MaxLocalNonces. Every error is a
+// LocalNoncesDecodeError.
+func readLocalNoncesHeaderWith(r io.Reader, recordLen uint64,
+	layout localNoncesLayout) (uint16, error) {
+
+	switch {
+	// A zero length record is treated as an empty set. Nothing is read
+	// from r in this case, as any bytes left there belong to whatever
+	// follows the record.
+	case recordLen == 0:
+		return 0, nil
+
+	// Any other record shorter than the count field, which can only be a
+	// single stray byte, is malformed.
+	case recordLen < localNoncesCountSize:
+		return 0, newLocalNoncesCountError(fmt.Errorf("%w: %d bytes",
+			ErrLocalNoncesRecordTooShort, recordLen))
+	}
+
+	numEntries, err := readLocalNoncesCount(r)
+	if err != nil {
+		return 0, newLocalNoncesCountError(err)
+	}
+
+	// Check for a count that overflowed on the sender's side first, so
+	// that the hint is attached no matter which check the wrapped count
+	// ends up failing.
+	hint := localNoncesCountOverflowHint(numEntries, recordLen, layout)
+
+	// A zero count followed by data is a clear sender bug, so we call it
+	// out explicitly. The error still matches the generic length mismatch.
+	if numEntries == 0 && recordLen > localNoncesCountSize {
+		return 0, newLocalNoncesCountError(fmt.Errorf("%w: %w: %d "+
+			"trailing bytes%s", ErrLocalNoncesZeroCountWithData,
+			ErrLocalNoncesLengthMismatch,
+			recordLen-localNoncesCountSize, hint))
+	}
+
+	// Even if the record length matches, we don't accept more entries
+	// than a P2P record can hold.
+	if numEntries > MaxLocalNonces {
+		return 0, newLocalNoncesCountError(fmt.Errorf("%w: record "+
+			"claims %d entries, max is %d%s", ErrTooManyLocalNonces,
+			numEntries, MaxLocalNonces, hint))
+	}
+
+	expectedLen := localNoncesCountSize +
+		uint64(numEntries)*layout.entrySize
+	switch {
+	case !layout.variable && recordLen != expectedLen:
+		return 0, newLocalNoncesCountError(fmt.Errorf("%w: expected "+
+			"%d bytes for %d entries, got %d%s",
+			ErrLocalNoncesLengthMismatch, expectedLen, numEntries,
+			recordLen, hint))
+
+	// Entries of a variable size take up at least the minimum size, so
+	// we can reject a count that can't possibly fit before allocating
+	// anything.
+	case layout.variable && recordLen < expectedLen:
+		return 0, newLocalNoncesCountError(fmt.Errorf("%w: %d entries "+
+			"need at least %d bytes, got %d",
+			ErrLocalNoncesLengthMismatch, numEntries, expectedLen,
+			recordLen))
+	}
+
+	// The framing is consistent, but if the reader knows how many bytes
+	// it has left, like bytes.Reader does, we can catch a record that
+	// claims more bytes than there are before reading any of the entries.
+	bodyLen := recordLen - localNoncesCountSize
+	if lr, ok := r.(interface{ Len() int }); ok &&
+		bodyLen > uint64(lr.Len()) {
+
+		// The first missing byte is the one right after those that
+		// are available.
+		return 0, layout.entryError(uint64(lr.Len()), fmt.Errorf(
+			"%w: %w: record claims %d bytes of entries, only %d "+
+				"available", ErrLocalNoncesRecordTruncated,
+			io.ErrUnexpectedEOF, bodyLen, lr.Len(),
+		))
+	}
+
+	return numEntries, nil
+}
+
+// localNoncesCountOverflowHint returns a hint to append to the error for a
+// record that's rejected because of its count, whether for not matching the
+// record length or for exceeding MaxLocalNonces, if the length is that of more
+// entries than the count field can hold, and the count is what that number of
+// entries wraps to. Older senders didn't refuse to encode such sets and
+// silently truncated the count instead, which results in exactly this kind of
+// record. An empty string is returned for any other mismatch, and for layouts
+// with entries of a variable size.
+func localNoncesCountOverflowHint(numEntries uint16, recordLen uint64,
+	layout localNoncesLayout) string {
+
+	bodyLen := recordLen - localNoncesCountSize
+	if layout.variable || bodyLen%layout.entrySize != 0 {
+		return ""
+	}
+
+	impliedEntries := bodyLen / layout.entrySize
+	if impliedEntries <= math.MaxUint16 ||
+		uint16(impliedEntries) != numEntries {
+
+		return ""
+	}
+
+	return fmt.Sprintf(" (record length implies %d entries, possible "+
+		"count overflow by the sender)", impliedEntries)
+}
+
+// SortedEntries returns the entries of the set in ascending txid order, which
+// is the order they're written in on the wire.
+func (lnd *LocalNoncesData) SortedEntries() []LocalNonceEntry {
+	txids := lnd.sortedTxids()
+	entries := make([]LocalNonceEntry, 0, len(txids))
+	for _, txid := range txids {
+		entries = append(entries, LocalNonceEntry{
+			TXID:  txid,
+			Nonce: lnd.NoncesMap[txid],
+		})
+	}
+
+	return entries
+}
+
+// encodeLocalNoncesData is a custom TLV encoder for the LocalNoncesData
+// record. Entries are always written in ascending txid order so that the
+// encoding of a given set is deterministic.
+//
+// NOTE: The scratch buffer handed in by the tlv package is unused. As with any
+// tlv codec, its contents are undefined on entry and it may be clobbered, so
+// it must never influence the encoding.
+func encodeLocalNoncesData(w io.Writer, val interface{}, _ *[8]byte) error {
+	v, ok := val.(*LocalNoncesData)
+	if !ok {
+		return tlv.NewTypeForEncodingErr(val, "lnwire.LocalNoncesData")
+	}
+
+	if v.Len() > MaxLocalNonces {
+		return fmt.Errorf("%w: %d entries, max is %d",
+			ErrTooManyLocalNonces, v.Len(), MaxLocalNonces)
+	}
+
+	if err := writeLocalNoncesCount(w, v.Len()); err != nil {
+		return err
+	}
+
+	// Most records carry the nonce of a single transaction. A single
+	// entry is already sorted, so we write it straight from the map
+	// without collecting and sorting the txids first. This saves two of
+	// the five allocations and roughly a third of the time it takes to
+	// encode such a record (see BenchmarkLocalNoncesSingleEntry).
+	if v.Len() == 1 {
+		for txid, nonce := range v.NoncesMap {
+			return writeLocalNonceEntry(w, txid, nonce)
+		}
+	}
+
+	for _, txid := range v.sortedTxids() {
+		err := writeLocalNonceEntry(w, txid, v.NoncesMap[txid])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeLocalNonceEntry writes a single txid and nonce pair to w.
+func writeLocalNonceEntry(w io.Writer, txid chainhash.Hash,
+	nonce Musig2Nonce) error {
+
+	if _, err := w.Write(txid[:]); err != nil {
+		return err
+	}
+
+	_, err := w.Write(nonce[:])
+
+	return err
+}
+
+// EncodedSize returns the size in bytes of the record encoding of the set,
+// without the TLV type and length that precede it.
+func (lnd *LocalNoncesData) EncodedSize() int {
+	return localNoncesCountSize + lnd.Len()*localNonceEntrySize
+}
+
+// FitsInMessage returns true if a message carrying the encoded set along with
+// otherBytes bytes of other payload stays within MaxMsgBody. The other bytes
+// must account for everything else in the message body, including the TLV
+// type and length of this record. Message builders can use this to bail out
+// before constructing a message that can't be sent.
+func (lnd *LocalNoncesData) FitsInMessage(otherBytes int) bool {
+	return lnd.EncodedSize()+otherBytes <= MaxMsgBody
+}
+
+// localNoncesTLVSize returns the size of a complete TLV record holding a set of
+// numEntries entries: the type, the BigSize length and the record value.
+func localNoncesTLVSize(numEntries int) uint64 {
+	valueLen := uint64(
+		localNoncesCountSize + numEntries*localNonceEntrySize,
+	)
+
+	return tlv.VarIntSize(uint64(localNoncesRecordType)) +
+		tlv.VarIntSize(valueLen) + valueLen
+}
+
+// MaxEntriesInBytes returns the maximum number of entries, capped at
+// MaxLocalNonces, of a LocalNoncesData whose complete TLV record fits within
+// budget bytes. The record includes its type and BigSize length, the latter of
+// which grows from one to three bytes once the value reaches 253 bytes, which
+// is accounted for. Zero is returned if not even an empty record fits.
+func MaxEntriesInBytes(budget uint64) int {
+	if budget < localNoncesTLVSize(0) {
+		return 0
+	}
+
+	numEntries := int(min(
+		(budget-localNoncesCountSize)/localNonceEntrySize,
+		MaxLocalNonces,
+	))
+
+	// The estimate above ignores the type and length, which together are
+	// smaller than a single entry, so we'll need to drop one entry at
+	// most.
+	if localNoncesTLVSize(numEntries) > budget {
+		numEntries--
+	}
+
+	return numEntries
+}
+
+// AppendTo appends the record encoding of the set to b and returns the
+// extended slice. This produces the exact same bytes as the TLV record
+// encoder, but lets callers that serialize many records reuse their buffer.
+func (lnd *LocalNoncesData) AppendTo(b []byte) ([]byte, error) {
+	numEntries := lnd.Len()
+	if numEntries > MaxLocalNonces {
+		return b, fmt.Errorf("%w: %d entries, max is %d",
+			ErrTooManyLocalNonces, numEntries, MaxLocalNonces)
+	}
+
+	b = slices.Grow(b, lnd.EncodedSize())
+	b = binary.BigEndian.AppendUint16(b, uint16(numEntries))
+
+	// As in encodeLocalNoncesData, a single entry doesn't need sorting,
+	// which makes appending it allocation free.
+	if numEntries == 1 {
+		for txid, nonce := range lnd.NoncesMap {
+			b = append(b, txid[:]...)
+			b = append(b, nonce[:]...)
+		}
+
+		return b, nil
+	}
+
+	for _, txid := range lnd.sortedTxids() {
+		nonce := lnd.NoncesMap[txid]
+		b = append(b, txid[:]...)
+		b = append(b, nonce[:]...)
+	}
+
+	return b, nil
+}
+
+// decodeLocalNoncesData is a custom TLV decoder for the LocalNoncesData
+// record. Decoding replaces rather than merges: on success the NoncesMap of
+// the target holds exactly the entries of the record, no matter what it held
+// before. On failure, the target is left untouched.
+//
+// The entries are always decoded into a freshly allocated map that replaces
+// the previous one, which is never cleared or written to. A record with a zero
+// count therefore leaves a reused target empty, while any references callers
+// still hold to its previous map keep seeing the old entries.
+//
+// NOTE: The decoder only sees the record value and can't tell which TLV type
+// it was read from. Callers that route records by hand should go through
+// ParseLocalNoncesRecord, which checks the type. Just like for the encoder,
+// the contents of the scratch buffer must never influence the result.
+func decodeLocalNoncesData(r io.Reader, val interface{}, _ *[8]byte,
+	recordLen uint64) error {
+
+	v, ok := val.(*LocalNoncesData)
+	if !ok {
+		return tlv.NewTypeForDecodingErr(
+			val, "lnwire.LocalNoncesData", recordLen,
+			localNoncesCountSize,
+		)
+	}
+
+	// If a quality hook is set, it's told about every record, including
+	// those that are rejected.
+	var quality *LocalNoncesQuality
+	if localNoncesQualityHook.Load() != nil {
+		quality = &LocalNoncesQuality{
+			RecordLen: recordLen,
+			Canonical: true,
+		}
+	}
+
+	numEntries, err := readLocalNoncesHeader(r, recordLen)
+	if err != nil {
+		reportLocalNoncesQuality(quality, err)
+		return err
+	}
+
+	// Reserve room for the entries before allocating anything, so that a
+	// swarm of peers can't exceed the global budget.
+	charged, err := chargeLocalNoncesBudget(uint64(numEntries))
+	if err != nil {
+		reportLocalNoncesQuality(quality, err)
+		return err
+	}
+
+	nonces := make(map[chainhash.Hash]Musig2Nonce, numEntries)
+	if quality != nil {
+		quality.NumEntries = int(numEntries)
+		err = decodeLocalNoncesBodyWithQuality(
+			r, numEntries, nonces, quality,
+		)
+	} else {
+		err = decodeLocalNoncesBody(r, numEntries, nonces)
+	}
+	reportLocalNoncesQuality(quality, err)
+	if err != nil {
+		releaseLocalNoncesBudget(charged)
+		return err
+	}
+
+	// The previous entries are replaced, so whatever they were charged
+	// is given back.
+	v.ReleaseBudget()
+
+	v.NoncesMap = nonces
+	v.sources = nil
+	v.roles = nil
+	v.createdAt = nil
+	v.charge = newLocalNoncesCharge(charged)
+
+	return nil
+}
+
+// Encode writes the set to w in a self-describing form, for instance to store
+// it in a file or database: a BigSize length prefix followed by the record
+// value. Unlike within a TLV stream, the length isn't known from the outside,
+// so Decode relies on the prefix to frame the record.
+func (lnd *LocalNoncesData) Encode(w io.Writer) error {
+	// Check the size before writing anything, so that w doesn't end up
+	// with a dangling length prefix.
+	if lnd.Len() > MaxLocalNonces {
+		return fmt.Errorf("%w: %d entries, at most %d allowed",
+			ErrTooManyLocalNonces, lnd.Len(), MaxLocalNonces)
+	}
+
+	var buf [8]byte
+	err := tlv.WriteVarInt(w, uint64(lnd.EncodedSize()), &buf)
+	if err != nil {
+		return err
+	}
+
+	return encodeLocalNoncesData(w, lnd, &buf)
+}
+
+// Decode reads a set written by Encode from r, replacing the contents of lnd.
+// The length prefix is validated against MaxLocalNoncesRecordBytes before
+// anything else is read. On failure, lnd is left untouched.
+func (lnd *LocalNoncesData) Decode(r io.Reader) error {
+	var buf [8]byte
+	recordLen, err := tlv.ReadVarInt(r, &buf)
+	if err != nil {
+		return err
+	}
+
+	if recordLen > MaxLocalNoncesRecordBytes {
+		return fmt.Errorf("%w: %d bytes, at most %d allowed",
+			ErrLocalNoncesRecordTooLarge, recordLen,
+			MaxLocalNoncesRecordBytes)
+	}
+
+	return decodeLocalNoncesData(r, lnd, &buf, recordLen)
+}
+
+// DecodeLocalNoncesInto decodes a record of recordLen bytes read from r into
+// the caller provided dst map, which must not be nil. Any entries dst holds
+// beforehand are removed, so that on success it holds exactly the entries of
+// the record. On failure, dst is left empty. Reusing a map across calls
+// avoids allocating a new one for every record in hot loops.
+func DecodeLocalNoncesInto(r io.Reader, recordLen uint64,
+	dst map[chainhash.Hash]Musig2Nonce) error {
+
+	clear(dst)
+
+	numEntries, err := readLocalNoncesHeader(r, recordLen)
+	if err != nil {
+		return err
+	}
+
+	if err := decodeLocalNoncesBody(r, numEntries, dst); err != nil {
+		clear(dst)
+		return err
+	}
+
+	return nil
+}
+
+// DecodeLocalNoncesRewind decodes a record of recordLen bytes read from r. If
+// decoding fails and r implements io.Seeker, r is rewound to the offset it
+// was at before the call, so that the malformed record can be read again, for
+// instance by a diagnostic tool.
+//
+// NOTE: A reader that doesn't implement io.Seeker is left wherever decoding
+// stopped, just like with any of the other decoders.
+func DecodeLocalNoncesRewind(r io.Reader,
+	recordLen uint64) (*LocalNoncesData, error) {
+
+	var (
+		seeker io.Seeker
+		start  int64
+	)
+	if s, ok := r.(io.Seeker); ok {
+		offset, err := s.Seek(0, io.SeekCurrent)
+		if err != nil {
+			return nil, err
+		}
+		seeker, start = s, offset
+	}
+
+	var (
+		lnd LocalNoncesData
+		buf [8]byte
+	)
+	err := decodeLocalNoncesData(r, &lnd, &buf, recordLen)
+	if err == nil {
+		return &lnd, nil
+	}
+
+	if seeker != nil {
+		_, seekErr := seeker.Seek(start, io.SeekStart)
+		if seekErr != nil {
+			return nil, fmt.Errorf("%w: unable to rewind: %w", err,
+				seekErr)
+		}
+	}
+
+	return nil, err
+}
+
+// DecodeLocalNoncesAsEntries decodes a record of recordLen bytes read from r
+// into a slice of entries in ascending txid order, without building a map.
+// This suits callers that only re-encode or walk the entries in order. The
+// same malformed records are rejected as by the map based decoder, including
+// ones with duplicate txids.
+func DecodeLocalNoncesAsEntries(r io.Reader,
+	recordLen uint64) ([]LocalNonceEntry, error) {
+
+	numEntries, err := readLocalNoncesHeader(r, recordLen)
+	if err != nil {
+		return nil, err
+	}
+
+	body := make([]byte, uint64(numEntries)*localNonceEntrySize)
+	if n, err := io.ReadFull(r, body); err != nil {
+		return nil, newLocalNoncesEntryError(uint64(n), err)
+	}
+
+	entries := make([]LocalNonceEntry, numEntries)
+	for i := range entries {
+		entry := body[i*localNonceEntrySize : (i+1)*localNonceEntrySize]
+		copy(entries[i].TXID[:], entry[:chainhash.HashSize])
+		copy(entries[i].Nonce[:], entry[chainhash.HashSize:])
+	}
+
+	// A conforming sender writes the entries in ascending txid order, in
+	// which case there's nothing left to sort.
+	if !slices.IsSortedFunc(entries, CompareLocalNonceEntries) {
+		slices.SortFunc(entries, CompareLocalNonceEntries)
+	}
+
+	// Once sorted, any duplicates end up next to each other.
+	for i := 1; i < len(entries); i++ {
+		if entries[i].TXID == entries[i-1].TXID {
+			return nil, fmt.Errorf("%w: %v",
+				ErrLocalNoncesDuplicateTxid, entries[i].TXID)
+		}
+	}
+
+	return entries, nil
+}
+
+// LocalNoncesFromSortedEntries builds a set from entries in strictly ascending
+// txid order, such as those returned by DecodeLocalNoncesAsEntries. The order
+// is checked while building the map, so an out of order entry results in
+// ErrLocalNoncesOutOfOrder, and a repeated txid in
+// ErrLocalNoncesDuplicateTxid.
+func LocalNoncesFromSortedEntries(
+	entries []LocalNonceEntry) (*LocalNoncesData, error) {
+
+	nonces := make(map[chainhash.Hash]Musig2Nonce, len(entries))
+	for i, entry := range entries {
+		if i > 0 {
+			prev := entries[i-1].TXID
+			switch c := bytes.Compare(prev[:], entry.TXID[:]); {
+			case c == 0:
+				return nil, fmt.Errorf("%w: %v",
+					ErrLocalNoncesDuplicateTxid, entry.TXID)
+
+			case c > 0:
+				return nil, fmt.Errorf("%w: txid %v doesn't "+
+					"follow %v", ErrLocalNoncesOutOfOrder,
+					entry.TXID, prev)
+			}
+		}
+
+		nonces[entry.TXID] = entry.Nonce
+	}
+
+	return &LocalNoncesData{NoncesMap: nonces}, nil
+}
+
+// DecodeStats describes a decoded LocalNoncesData record, for instance for
+// operators that monitor the behavior of their peers.
+type DecodeStats struct {
+	// NumEntries is the number of entries the record holds.
+	NumEntries int
+
+	// Canonical is true if the record was written by a conforming
+	// encoder, with its entries in strictly ascending txid order. A
+	// non-canonical record points at a buggy or misbehaving peer.
+	Canonical bool
+
+	// TotalBytes is the length of the record.
+	TotalBytes uint64
+
+	// CountFieldSize is the size of the entry count field in bytes, which
+	// is zero for a zero length record.
+	CountFieldSize int
+}
+
+// DecodeLocalNoncesWithStats decodes a record of recordLen bytes read from r,
+// just like the TLV decoder does, but also returns stats about the record.
+// The same malformed records are rejected, so the stats are only returned on
+// success.
+func DecodeLocalNoncesWithStats(r io.Reader,
+	recordLen uint64) (*LocalNoncesData, DecodeStats, error) {
+
+	numEntries, err := readLocalNoncesHeader(r, recordLen)
+	if err != nil {
+		return nil, DecodeStats{}, err
+	}
+
+	stats := DecodeStats{
+		NumEntries: int(numEntries),
+		Canonical:  true,
+		TotalBytes: recordLen,
+	}
+	if recordLen != 0 {
+		stats.CountFieldSize = localNoncesCountSize
+	}
+
+	body := make([]byte, uint64(numEntries)*localNonceEntrySize)
+	if n, err := io.ReadFull(r, body); err != nil {
+		return nil, DecodeStats{}, newLocalNoncesEntryError(
+			uint64(n), err,
+		)
+	}
+
+	nonces := make(map[chainhash.Hash]Musig2Nonce, numEntries)
+	err = decodeLocalNonceEntriesBulk(
+		bytes.NewReader(body), numEntries, nonces,
+	)
+	if err != nil {
+		return nil, DecodeStats{}, err
+	}
+
+	for i := 1; i < int(numEntries); i++ {
+		prev := body[(i-1)*localNonceEntrySize:][:chainhash.HashSize]
+		txid := body[i*localNonceEntrySize:][:chainhash.HashSize]
+		if bytes.Compare(prev, txid) > 0 {
+			stats.Canonical = false
+			break
+		}
+	}
+
+	return &LocalNoncesData{NoncesMap: nonces}, stats, nil
+}
+
+// decodeLocalNoncesBody reads the numEntries entries that follow the count of
+// a record from r, adding each of them to nonces.
+func decodeLocalNoncesBody(r io.Reader, numEntries uint16,
+	nonces map[chainhash.Hash]Musig2Nonce) error {
+
+	if numEntries == 0 {
+		return nil
+	}
+
+	// When the reader is an in-memory one such as a bytes.Reader or a
+	// bufio.Reader (both of which implement io.ByteReader), we read the
+	// entire body with a single call and slice the entries out of it.
+	// This avoids two small reads per entry, which makes decoding a
+	// bytes.Reader backed record roughly 30% faster with a fraction of
+	// the allocations (see BenchmarkDecodeLocalNonces).
+	if _, ok := r.(io.ByteReader); ok {
+		return decodeLocalNonceEntriesBulk(r, numEntries, nonces)
+	}
+
+	// Any other reader, such as a network connection, is buffered so that
+	// the per-entry reads don't each hit the underlying reader.
+	bodyLen := uint64(numEntries) * localNonceEntrySize
+
+	return decodeLocalNonceEntries(
+		newLocalNoncesReader(r, bodyLen), numEntries, nonces,
+	)
+}
+
+// newLocalNoncesReader wraps r in a buffered reader sized to the remaining
+// bodyLen bytes of a record, capped at MaxLocalNoncesRecordBytes. The wrapped
+// reader is limited to bodyLen bytes, so buffering never reads past the end
+// of the record into whatever follows it in the stream.
+func newLocalNoncesReader(r io.Reader, bodyLen uint64) *bufio.Reader {
+	size := min(bodyLen, MaxLocalNoncesRecordBytes)
+
+	return bufio.NewReaderSize(io.LimitReader(r, int64(bodyLen)), int(size))
+}
+
+// decodeLocalNonceEntries reads numEntries entries from r one at a time,
+// adding each of them to nonces.
+func decodeLocalNonceEntries(r io.Reader, numEntries uint16,
+	nonces map[chainhash.Hash]Musig2Nonce) error {
+
+	for i := uint16(0); i < numEntries; i++ {
+		var (
+			txid  chainhash.Hash
+			nonce Musig2Nonce
+		)
+		offset := uint64(i) * localNonceEntrySize
+
+		n, err := io.ReadFull(r, txid[:])
+		if err != nil {
+			return newLocalNoncesEntryError(offset+uint64(n), err)
+		}
+
+		n, err = io.ReadFull(r, nonce[:])
+		if err != nil {
+			return newLocalNoncesEntryError(
+				offset+chainhash.HashSize+uint64(n), err,
+			)
+		}
+
+		err = addDecodedLocalNonce(nonces, txid, nonce)
+		if err != nil {
+			return newLocalNoncesEntryError(offset, err)
+		}
+	}
+
+	return nil
+}
+
+// decodeLocalNonceEntriesBulk reads all numEntries entries from r with a
+// single read, then slices the individual entries out of the buffer, adding
+// each of them to nonces.
+func decodeLocalNonceEntriesBulk(r io.Reader, numEntries uint16,
+	nonces map[chainhash.Hash]Musig2Nonce) error {
+
+	body := make([]byte, uint64(numEntries)*localNonceEntrySize)
+	if n, err := io.ReadFull(r, body); err != nil {
+		return newLocalNoncesEntryError(uint64(n), err)
+	}
+
+	for offset := 0; offset < len(body); offset += localNonceEntrySize {
+		var (
+			txid  chainhash.Hash
+			nonce Musig2Nonce
+		)
+		entry := body[offset : offset+localNonceEntrySize]
+		copy(txid[:], entry[:chainhash.HashSize])
+		copy(nonce[:], entry[chainhash.HashSize:])
+
+		err := addDecodedLocalNonce(nonces, txid, nonce)
+		if err != nil {
+			return newLocalNoncesEntryError(uint64(offset), err)
+		}
+	}
+
+	return nil
+}
+
+// addDecodedLocalNonce adds a freshly decoded entry to nonces, rejecting a
+// txid that has already been seen within the same record.
+func addDecodedLocalNonce(nonces map[chainhash.Hash]Musig2Nonce,
+	txid chainhash.Hash, nonce Musig2Nonce) error {
+
+	if _, ok := nonces[txid]; ok {
+		return fmt.Errorf("%w: %v", ErrLocalNoncesDuplicateTxid, txid)
+	}
+	nonces[txid] = nonce
+
+	return nil
+}
@@ -0,0 +1,210 @@
+package lnwire
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+
+	"github.com/btcsuite/btcd/btcec/v2/schnorr/musig2"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+var (
+	// ErrLocalNonceNotInUniverse is returned when a LocalNoncesData is
+	// bitmap encoded against a universe that lacks one of its txids.
+	ErrLocalNonceNotInUniverse = errors.New("local nonce txid not in " +
+		"universe")
+
+	// ErrInvalidLocalNoncesUniverse is returned when the universe of a
+	// bitmap encoding contains the same txid more than once.
+	ErrInvalidLocalNoncesUniverse = errors.New("invalid local nonces " +
+		"universe")
+
+	// ErrInvalidLocalNoncesBitmap is returned when a bitmap encoding sets
+	// bits past the end of its universe.
+	ErrInvalidLocalNoncesBitmap = errors.New("invalid local nonces " +
+		"bitmap")
+)
+
+// localNoncesBitmapLen returns the size of the presence bitmap for a universe
+// of the given size.
+func localNoncesBitmapLen(universeSize int) int {
+	return (universeSize + 7) / 8
+}
+
+// EncodeBitmap writes the set to w as a presence bitmap over the given
+// ordered universe of txids, followed by the nonces of the present txids in
+// universe order. Bit i of the bitmap, counting from the most significant bit
+// of the first byte, is set if the set holds a nonce for universe[i]. As the
+// txids themselves are omitted, this is far more compact than the record
+// encoding for dense subsets of a universe both peers agree on. Every txid of
+// the set must be part of the universe, and a set of more than MaxLocalNonces
+// entries is rejected with ErrTooManyLocalNonces, as DecodeLocalNoncesBitmap
+// would never accept it.
+func (lnd *LocalNoncesData) EncodeBitmap(universe []chainhash.Hash,
+	w io.Writer) error {
+
+	if lnd.Len() > MaxLocalNonces {
+		return fmt.Errorf("%w: %d entries, max is %d",
+			ErrTooManyLocalNonces, lnd.Len(), MaxLocalNonces)
+	}
+
+	positions, err := localNoncesUniversePositions(universe)
+	if err != nil {
+		return err
+	}
+
+	bitmap := make([]byte, localNoncesBitmapLen(len(universe)))
+	for _, txid := range lnd.sortedTxids() {
+		pos, ok := positions[txid]
+		if !ok {
+			return fmt.Errorf("%w: %v", ErrLocalNonceNotInUniverse,
+				txid)
+		}
+
+		bitmap[pos/8] |= 0x80 >> (pos % 8)
+	}
+
+	if _, err := w.Write(bitmap); err != nil {
+		return err
+	}
+
+	for _, txid := range universe {
+		nonce, ok := lnd.Get(txid)
+		if !ok {
+			continue
+		}
+
+		if _, err := w.Write(nonce[:]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// newLocalNoncesBitmapError returns a LocalNoncesDecodeError for a problem
+// with the presence bitmap at the given offset within a bitmap encoded record.
+func newLocalNoncesBitmapError(offset uint64,
+	err error) *LocalNoncesDecodeError {
+
+	return &LocalNoncesDecodeError{
+		Offset: offset,
+		Reason: "bitmap",
+		Err:    err,
+	}
+}
+
+// readLocalNoncesBitmapHeader reads the presence bitmap of a bitmap encoded
+// record of recordLen bytes over a universe of universeSize txids, checking
+// that the record length matches the number of entries the bitmap claims to
+// hold, and that it doesn't claim more than MaxLocalNonces. Every error is a
+// LocalNoncesDecodeError.
+func readLocalNoncesBitmapHeader(r io.Reader, recordLen uint64,
+	universeSize int) ([]byte, error) {
+
+	bitmapLen := localNoncesBitmapLen(universeSize)
+	if recordLen < uint64(bitmapLen) {
+		return nil, newLocalNoncesBitmapError(0, fmt.Errorf("%w: %d "+
+			"bytes can't hold a %d byte bitmap",
+			ErrLocalNoncesRecordTooShort, recordLen, bitmapLen))
+	}
+
+	bitmap := make([]byte, bitmapLen)
+	if n, err := io.ReadFull(r, bitmap); err != nil {
+		return nil, newLocalNoncesBitmapError(uint64(n), err)
+	}
+
+	// Any padding bits in the last byte must be left unset.
+	if pad := bitmapLen*8 - universeSize; pad > 0 &&
+		bitmap[bitmapLen-1]&(1<<pad-1) != 0 {
+
+		return nil, newLocalNoncesBitmapError(
+			uint64(bitmapLen-1), fmt.Errorf("%w: bits set past "+
+				"universe of %d txids",
+				ErrInvalidLocalNoncesBitmap, universeSize),
+		)
+	}
+
+	var numEntries int
+	for _, b := range bitmap {
+		numEntries += bits.OnesCount8(b)
+	}
+
+	// Even if the record length matches, we don't accept more entries
+	// than a P2P record can hold.
+	if numEntries > MaxLocalNonces {
+		return nil, newLocalNoncesBitmapError(0, fmt.Errorf("%w: "+
+			"bitmap claims %d entries, max is %d",
+			ErrTooManyLocalNonces, numEntries, MaxLocalNonces))
+	}
+
+	expectedLen := uint64(bitmapLen) +
+		uint64(numEntries)*musig2.PubNonceSize
+	if recordLen != expectedLen {
+		return nil, newLocalNoncesBitmapError(0, fmt.Errorf("%w: "+
+			"expected %d bytes for %d entries, got %d",
+			ErrLocalNoncesLengthMismatch, expectedLen, numEntries,
+			recordLen))
+	}
+
+	return bitmap, nil
+}
+
+// DecodeLocalNoncesBitmap reads a set of recordLen bytes from r that was
+// written by EncodeBitmap using the same universe.
+func DecodeLocalNoncesBitmap(r io.Reader, recordLen uint64,
+	universe []chainhash.Hash) (*LocalNoncesData, error) {
+
+	if _, err := localNoncesUniversePositions(universe); err != nil {
+		return nil, err
+	}
+
+	bitmap, err := readLocalNoncesBitmapHeader(
+		r, recordLen, len(universe),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		nonces = make(map[chainhash.Hash]Musig2Nonce)
+		offset = uint64(len(bitmap))
+	)
+	for pos, txid := range universe {
+		if bitmap[pos/8]&(0x80>>(pos%8)) == 0 {
+			continue
+		}
+
+		var nonce Musig2Nonce
+		if n, err := io.ReadFull(r, nonce[:]); err != nil {
+			return nil, &LocalNoncesDecodeError{
+				Offset: offset + uint64(n),
+				Reason: fmt.Sprintf("entry %d", len(nonces)),
+				Err:    err,
+			}
+		}
+		nonces[txid] = nonce
+		offset += musig2.PubNonceSize
+	}
+
+	return &LocalNoncesData{NoncesMap: nonces}, nil
+}
+
+// localNoncesUniversePositions returns the position of every txid within the
+// universe, making sure that no txid is part of it more than once.
+func localNoncesUniversePositions(
+	universe []chainhash.Hash) (map[chainhash.Hash]int, error) {
+
+	positions := make(map[chainhash.Hash]int, len(universe))
+	for pos, txid := range universe {
+		if _, ok := positions[txid]; ok {
+			return nil, fmt.Errorf("%w: duplicate txid %v",
+				ErrInvalidLocalNoncesUniverse, txid)
+		}
+		positions[txid] = pos
+	}
+
+	return positions, nil
+}
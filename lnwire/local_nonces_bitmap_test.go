@@ -0,0 +1,191 @@
+package lnwire
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2/schnorr/musig2"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/stretchr/testify/require"
+)
+
+// makeTestUniverse returns a universe of n txids, in descending order so the
+// universe order differs from the txid order.
+func makeTestUniverse(n int) []chainhash.Hash {
+	universe := make([]chainhash.Hash, 0, n)
+	for i := n; i > 0; i-- {
+		universe = append(universe, makeTestTxId(byte(i)))
+	}
+
+	return universe
+}
+
+// TestLocalNoncesBitmapEncoding tests that sparse and dense subsets of a
+// universe can be round-tripped through the bitmap encoding.
+func TestLocalNoncesBitmapEncoding(t *testing.T) {
+	t.Parallel()
+
+	universe := makeTestUniverse(20)
+
+	testCases := []struct {
+		name  string
+		txids []byte
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name:  "sparse",
+			txids: []byte{2, 17},
+		},
+		{
+			name: "dense",
+			txids: []byte{
+				1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14,
+				15, 16, 18, 19, 20,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			nonces := &LocalNoncesData{
+				NoncesMap: make(map[chainhash.Hash]Musig2Nonce),
+			}
+			for _, b := range tc.txids {
+				nonces.NoncesMap[makeTestTxId(b)] =
+					makeTestNonce(b + 100)
+			}
+
+			var w bytes.Buffer
+			require.NoError(t, nonces.EncodeBitmap(universe, &w))
+			require.Len(
+				t, w.Bytes(),
+				3+len(tc.txids)*musig2.PubNonceSize,
+			)
+
+			decoded, err := DecodeLocalNoncesBitmap(
+				bytes.NewReader(w.Bytes()), uint64(w.Len()),
+				universe,
+			)
+			require.NoError(t, err)
+			require.Equal(t, nonces.NoncesMap, decoded.NoncesMap)
+		})
+	}
+
+	// The bits follow the universe order: txid 20 is the first entry of
+	// the universe, and txid 1 the last.
+	nonces := SingleLocalNonce(makeTestTxId(20), makeTestNonce(1))
+	var w bytes.Buffer
+	require.NoError(t, nonces.EncodeBitmap(universe, &w))
+	require.Equal(t, []byte{0x80, 0x00, 0x00}, w.Bytes()[:3])
+
+	nonces = SingleLocalNonce(makeTestTxId(1), makeTestNonce(1))
+	w.Reset()
+	require.NoError(t, nonces.EncodeBitmap(universe, &w))
+	require.Equal(t, []byte{0x00, 0x00, 0x10}, w.Bytes()[:3])
+}
+
+// TestLocalNoncesBitmapFailures tests that sets and records that don't match
+// the universe are rejected.
+func TestLocalNoncesBitmapFailures(t *testing.T) {
+	t.Parallel()
+
+	universe := makeTestUniverse(4)
+
+	// A txid outside of the universe can't be encoded.
+	nonces := makeTestLocalNonces(5)
+	var w bytes.Buffer
+	err := nonces.EncodeBitmap(universe, &w)
+	require.ErrorIs(t, err, ErrLocalNonceNotInUniverse)
+
+	// Neither can a universe with duplicates be used.
+	dupUniverse := append(makeTestUniverse(2), makeTestTxId(1))
+	err = makeTestLocalNonces(1).EncodeBitmap(dupUniverse, &w)
+	require.ErrorIs(t, err, ErrInvalidLocalNoncesUniverse)
+
+	_, err = DecodeLocalNoncesBitmap(
+		bytes.NewReader([]byte{0x00}), 1, dupUniverse,
+	)
+	require.ErrorIs(t, err, ErrInvalidLocalNoncesUniverse)
+
+	// A bit past the end of the universe is rejected.
+	padded := append([]byte{0x08}, make([]byte, musig2.PubNonceSize)...)
+	_, err = DecodeLocalNoncesBitmap(
+		bytes.NewReader(padded), uint64(len(padded)), universe,
+	)
+	require.ErrorIs(t, err, ErrInvalidLocalNoncesBitmap)
+
+	// As is a record whose length doesn't match the bitmap.
+	w.Reset()
+	require.NoError(t, makeTestLocalNonces(2).EncodeBitmap(universe, &w))
+	_, err = DecodeLocalNoncesBitmap(
+		bytes.NewReader(w.Bytes()), uint64(w.Len())-1, universe,
+	)
+	require.ErrorIs(t, err, ErrLocalNoncesLengthMismatch)
+
+	_, err = DecodeLocalNoncesBitmap(bytes.NewReader(nil), 0, universe)
+	require.ErrorIs(t, err, ErrLocalNoncesRecordTooShort)
+}
+
+// TestLocalNoncesBitmapHeaderChecks tests that more than MaxLocalNonces
+// entries are rejected on both encode and decode, and that a truncated record
+// reports the offset of the first missing byte.
+func TestLocalNoncesBitmapHeaderChecks(t *testing.T) {
+	t.Parallel()
+
+	universe := make([]chainhash.Hash, MaxLocalNonces+1)
+	for i := range universe {
+		binary.BigEndian.PutUint16(universe[i][:], uint16(i))
+	}
+
+	// A set that holds every txid of a universe that's larger than the
+	// max can't be encoded, and nothing is written.
+	nonces := &LocalNoncesData{
+		NoncesMap: make(map[chainhash.Hash]Musig2Nonce, len(universe)),
+	}
+	for _, txid := range universe {
+		nonces.NoncesMap[txid] = makeTestNonce(1)
+	}
+
+	var w bytes.Buffer
+	err := nonces.EncodeBitmap(universe, &w)
+	require.ErrorIs(t, err, ErrTooManyLocalNonces)
+	require.Zero(t, w.Len())
+
+	// Neither is a full bitmap over that universe decoded, even if the
+	// record is long enough to hold every nonce.
+	bitmapLen := localNoncesBitmapLen(len(universe))
+
+	tooMany := make([]byte, bitmapLen+len(universe)*musig2.PubNonceSize)
+	for i := range len(universe) {
+		tooMany[i/8] |= 0x80 >> (i % 8)
+	}
+
+	_, err = DecodeLocalNoncesBitmap(
+		bytes.NewReader(tooMany), uint64(len(tooMany)), universe,
+	)
+	require.ErrorIs(t, err, ErrTooManyLocalNonces)
+
+	var decodeErr *LocalNoncesDecodeError
+	require.ErrorAs(t, err, &decodeErr)
+	require.Equal(t, "bitmap", decodeErr.Reason)
+
+	// A record that's cut short within its last nonce points at the
+	// first missing byte.
+	w.Reset()
+	universe = makeTestUniverse(4)
+	require.NoError(t, makeTestLocalNonces(2).EncodeBitmap(universe, &w))
+	truncated := w.Bytes()[:w.Len()-1]
+
+	_, err = DecodeLocalNoncesBitmap(
+		bytes.NewReader(truncated), uint64(w.Len()), universe,
+	)
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	require.ErrorAs(t, err, &decodeErr)
+	require.EqualValues(t, len(truncated), decodeErr.Offset)
+}
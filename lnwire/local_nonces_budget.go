@@ -0,0 +1,118 @@
+package lnwire
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+// ErrLocalNoncesBudgetExceeded is returned when decoding a record would push
+// the number of charged entries held in memory beyond the budget set with
+// SetLocalNoncesBudget.
+var ErrLocalNoncesBudgetExceeded = errors.New("local nonces budget exceeded")
+
+var (
+	// localNoncesBudget is the maximum number of entries decoded by the
+	// TLV record decoder that may be held in memory at any time. Zero
+	// means there's no limit.
+	localNoncesBudget atomic.Uint64
+
+	// localNoncesInFlight is the number of decoded entries currently
+	// charged against localNoncesBudget.
+	localNoncesInFlight atomic.Uint64
+)
+
+// SetLocalNoncesBudget sets the maximum number of entries that may be held in
+// memory at any time across all sets decoded by the TLV record decoder, which
+// keeps a swarm of peers that each send a maximum sized record from
+// exhausting our memory. A budget of zero, which is the default, means there's
+// no limit. Only sets decoded while a budget is in place are charged against
+// it, and they must be handed to ReleaseBudget once they're discarded.
+//
+// NOTE: Records received from peers are decoded by the TLV record decoder,
+// which backs Record, Decode, DecodeGzip, ParseLocalNoncesRecord,
+// ParseLocalNoncesPrefix, LocalNoncesFromHex, DecodeLocalNoncesRewind and the
+// fixed encoding of DecodeLocalNoncesWith and DecodeLocalNoncesAdaptive. No
+// other decoder, such as DecodeLocalNoncesFlat, DecodeLocalNoncesWithStats,
+// the alternative encodings or the cursor, is charged against the budget, so
+// they must only be used for records that are trusted or bounded otherwise.
+func SetLocalNoncesBudget(maxEntries uint64) {
+	localNoncesBudget.Store(maxEntries)
+}
+
+// chargeLocalNoncesBudget reserves room for numEntries decoded entries,
+// returning the number of entries that were actually charged, which is zero
+// if there's no budget in place.
+func chargeLocalNoncesBudget(numEntries uint64) (uint64, error) {
+	for {
+		budget := localNoncesBudget.Load()
+		if budget == 0 {
+			return 0, nil
+		}
+
+		inFlight := localNoncesInFlight.Load()
+		if inFlight+numEntries > budget {
+			return 0, fmt.Errorf("%w: %d entries in flight, %d "+
+				"more requested, budget is %d",
+				ErrLocalNoncesBudgetExceeded, inFlight,
+				numEntries, budget)
+		}
+
+		if localNoncesInFlight.CompareAndSwap(
+			inFlight, inFlight+numEntries,
+		) {
+
+			return numEntries, nil
+		}
+	}
+}
+
+// releaseLocalNoncesBudget gives back the room reserved for numEntries
+// decoded entries.
+func releaseLocalNoncesBudget(numEntries uint64) {
+	if numEntries != 0 {
+		localNoncesInFlight.Add(^(numEntries - 1))
+	}
+}
+
+// localNoncesCharge is the room a decoded set was charged against the budget.
+// Copies of a set, such as the one wrapped by OptLocalNonces, share the same
+// charge, so that releasing more than one of them doesn't give back more room
+// than was charged.
+type localNoncesCharge struct {
+	numEntries atomic.Uint64
+}
+
+// newLocalNoncesCharge returns the charge for numEntries entries, or nil if
+// nothing was charged.
+func newLocalNoncesCharge(numEntries uint64) *localNoncesCharge {
+	if numEntries == 0 {
+		return nil
+	}
+
+	c := &localNoncesCharge{}
+	c.numEntries.Store(numEntries)
+
+	return c
+}
+
+// release gives back the room of the charge, unless that already happened.
+func (c *localNoncesCharge) release() {
+	if c != nil {
+		releaseLocalNoncesBudget(c.numEntries.Swap(0))
+	}
+}
+
+// ReleaseBudget gives back the room the entries of the set were charged
+// against the budget set with SetLocalNoncesBudget when it was decoded. It
+// must be called once the set is discarded, and is a no-op for sets that
+// weren't charged, or were already released. As all copies of a set share its
+// charge, releasing any number of them gives back the room exactly once.
+func (lnd *LocalNoncesData) ReleaseBudget() {
+	if lnd == nil {
+		return
+	}
+
+	lnd.charge.release()
+	lnd.charge = nil
+}
@@ -0,0 +1,146 @@
+package lnwire
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+
+	"github.com/lightningnetwork/lnd/tlv"
+	"github.com/stretchr/testify/require"
+)
+
+// TestLocalNoncesBudget tests that concurrent decodes beyond the global budget
+// are rejected until earlier sets are released.
+//
+// NOTE: This test must not run in parallel, as the budget is global.
+func TestLocalNoncesBudget(t *testing.T) {
+	const (
+		numSets       = 4
+		entriesPerSet = 3
+	)
+
+	SetLocalNoncesBudget(numSets * entriesPerSet)
+	t.Cleanup(func() {
+		SetLocalNoncesBudget(0)
+	})
+
+	nonces := makeTestLocalNonces(entriesPerSet)
+	encoded := encodeTestLocalNonces(t, nonces)
+
+	var standalone bytes.Buffer
+	require.NoError(t, nonces.Encode(&standalone))
+
+	// Decode one more set than fits into the budget, all at once.
+	var (
+		wg   sync.WaitGroup
+		sets = make([]LocalNoncesData, numSets+1)
+		errs = make([]error, numSets+1)
+	)
+	for i := range sets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			r := bytes.NewReader(standalone.Bytes())
+			errs[i] = sets[i].Decode(r)
+		}()
+	}
+	wg.Wait()
+
+	var (
+		rejected int
+		accepted *LocalNoncesData
+	)
+	for i, err := range errs {
+		if err == nil {
+			require.Equal(t, entriesPerSet, sets[i].Len())
+			accepted = &sets[i]
+
+			continue
+		}
+
+		require.ErrorIs(t, err, ErrLocalNoncesBudgetExceeded)
+		rejected++
+	}
+	require.Equal(t, 1, rejected)
+	require.EqualValues(
+		t, numSets*entriesPerSet, localNoncesInFlight.Load(),
+	)
+
+	// Once a set is released, there's room for another one. Releasing a
+	// set twice doesn't free up more room than it was charged.
+	accepted.ReleaseBudget()
+	accepted.ReleaseBudget()
+
+	var (
+		first, second LocalNoncesData
+		buf           [8]byte
+	)
+	err := decodeLocalNoncesData(
+		bytes.NewReader(encoded), &first, &buf, uint64(len(encoded)),
+	)
+	require.NoError(t, err)
+
+	err = decodeLocalNoncesData(
+		bytes.NewReader(encoded), &second, &buf, uint64(len(encoded)),
+	)
+	require.ErrorIs(t, err, ErrLocalNoncesBudgetExceeded)
+
+	// Releasing everything brings the count back to zero.
+	first.ReleaseBudget()
+	for i := range sets {
+		sets[i].ReleaseBudget()
+	}
+	require.Zero(t, localNoncesInFlight.Load())
+}
+
+// TestLocalNoncesBudgetReleaseCopies tests that releasing several copies of a
+// decoded set only gives back the room it was charged once.
+//
+// NOTE: This test must not run in parallel, as the budget is global.
+func TestLocalNoncesBudgetReleaseCopies(t *testing.T) {
+	const entriesPerSet = 3
+
+	SetLocalNoncesBudget(2 * entriesPerSet)
+	t.Cleanup(func() {
+		SetLocalNoncesBudget(0)
+	})
+
+	nonces := makeTestLocalNonces(entriesPerSet)
+	encoded := encodeTestLocalNonces(t, nonces)
+
+	var (
+		first, second LocalNoncesData
+		buf           [8]byte
+	)
+	decode := func(lnd *LocalNoncesData) error {
+		return decodeLocalNoncesData(
+			bytes.NewReader(encoded), lnd, &buf,
+			uint64(len(encoded)),
+		)
+	}
+	require.NoError(t, decode(&first))
+	require.NoError(t, decode(&second))
+
+	// Release the set along with a plain copy of it and the copy wrapped
+	// by an optional record.
+	copied := first
+	opt := SomeLocalNonces(first)
+	first.ReleaseBudget()
+	copied.ReleaseBudget()
+	opt.WhenSome(func(r tlv.RecordT[LocalNoncesRecordTypeT,
+		LocalNoncesData]) {
+
+		r.Val.ReleaseBudget()
+	})
+	require.EqualValues(t, entriesPerSet, localNoncesInFlight.Load())
+
+	// The room of the first set is available again, but no more.
+	var third, fourth LocalNoncesData
+	require.NoError(t, decode(&third))
+	require.ErrorIs(t, decode(&fourth), ErrLocalNoncesBudgetExceeded)
+
+	second.ReleaseBudget()
+	third.ReleaseBudget()
+	require.Zero(t, localNoncesInFlight.Load())
+}
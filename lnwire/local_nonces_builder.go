@@ -0,0 +1,77 @@
+package lnwire
+
+import (
+	"encoding/binary"
+	"fmt"
+	"slices"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+// LocalNoncesBuilder collects the entries of a LocalNoncesData record in any
+// order, and only produces the canonical encoding once Build is called. Unlike
+// LocalNoncesEncoder, which requires entries in ascending txid order, this
+// suits callers that can't guarantee any particular order, at the cost of
+// holding all entries in memory.
+type LocalNoncesBuilder struct {
+	entries []LocalNonceEntry
+}
+
+// NewLocalNoncesBuilder returns an empty builder with room for sizeHint
+// entries.
+func NewLocalNoncesBuilder(sizeHint int) *LocalNoncesBuilder {
+	return &LocalNoncesBuilder{
+		entries: make([]LocalNonceEntry, 0, sizeHint),
+	}
+}
+
+// Add adds an entry to the record. Adding the same entry more than once is
+// harmless, while adding different nonces for the same txid makes Build fail.
+func (b *LocalNoncesBuilder) Add(txid chainhash.Hash, nonce Musig2Nonce) {
+	b.entries = append(b.entries, LocalNonceEntry{
+		TXID:  txid,
+		Nonce: nonce,
+	})
+}
+
+// Build returns the record encoding of the entries added so far, sorted in
+// ascending txid order. An error is returned if the same txid was added with
+// different nonces, or if there are more entries than fit into a record. The
+// builder can still be used afterwards.
+func (b *LocalNoncesBuilder) Build() ([]byte, error) {
+	// Sorting by txid, then nonce, puts any repeated txids next to each
+	// other.
+	entries := slices.Clone(b.entries)
+	slices.SortFunc(entries, CompareLocalNonceEntries)
+
+	unique := entries[:0]
+	for _, entry := range entries {
+		n := len(unique)
+		if n > 0 && unique[n-1].TXID == entry.TXID {
+			if unique[n-1].Nonce != entry.Nonce {
+				return nil, fmt.Errorf("%w: txid %v",
+					ErrLocalNonceConflict, entry.TXID)
+			}
+
+			continue
+		}
+
+		unique = append(unique, entry)
+	}
+
+	if len(unique) > MaxLocalNonces {
+		return nil, fmt.Errorf("%w: %d entries, at most %d allowed",
+			ErrTooManyLocalNonces, len(unique), MaxLocalNonces)
+	}
+
+	encoded := make(
+		[]byte, 0, localNoncesCountSize+len(unique)*localNonceEntrySize,
+	)
+	encoded = binary.BigEndian.AppendUint16(encoded, uint16(len(unique)))
+	for _, entry := range unique {
+		encoded = append(encoded, entry.TXID[:]...)
+		encoded = append(encoded, entry.Nonce[:]...)
+	}
+
+	return encoded, nil
+}
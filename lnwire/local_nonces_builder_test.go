@@ -0,0 +1,55 @@
+package lnwire
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestLocalNoncesBuilder tests that entries added in any order result in the
+// canonical encoding, with repeated entries only encoded once.
+func TestLocalNoncesBuilder(t *testing.T) {
+	t.Parallel()
+
+	r := rand.New(rand.NewSource(6))
+	nonces := makeRandomLocalNonces(t, r, 50)
+
+	entries := nonces.SortedEntries()
+	r.Shuffle(len(entries), func(i, j int) {
+		entries[i], entries[j] = entries[j], entries[i]
+	})
+
+	b := NewLocalNoncesBuilder(len(entries))
+	for _, entry := range entries {
+		b.Add(entry.TXID, entry.Nonce)
+	}
+
+	// Repeating an entry is harmless.
+	b.Add(entries[0].TXID, entries[0].Nonce)
+
+	encoded, err := b.Build()
+	require.NoError(t, err)
+	require.Equal(t, encodeTestLocalNonces(t, nonces), encoded)
+
+	// An empty builder results in the empty record.
+	encoded, err = NewLocalNoncesBuilder(0).Build()
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x00, 0x00}, encoded)
+}
+
+// TestLocalNoncesBuilderConflict tests that adding different nonces for the
+// same txid makes Build fail, no matter the order they're added in.
+func TestLocalNoncesBuilderConflict(t *testing.T) {
+	t.Parallel()
+
+	b := NewLocalNoncesBuilder(0)
+	b.Add(makeTestTxId(2), makeTestNonce(2))
+	b.Add(makeTestTxId(1), makeTestNonce(9))
+	b.Add(makeTestTxId(3), makeTestNonce(3))
+	b.Add(makeTestTxId(1), makeTestNonce(1))
+
+	_, err := b.Build()
+	require.ErrorIs(t, err, ErrLocalNonceConflict)
+	require.ErrorContains(t, err, makeTestTxId(1).String())
+}
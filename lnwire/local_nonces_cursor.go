@@ -0,0 +1,137 @@
+package lnwire
+
+import (
+	"io"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/lightningnetwork/lnd/fn/v2"
+)
+
+// LocalNoncesCursor decodes the entries of a LocalNoncesData record lazily,
+// reading a single entry from the underlying reader on each call to Next.
+// Only the current entry is held in memory, which makes the cursor suitable
+// for very large records. Entries are yielded in wire order and, as no state
+// is kept across entries, duplicate txids aren't detected.
+//
+// A typical iteration looks like:
+//
+//	for cursor.Next() {
+//		entry := cursor.Entry()
+//		...
+//	}
+//	if err := cursor.Err(); err != nil {
+//		...
+//	}
+type LocalNoncesCursor struct {
+	r         io.Reader
+	remaining uint16
+	entry     LocalNonceEntry
+	err       error
+	closed    bool
+}
+
+// NewLocalNoncesCursor returns a cursor over the entries of a record of
+// recordLen bytes read from r. The entry count is read and checked against
+// recordLen up front, while the entries themselves are only read as the
+// cursor advances.
+func NewLocalNoncesCursor(r io.Reader,
+	recordLen uint64) (*LocalNoncesCursor, error) {
+
+	numEntries, err := readLocalNoncesHeader(r, recordLen)
+	if err != nil {
+		return nil, err
+	}
+
+	return &LocalNoncesCursor{
+		r:         r,
+		remaining: numEntries,
+	}, nil
+}
+
+// Len returns the number of entries that haven't been read yet.
+func (c *LocalNoncesCursor) Len() int {
+	return int(c.remaining)
+}
+
+// Next advances the cursor to the next entry, returning false once all
+// entries have been read, the cursor was closed, or an error occurred.
+func (c *LocalNoncesCursor) Next() bool {
+	if c.closed || c.err != nil || c.remaining == 0 {
+		return false
+	}
+
+	var entry LocalNonceEntry
+	if _, err := io.ReadFull(c.r, entry.TXID[:]); err != nil {
+		c.err = err
+		return false
+	}
+	if _, err := io.ReadFull(c.r, entry.Nonce[:]); err != nil {
+		c.err = err
+		return false
+	}
+
+	c.entry = entry
+	c.remaining--
+
+	return true
+}
+
+// Entry returns the entry the cursor currently points at. It must only be
+// called after Next returned true.
+func (c *LocalNoncesCursor) Entry() LocalNonceEntry {
+	return c.entry
+}
+
+// Err returns the error, if any, that stopped the iteration.
+func (c *LocalNoncesCursor) Err() error {
+	return c.err
+}
+
+// Close ends the iteration early. The entries that haven't been read yet are
+// discarded, so that the underlying reader is left positioned right after the
+// record, ready for whatever follows it.
+func (c *LocalNoncesCursor) Close() error {
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+
+	// If reading already failed, the position within the record is
+	// unknown, so there's nothing sensible left to discard.
+	if c.err != nil || c.remaining == 0 {
+		return nil
+	}
+
+	remainingLen := int64(c.remaining) * localNonceEntrySize
+	c.remaining = 0
+	if _, err := io.CopyN(io.Discard, c.r, remainingLen); err != nil {
+		c.err = err
+		return err
+	}
+
+	return nil
+}
+
+// LocalNonceAt looks up the nonce for the given txid directly in the encoded
+// record, without decoding it. As entries are of a fixed size and written in
+// ascending txid order, entry i starts right after the count at offset
+// i*localNonceEntrySize of the body, which allows for a binary search over the
+// txids in O(log n). None is returned if the record doesn't hold the txid.
+//
+// NOTE: Only the framing of the record is checked. The search relies on the
+// record being canonical, see IsCanonicalLocalNonces, and may miss entries of
+// a record that isn't.
+func LocalNonceAt(encoded []byte,
+	txid chainhash.Hash) (fn.Option[Musig2Nonce], error) {
+
+	_, i, found, err := searchEncodedLocalNonces(encoded, txid)
+	if err != nil || !found {
+		return fn.None[Musig2Nonce](), err
+	}
+
+	var nonce Musig2Nonce
+	offset := encodedLocalNonceOffset(i) + chainhash.HashSize
+	copy(nonce[:], encoded[offset:])
+
+	return fn.Some(nonce), nil
+}
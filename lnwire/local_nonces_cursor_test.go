@@ -0,0 +1,153 @@
+package lnwire
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/lightningnetwork/lnd/fn/v2"
+	"github.com/stretchr/testify/require"
+)
+
+// TestLocalNoncesCursor tests that iterating a record with a cursor yields the
+// same entries as the map decoder, in wire order.
+func TestLocalNoncesCursor(t *testing.T) {
+	t.Parallel()
+
+	nonces := makeTestLocalNonces(10)
+	encoded := encodeTestLocalNonces(t, nonces)
+
+	cursor, err := NewLocalNoncesCursor(
+		bytes.NewReader(encoded), uint64(len(encoded)),
+	)
+	require.NoError(t, err)
+	require.Equal(t, 10, cursor.Len())
+
+	var txids []chainhash.Hash
+	decoded := make(map[chainhash.Hash]Musig2Nonce)
+	for cursor.Next() {
+		entry := cursor.Entry()
+		txids = append(txids, entry.TXID)
+		decoded[entry.TXID] = entry.Nonce
+	}
+	require.NoError(t, cursor.Err())
+	require.Zero(t, cursor.Len())
+
+	require.Equal(t, nonces.NoncesMap, decoded)
+	require.Equal(t, nonces.sortedTxids(), txids)
+
+	// An empty record yields no entries.
+	cursor, err = NewLocalNoncesCursor(bytes.NewReader(nil), 0)
+	require.NoError(t, err)
+	require.False(t, cursor.Next())
+	require.NoError(t, cursor.Err())
+}
+
+// TestLocalNoncesCursorEarlyClose tests that closing a cursor mid-iteration
+// leaves the reader positioned right after the record.
+func TestLocalNoncesCursorEarlyClose(t *testing.T) {
+	t.Parallel()
+
+	encoded := encodeTestLocalNonces(t, makeTestLocalNonces(10))
+	trailer := []byte{0x01, 0x02, 0x03}
+	r := bytes.NewReader(append(bytes.Clone(encoded), trailer...))
+
+	cursor, err := NewLocalNoncesCursor(r, uint64(len(encoded)))
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		require.True(t, cursor.Next())
+	}
+	require.Equal(t, makeTestTxId(3), cursor.Entry().TXID)
+
+	require.NoError(t, cursor.Close())
+	require.False(t, cursor.Next())
+	require.NoError(t, cursor.Err())
+	require.NoError(t, cursor.Close())
+
+	rest, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, trailer, rest)
+}
+
+// TestLocalNoncesCursorFailures tests that malformed records are reported by
+// the cursor.
+func TestLocalNoncesCursorFailures(t *testing.T) {
+	t.Parallel()
+
+	encoded := encodeTestLocalNonces(t, makeTestLocalNonces(2))
+
+	// A mismatched length is caught before any entry is read.
+	_, err := NewLocalNoncesCursor(
+		bytes.NewReader(encoded), uint64(len(encoded))+1,
+	)
+	require.ErrorIs(t, err, ErrLocalNoncesLengthMismatch)
+
+	// A truncated body is caught up front if the reader knows its length.
+	_, err = NewLocalNoncesCursor(
+		bytes.NewReader(encoded[:len(encoded)-1]),
+		uint64(len(encoded)),
+	)
+	require.ErrorIs(t, err, ErrLocalNoncesRecordTruncated)
+
+	// Otherwise, it surfaces through Err.
+	cursor, err := NewLocalNoncesCursor(
+		plainReader{bytes.NewReader(encoded[:len(encoded)-1])},
+		uint64(len(encoded)),
+	)
+	require.NoError(t, err)
+	require.True(t, cursor.Next())
+	require.False(t, cursor.Next())
+	require.ErrorIs(t, cursor.Err(), io.ErrUnexpectedEOF)
+}
+
+// TestLocalNonceAt tests that looking up a txid in an encoded record finds the
+// nonces of all entries it holds, including the first and last, and none for
+// txids it doesn't hold.
+func TestLocalNonceAt(t *testing.T) {
+	t.Parallel()
+
+	// The txids 2, 4, ..., 20 leave gaps before, between and after the
+	// entries.
+	nonces := &LocalNoncesData{
+		NoncesMap: make(map[chainhash.Hash]Musig2Nonce),
+	}
+	for i := byte(1); i <= 10; i++ {
+		nonces.NoncesMap[makeTestTxId(2*i)] = makeTestNonce(i)
+	}
+	encoded := encodeTestLocalNonces(t, nonces)
+
+	for txid, nonce := range nonces.NoncesMap {
+		found, err := LocalNonceAt(encoded, txid)
+		require.NoError(t, err)
+		require.Equal(t, fn.Some(nonce), found)
+	}
+
+	entries := nonces.SortedEntries()
+	for _, entry := range []LocalNonceEntry{entries[0], entries[9]} {
+		found, err := LocalNonceAt(encoded, entry.TXID)
+		require.NoError(t, err)
+		require.Equal(t, fn.Some(entry.Nonce), found)
+	}
+
+	for _, txid := range []chainhash.Hash{
+		makeTestTxId(0), makeTestTxId(1), makeTestTxId(7),
+		makeTestTxId(21), makeTestTxId(0xff),
+	} {
+		found, err := LocalNonceAt(encoded, txid)
+		require.NoError(t, err)
+		require.True(t, found.IsNone())
+	}
+
+	// Empty records hold no entries.
+	for _, empty := range [][]byte{nil, {0x00, 0x00}} {
+		found, err := LocalNonceAt(empty, makeTestTxId(2))
+		require.NoError(t, err)
+		require.True(t, found.IsNone())
+	}
+
+	// A record that isn't framed correctly is rejected.
+	_, err := LocalNonceAt(encoded[:len(encoded)-1], makeTestTxId(2))
+	require.ErrorIs(t, err, ErrLocalNoncesLengthMismatch)
+}
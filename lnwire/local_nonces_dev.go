@@ -0,0 +1,68 @@
+//go:build dev
+
+package lnwire
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+// MustParseLocalNonces builds a LocalNoncesData from a compact spec of comma
+// separated entries, each made up of a txid seed byte and a nonce seed byte in
+// hex separated by a colon, such as "01:aa,02:bb". The txid and nonce of an
+// entry have every byte set to their seed. An empty spec results in an empty
+// set.
+//
+// NOTE: THIS IS INTENDED FOR TESTS ONLY, which is why it's only available in
+// dev builds. MustParseLocalNonces panics if the spec is malformed or names a
+// txid more than once.
+func MustParseLocalNonces(spec string) *LocalNoncesData {
+	lnd := &LocalNoncesData{
+		NoncesMap: make(map[chainhash.Hash]Musig2Nonce),
+	}
+	if spec == "" {
+		return lnd
+	}
+
+	for _, entry := range strings.Split(spec, ",") {
+		txidSeed, nonceSeed, ok := strings.Cut(entry, ":")
+		if !ok {
+			panic(fmt.Sprintf("invalid local nonces spec entry %q",
+				entry))
+		}
+
+		var (
+			txid  chainhash.Hash
+			nonce Musig2Nonce
+		)
+		copy(txid[:], bytes.Repeat(
+			mustParseLocalNonceSeed(txidSeed), chainhash.HashSize,
+		))
+		copy(nonce[:], bytes.Repeat(
+			mustParseLocalNonceSeed(nonceSeed), len(nonce),
+		))
+
+		if _, ok := lnd.NoncesMap[txid]; ok {
+			panic(fmt.Sprintf("duplicate txid seed %q in local "+
+				"nonces spec", txidSeed))
+		}
+		lnd.NoncesMap[txid] = nonce
+	}
+
+	return lnd
+}
+
+// mustParseLocalNonceSeed parses a single hex encoded seed byte of a
+// MustParseLocalNonces spec, panicking if it's malformed.
+func mustParseLocalNonceSeed(seed string) []byte {
+	b, err := hex.DecodeString(strings.TrimSpace(seed))
+	if err != nil || len(b) != 1 {
+		panic(fmt.Sprintf("invalid local nonces spec seed %q", seed))
+	}
+
+	return b
+}
@@ -0,0 +1,36 @@
+//go:build dev
+
+package lnwire
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/stretchr/testify/require"
+)
+
+// TestMustParseLocalNonces tests that MustParseLocalNonces builds the set
+// described by a spec, and panics on malformed ones.
+func TestMustParseLocalNonces(t *testing.T) {
+	t.Parallel()
+
+	nonces := MustParseLocalNonces("01:aa, 02:bb,ff:00")
+	require.Equal(t, map[chainhash.Hash]Musig2Nonce{
+		makeTestTxId(0x01): makeTestNonce(0xaa),
+		makeTestTxId(0x02): makeTestNonce(0xbb),
+		makeTestTxId(0xff): makeTestNonce(0x00),
+	}, nonces.NoncesMap)
+
+	require.Equal(t, &LocalNoncesData{
+		NoncesMap: map[chainhash.Hash]Musig2Nonce{},
+	}, MustParseLocalNonces(""))
+
+	malformed := []string{
+		"01", "01:", ":aa", "0g:aa", "0100:aa", "01:aa,", "01:aa,01:bb",
+	}
+	for _, spec := range malformed {
+		require.Panics(t, func() {
+			MustParseLocalNonces(spec)
+		}, spec)
+	}
+}
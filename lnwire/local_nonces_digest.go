@@ -0,0 +1,219 @@
+package lnwire
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+const (
+	// localNoncesFingerprintSize is the number of digest bytes that make
+	// up the fingerprint of a LocalNoncesData.
+	localNoncesFingerprintSize = 8
+
+	// localNonceMerkleLeafTag prefixes the preimage of every leaf of the
+	// Merkle tree over a LocalNoncesData, so a leaf can never be passed
+	// off as an inner node or vice versa.
+	localNonceMerkleLeafTag = 0x00
+
+	// localNonceMerkleNodeTag prefixes the preimage of every inner node of
+	// the Merkle tree over a LocalNoncesData.
+	localNonceMerkleNodeTag = 0x01
+)
+
+var (
+	// ErrLocalNoncesDigestMismatch is returned when an encoded
+	// LocalNoncesData record doesn't hash to the digest it's expected to.
+	ErrLocalNoncesDigestMismatch = errors.New("local nonces digest " +
+		"mismatch")
+
+	// ErrLocalNonceNotFound is returned when an entry is requested for a
+	// txid that isn't part of a LocalNoncesData.
+	ErrLocalNonceNotFound = errors.New("local nonce not found")
+
+	// ErrInvalidLocalNonceMerkleProof is returned when a Merkle proof
+	// doesn't tie an entry to the expected root.
+	ErrInvalidLocalNonceMerkleProof = errors.New("invalid local nonce " +
+		"merkle proof")
+)
+
+// Digest returns the sha256 digest of the record encoding of the set. As the
+// encoding is canonical, two sets with the same entries always share the same
+// digest.
+func (lnd *LocalNoncesData) Digest() ([32]byte, error) {
+	encoded, err := lnd.AppendTo(nil)
+	if err != nil {
+		return [32]byte{}, err
+	}
+
+	return sha256.Sum256(encoded), nil
+}
+
+// Fingerprint returns a short hex string derived from the first
+// localNoncesFingerprintSize bytes of the Digest of the set. It's meant to
+// quickly tell whether two sets match by comparing log lines, and equal sets
+// always share the same fingerprint. A set too large to be encoded has no
+// digest, and is reported as "oversized".
+func (lnd *LocalNoncesData) Fingerprint() string {
+	digest, err := lnd.Digest()
+	if err != nil {
+		return "oversized"
+	}
+
+	return hex.EncodeToString(digest[:localNoncesFingerprintSize])
+}
+
+// VerifyLocalNoncesDigest checks that the encoded record hashes to want, which
+// was obtained from Digest when the record was stored. This allows callers to
+// detect a record that was modified at rest before decoding it.
+func VerifyLocalNoncesDigest(encoded []byte, want [32]byte) error {
+	got := sha256.Sum256(encoded)
+	if got != want {
+		return fmt.Errorf("%w: expected %x, got %x",
+			ErrLocalNoncesDigestMismatch, want, got)
+	}
+
+	return nil
+}
+
+// MerkleRoot returns the root of a Merkle tree over the entries of the set,
+// which commits to the set while allowing individual entries to be revealed
+// later on using MerkleProof. The leaves are the hashes of each txid and nonce
+// pair in ascending txid order. Each inner node hashes its two children in
+// byte order, and an odd node at the end of a level is carried up as is. The
+// root of an empty set is all zeroes.
+func (lnd *LocalNoncesData) MerkleRoot() [32]byte {
+	level := lnd.merkleLeaves()
+	if len(level) == 0 {
+		return [32]byte{}
+	}
+
+	for len(level) > 1 {
+		level = nextLocalNonceMerkleLevel(level)
+	}
+
+	return level[0]
+}
+
+// MerkleProof returns the sibling hashes that tie the entry of the given txid
+// to the MerkleRoot of the set, ordered from the leaf up. The proof can be
+// checked using VerifyLocalNonceMerkleProof.
+func (lnd *LocalNoncesData) MerkleProof(txid chainhash.Hash) ([][32]byte,
+	error) {
+
+	txids := lnd.sortedTxids()
+	idx := sort.Search(len(txids), func(i int) bool {
+		return bytes.Compare(txids[i][:], txid[:]) >= 0
+	})
+	if idx == len(txids) || txids[idx] != txid {
+		return nil, fmt.Errorf("%w: %v", ErrLocalNonceNotFound, txid)
+	}
+
+	var (
+		level = lnd.merkleLeaves()
+		proof [][32]byte
+	)
+	for len(level) > 1 {
+		// A node without a sibling is carried up as is, so it doesn't
+		// contribute anything to the proof.
+		if sibling := idx ^ 1; sibling < len(level) {
+			proof = append(proof, level[sibling])
+		}
+
+		level = nextLocalNonceMerkleLevel(level)
+		idx /= 2
+	}
+
+	return proof, nil
+}
+
+// MatchesRoot returns true if the MerkleRoot of the set equals root, which a
+// peer previously committed to. This ties a revealed set to its commitment.
+func (lnd *LocalNoncesData) MatchesRoot(root [32]byte) bool {
+	return lnd.MerkleRoot() == root
+}
+
+// VerifyLocalNonceMerkleProof checks that the proof obtained from MerkleProof
+// ties the given txid and nonce to the root.
+func VerifyLocalNonceMerkleProof(root [32]byte, txid chainhash.Hash,
+	nonce Musig2Nonce, proof [][32]byte) error {
+
+	node := localNonceMerkleLeaf(txid, nonce)
+	for _, sibling := range proof {
+		node = localNonceMerkleNode(node, sibling)
+	}
+
+	if node != root {
+		return fmt.Errorf("%w: txid %v",
+			ErrInvalidLocalNonceMerkleProof, txid)
+	}
+
+	return nil
+}
+
+// merkleLeaves returns the Merkle leaves of the entries of the set in
+// ascending txid order.
+func (lnd *LocalNoncesData) merkleLeaves() [][32]byte {
+	txids := lnd.sortedTxids()
+	leaves := make([][32]byte, 0, len(txids))
+	for _, txid := range txids {
+		leaves = append(
+			leaves, localNonceMerkleLeaf(txid, lnd.NoncesMap[txid]),
+		)
+	}
+
+	return leaves
+}
+
+// nextLocalNonceMerkleLevel hashes the nodes of a Merkle tree level in pairs
+// to form the level above it.
+func nextLocalNonceMerkleLevel(level [][32]byte) [][32]byte {
+	next := make([][32]byte, 0, (len(level)+1)/2)
+	for i := 0; i < len(level); i += 2 {
+		if i+1 == len(level) {
+			next = append(next, level[i])
+			break
+		}
+
+		next = append(next, localNonceMerkleNode(level[i], level[i+1]))
+	}
+
+	return next
+}
+
+// localNonceMerkleLeaf returns the Merkle leaf of a single entry.
+func localNonceMerkleLeaf(txid chainhash.Hash, nonce Musig2Nonce) [32]byte {
+	h := sha256.New()
+	h.Write([]byte{localNonceMerkleLeafTag})
+	h.Write(txid[:])
+	h.Write(nonce[:])
+
+	var leaf [32]byte
+	copy(leaf[:], h.Sum(nil))
+
+	return leaf
+}
+
+// localNonceMerkleNode returns the inner node of a Merkle tree that has the
+// two given children. The children are hashed in byte order, so a proof
+// doesn't need to state on which side each sibling sits.
+func localNonceMerkleNode(a, b [32]byte) [32]byte {
+	if bytes.Compare(a[:], b[:]) > 0 {
+		a, b = b, a
+	}
+
+	h := sha256.New()
+	h.Write([]byte{localNonceMerkleNodeTag})
+	h.Write(a[:])
+	h.Write(b[:])
+
+	var node [32]byte
+	copy(node[:], h.Sum(nil))
+
+	return node
+}
@@ -0,0 +1,144 @@
+package lnwire
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestVerifyLocalNoncesDigest tests that a stored record verifies against its
+// digest, and that any modification of it is detected.
+func TestVerifyLocalNoncesDigest(t *testing.T) {
+	t.Parallel()
+
+	nonces := makeTestLocalNonces(3)
+	encoded := encodeTestLocalNonces(t, nonces)
+
+	digest, err := nonces.Digest()
+	require.NoError(t, err)
+	require.NoError(t, VerifyLocalNoncesDigest(encoded, digest))
+
+	// Flipping a single bit anywhere in the record must be detected.
+	for _, idx := range []int{0, localNoncesCountSize, len(encoded) - 1} {
+		corrupted := bytes.Clone(encoded)
+		corrupted[idx] ^= 0x01
+
+		err := VerifyLocalNoncesDigest(corrupted, digest)
+		require.ErrorIs(t, err, ErrLocalNoncesDigestMismatch)
+	}
+
+	// So must a truncated record.
+	err = VerifyLocalNoncesDigest(encoded[:len(encoded)-1], digest)
+	require.ErrorIs(t, err, ErrLocalNoncesDigestMismatch)
+
+	// The digest of a different set doesn't match either.
+	other, err := makeTestLocalNonces(2).Digest()
+	require.NoError(t, err)
+	require.NotEqual(t, digest, other)
+	err = VerifyLocalNoncesDigest(encoded, other)
+	require.ErrorIs(t, err, ErrLocalNoncesDigestMismatch)
+}
+
+// TestLocalNoncesMerkleProof tests that the proof of every entry verifies
+// against the Merkle root, while tampered entries or proofs don't.
+func TestLocalNoncesMerkleProof(t *testing.T) {
+	t.Parallel()
+
+	// Cover a single leaf, full trees and trees with odd levels.
+	for _, n := range []int{1, 2, 3, 4, 5, 7, 8} {
+		nonces := makeTestLocalNonces(n)
+		root := nonces.MerkleRoot()
+
+		for _, entry := range nonces.SortedEntries() {
+			proof, err := nonces.MerkleProof(entry.TXID)
+			require.NoError(t, err)
+			require.NoError(t, VerifyLocalNonceMerkleProof(
+				root, entry.TXID, entry.Nonce, proof,
+			))
+
+			// A different nonce for the same txid must fail.
+			tampered := entry.Nonce
+			tampered[0] ^= 0x01
+			err = VerifyLocalNonceMerkleProof(
+				root, entry.TXID, tampered, proof,
+			)
+			require.ErrorIs(t, err, ErrInvalidLocalNonceMerkleProof)
+
+			// As must a tampered proof.
+			if len(proof) == 0 {
+				continue
+			}
+			proof[0][0] ^= 0x01
+			err = VerifyLocalNonceMerkleProof(
+				root, entry.TXID, entry.Nonce, proof,
+			)
+			require.ErrorIs(t, err, ErrInvalidLocalNonceMerkleProof)
+		}
+	}
+
+	nonces := makeTestLocalNonces(3)
+
+	// The root commits to every entry.
+	changed := makeTestLocalNonces(3)
+	changed.NoncesMap[makeTestTxId(2)] = makeTestNonce(0xaa)
+	require.NotEqual(t, nonces.MerkleRoot(), changed.MerkleRoot())
+
+	// No proof can be produced for an unknown txid.
+	_, err := nonces.MerkleProof(makeTestTxId(9))
+	require.ErrorIs(t, err, ErrLocalNonceNotFound)
+
+	require.Equal(t, [32]byte{}, (&LocalNoncesData{}).MerkleRoot())
+}
+
+// TestLocalNoncesMatchesRoot tests that a set matches the root it was
+// committed to, while a mutated set doesn't.
+func TestLocalNoncesMatchesRoot(t *testing.T) {
+	t.Parallel()
+
+	nonces := makeTestLocalNonces(5)
+	root := nonces.MerkleRoot()
+	require.True(t, nonces.MatchesRoot(root))
+
+	// Changing a nonce, adding an entry or removing one all break the
+	// match.
+	nonces.NoncesMap[makeTestTxId(3)] = makeTestNonce(0xaa)
+	require.False(t, nonces.MatchesRoot(root))
+
+	nonces = makeTestLocalNonces(5)
+	nonces.NoncesMap[makeTestTxId(6)] = makeTestNonce(0xbb)
+	require.False(t, nonces.MatchesRoot(root))
+
+	nonces = makeTestLocalNonces(5)
+	delete(nonces.NoncesMap, makeTestTxId(5))
+	require.False(t, nonces.MatchesRoot(root))
+}
+
+// TestLocalNoncesFingerprint tests that equal sets share a fingerprint, while
+// sets that differ in any entry don't.
+func TestLocalNoncesFingerprint(t *testing.T) {
+	t.Parallel()
+
+	nonces := makeTestLocalNonces(3)
+	fingerprint := nonces.Fingerprint()
+	require.Len(t, fingerprint, 2*localNoncesFingerprintSize)
+	require.Equal(t, fingerprint, makeTestLocalNonces(3).Fingerprint())
+
+	// Changing a single nonce, or the set of txids, changes the
+	// fingerprint.
+	changed := makeTestLocalNonces(3)
+	changed.NoncesMap[makeTestTxId(1)] = makeTestNonce(0xff)
+	require.NotEqual(t, fingerprint, changed.Fingerprint())
+	require.NotEqual(t, fingerprint, makeTestLocalNonces(2).Fingerprint())
+
+	// The empty set has a fingerprint of its own.
+	var empty *LocalNoncesData
+	require.NotEqual(t, fingerprint, empty.Fingerprint())
+
+	// A set that can't be encoded has no digest to derive it from.
+	oversized := makeRandomLocalNonces(
+		t, rand.New(rand.NewSource(1)), MaxLocalNonces+1,
+	)
+	require.Equal(t, "oversized", oversized.Fingerprint())
+}
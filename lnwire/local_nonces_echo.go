@@ -0,0 +1,84 @@
+package lnwire
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+// ErrLocalNoncesEchoMismatch is returned when a peer echoes back a set that
+// differs from the one that was sent.
+var ErrLocalNoncesEchoMismatch = errors.New("local nonces echo mismatch")
+
+// LocalNoncesEchoError describes how an echoed set differs from the one that
+// was sent. Each list holds txids in ascending order. The error matches
+// ErrLocalNoncesEchoMismatch with errors.Is.
+type LocalNoncesEchoError struct {
+	// Missing holds the txids of the entries that were sent, but not
+	// echoed.
+	Missing []chainhash.Hash
+
+	// Extra holds the txids of the entries that were echoed, but never
+	// sent.
+	Extra []chainhash.Hash
+
+	// Changed holds the txids of the entries that were echoed with a
+	// different nonce than the one that was sent.
+	Changed []chainhash.Hash
+}
+
+// Error returns a human readable description of the error.
+func (e *LocalNoncesEchoError) Error() string {
+	var parts []string
+	for _, diff := range []struct {
+		kind  string
+		txids []chainhash.Hash
+	}{
+		{"missing", e.Missing},
+		{"extra", e.Extra},
+		{"changed", e.Changed},
+	} {
+		if len(diff.txids) == 0 {
+			continue
+		}
+
+		parts = append(parts, fmt.Sprintf("%d %s %v", len(diff.txids),
+			diff.kind, diff.txids))
+	}
+
+	return fmt.Sprintf("%v: %s", ErrLocalNoncesEchoMismatch,
+		strings.Join(parts, ", "))
+}
+
+// Unwrap returns ErrLocalNoncesEchoMismatch.
+func (e *LocalNoncesEchoError) Unwrap() error {
+	return ErrLocalNoncesEchoMismatch
+}
+
+// VerifyEcho checks that echoed, the set a peer echoed back, holds exactly the
+// entries of the set that was sent. If it doesn't, a LocalNoncesEchoError
+// lists the missing, extra and changed entries. A nil echoed is treated as an
+// empty set.
+func (lnd *LocalNoncesData) VerifyEcho(echoed *LocalNoncesData) error {
+	missing, extra := lnd.SymmetricDiff(echoed)
+
+	var changed []chainhash.Hash
+	for _, txid := range lnd.sortedTxids() {
+		nonce, ok := echoed.Get(txid)
+		if ok && nonce != lnd.NoncesMap[txid] {
+			changed = append(changed, txid)
+		}
+	}
+
+	if len(missing) == 0 && len(extra) == 0 && len(changed) == 0 {
+		return nil
+	}
+
+	return &LocalNoncesEchoError{
+		Missing: missing,
+		Extra:   extra,
+		Changed: changed,
+	}
+}
@@ -0,0 +1,107 @@
+package lnwire
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/stretchr/testify/require"
+)
+
+// TestLocalNoncesVerifyEcho tests that an exact echo is accepted, while each
+// kind of divergence is reported with the txids it affects.
+func TestLocalNoncesVerifyEcho(t *testing.T) {
+	t.Parallel()
+
+	sent := makeTestLocalNonces(3)
+
+	testCases := []struct {
+		name     string
+		echo     func(echoed *LocalNoncesData)
+		expected *LocalNoncesEchoError
+		msg      string
+	}{
+		{
+			name: "exact",
+			echo: func(*LocalNoncesData) {},
+		},
+		{
+			name: "missing",
+			echo: func(echoed *LocalNoncesData) {
+				delete(echoed.NoncesMap, makeTestTxId(2))
+			},
+			expected: &LocalNoncesEchoError{
+				Missing: []chainhash.Hash{makeTestTxId(2)},
+			},
+			msg: "1 missing",
+		},
+		{
+			name: "extra",
+			echo: func(echoed *LocalNoncesData) {
+				echoed.NoncesMap[makeTestTxId(4)] =
+					makeTestNonce(4)
+			},
+			expected: &LocalNoncesEchoError{
+				Extra: []chainhash.Hash{makeTestTxId(4)},
+			},
+			msg: "1 extra",
+		},
+		{
+			name: "changed",
+			echo: func(echoed *LocalNoncesData) {
+				echoed.NoncesMap[makeTestTxId(1)] =
+					makeTestNonce(0xaa)
+				echoed.NoncesMap[makeTestTxId(3)] =
+					makeTestNonce(0xbb)
+			},
+			expected: &LocalNoncesEchoError{
+				Changed: []chainhash.Hash{
+					makeTestTxId(1), makeTestTxId(3),
+				},
+			},
+			msg: "2 changed",
+		},
+		{
+			name: "all",
+			echo: func(echoed *LocalNoncesData) {
+				delete(echoed.NoncesMap, makeTestTxId(1))
+				echoed.NoncesMap[makeTestTxId(2)] =
+					makeTestNonce(0xaa)
+				echoed.NoncesMap[makeTestTxId(4)] =
+					makeTestNonce(4)
+			},
+			expected: &LocalNoncesEchoError{
+				Missing: []chainhash.Hash{makeTestTxId(1)},
+				Extra:   []chainhash.Hash{makeTestTxId(4)},
+				Changed: []chainhash.Hash{makeTestTxId(2)},
+			},
+			msg: "1 missing",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			echoed := copyLocalNonces(sent)
+			tc.echo(echoed)
+
+			err := sent.VerifyEcho(echoed)
+			if tc.expected == nil {
+				require.NoError(t, err)
+				return
+			}
+
+			require.ErrorIs(t, err, ErrLocalNoncesEchoMismatch)
+			require.ErrorContains(t, err, tc.msg)
+
+			var echoErr *LocalNoncesEchoError
+			require.True(t, errors.As(err, &echoErr))
+			require.Equal(t, tc.expected, echoErr)
+		})
+	}
+
+	// A nil echo is missing every entry.
+	err := sent.VerifyEcho(nil)
+	require.ErrorIs(t, err, ErrLocalNoncesEchoMismatch)
+	require.ErrorContains(t, err, "3 missing")
+}
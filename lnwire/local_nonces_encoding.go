@@ -0,0 +1,812 @@
+package lnwire
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/btcsuite/btcd/btcec/v2/schnorr/musig2"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+// LocalNoncesEncoding is an enum-like type that represents exactly how the
+// entries of a LocalNoncesData are encoded. Every encoding starts with the
+// same 2-byte entry count and writes the entries in ascending txid order.
+// Encodings other than LocalNoncesEncodingFixed must only be used once both
+// peers have signalled that they understand them.
+type LocalNoncesEncoding uint8
+
+const (
+	// LocalNoncesEncodingFixed signals that each entry is the txid
+	// followed by the nonce, which is assumed to be exactly
+	// musig2.PubNonceSize bytes. This is the encoding used by the TLV
+	// record.
+	LocalNoncesEncodingFixed LocalNoncesEncoding = 0
+
+	// LocalNoncesEncodingLenPrefixed signals that each entry is the txid
+	// followed by a single byte nonce length and then the nonce itself.
+	// This costs an extra byte per entry, but allows nonce formats of a
+	// different size to be introduced later on.
+	LocalNoncesEncodingLenPrefixed LocalNoncesEncoding = 1
+
+	// LocalNoncesEncodingXOnly signals that each entry is the txid
+	// followed by the x coordinates of the two points of the nonce. By
+	// convention, both points must have an even y coordinate, which saves
+	// the two parity bytes per entry. Nonces with an odd point can't be
+	// encoded this way.
+	LocalNoncesEncodingXOnly LocalNoncesEncoding = 2
+
+	// LocalNoncesEncodingRoleTagged signals that each entry is the txid
+	// followed by a single byte LocalNonceRole and then the nonce, which
+	// allows nonces to be grouped by the role of the transaction they
+	// sign. Entries without a role are tagged LocalNonceRoleUnspecified.
+	LocalNoncesEncodingRoleTagged LocalNoncesEncoding = 3
+
+	// LocalNoncesEncodingPrefixCompressed signals that each entry starts
+	// with a single byte holding the number of leading bytes its txid
+	// shares with the txid of the previous entry, followed by the rest of
+	// the txid and then the nonce. This saves space when the txids of a
+	// set are clustered, but costs an extra byte per entry otherwise.
+	LocalNoncesEncodingPrefixCompressed LocalNoncesEncoding = 4
+)
+
+// LocalNonceRole tags an entry of a LocalNoncesData with the role of the
+// transaction its nonce signs.
+type LocalNonceRole uint8
+
+const (
+	// LocalNonceRoleUnspecified is the role of entries that weren't
+	// tagged with any specific role.
+	LocalNonceRoleUnspecified LocalNonceRole = 0
+
+	// LocalNonceRoleFunding tags the nonce of a funding transaction.
+	LocalNonceRoleFunding LocalNonceRole = 1
+
+	// LocalNonceRoleClosing tags the nonce of a closing transaction.
+	LocalNonceRoleClosing LocalNonceRole = 2
+)
+
+// localNonceRoleTaggedEntrySize is the encoded size of a single entry in the
+// role tagged encoding.
+const localNonceRoleTaggedEntrySize = localNonceEntrySize + 1
+
+const (
+	// nonceXOnlyPointSize is the size of a single point of a nonce in the
+	// x-only encoding.
+	nonceXOnlyPointSize = 32
+
+	// localNonceXOnlyEntrySize is the encoded size of a single entry in
+	// the x-only encoding.
+	localNonceXOnlyEntrySize = chainhash.HashSize + 2*nonceXOnlyPointSize
+
+	// compressedPointEven is the prefix of a compressed point with an even
+	// y coordinate.
+	compressedPointEven = 0x02
+)
+
+var (
+	// localNoncesLenPrefixedLayout is the layout of the length prefixed
+	// encoding, where each entry takes up at least its txid and its
+	// length byte.
+	localNoncesLenPrefixedLayout = localNoncesLayout{
+		entrySize: chainhash.HashSize + 1,
+		variable:  true,
+	}
+
+	// localNoncesXOnlyLayout is the layout of the x-only encoding.
+	localNoncesXOnlyLayout = localNoncesLayout{
+		entrySize: localNonceXOnlyEntrySize,
+	}
+
+	// localNoncesRoleTaggedLayout is the layout of the role tagged
+	// encoding.
+	localNoncesRoleTaggedLayout = localNoncesLayout{
+		entrySize: localNonceRoleTaggedEntrySize,
+	}
+
+	// localNoncesPrefixCompressedLayout is the layout of the prefix
+	// compressed encoding, where each entry takes up at least its prefix
+	// byte and its nonce.
+	localNoncesPrefixCompressedLayout = localNoncesLayout{
+		entrySize: 1 + musig2.PubNonceSize,
+		variable:  true,
+	}
+)
+
+var (
+	// ErrUnknownLocalNoncesEncoding is returned when a LocalNoncesData is
+	// encoded or decoded using an encoding we don't know of.
+	ErrUnknownLocalNoncesEncoding = errors.New("unknown local nonces " +
+		"encoding")
+
+	// ErrInvalidLocalNonceLength is returned when a length prefixed entry
+	// declares a nonce length that we can't make sense of.
+	ErrInvalidLocalNonceLength = errors.New("invalid local nonce length")
+
+	// ErrLocalNonceNotXOnly is returned when a nonce can't be encoded
+	// using the x-only encoding, as one of its points doesn't have an
+	// even y coordinate.
+	ErrLocalNonceNotXOnly = errors.New("local nonce can't be x-only " +
+		"encoded")
+
+	// ErrInvalidLocalNonceSharedPrefix is returned when a prefix
+	// compressed entry declares a shared txid prefix that we can't make
+	// sense of.
+	ErrInvalidLocalNonceSharedPrefix = errors.New("invalid local nonce " +
+		"shared prefix")
+)
+
+// String returns a human readable description of the encoding.
+func (e LocalNoncesEncoding) String() string {
+	switch e {
+	case LocalNoncesEncodingFixed:
+		return "fixed"
+
+	case LocalNoncesEncodingLenPrefixed:
+		return "length-prefixed"
+
+	case LocalNoncesEncodingXOnly:
+		return "x-only"
+
+	case LocalNoncesEncodingRoleTagged:
+		return "role-tagged"
+
+	case LocalNoncesEncodingPrefixCompressed:
+		return "prefix-compressed"
+
+	default:
+		return fmt.Sprintf("unknown(%d)", uint8(e))
+	}
+}
+
+// EncodeWith writes the set to w using the given encoding. A set of more than
+// MaxLocalNonces entries is rejected with ErrTooManyLocalNonces before
+// anything is written, as no decoder would accept it.
+func (lnd *LocalNoncesData) EncodeWith(w io.Writer,
+	encoding LocalNoncesEncoding) error {
+
+	if lnd.Len() > MaxLocalNonces {
+		return fmt.Errorf("%w: %d entries, max is %d",
+			ErrTooManyLocalNonces, lnd.Len(), MaxLocalNonces)
+	}
+
+	switch encoding {
+	case LocalNoncesEncodingFixed:
+		var buf [8]byte
+		return encodeLocalNoncesData(w, lnd, &buf)
+
+	case LocalNoncesEncodingLenPrefixed:
+		return encodeLocalNoncesLenPrefixed(w, lnd)
+
+	case LocalNoncesEncodingXOnly:
+		return encodeLocalNoncesXOnly(w, lnd)
+
+	case LocalNoncesEncodingRoleTagged:
+		return encodeLocalNoncesRoleTagged(w, lnd)
+
+	case LocalNoncesEncodingPrefixCompressed:
+		return encodeLocalNoncesPrefixCompressed(w, lnd)
+
+	default:
+		return fmt.Errorf("%w: %v", ErrUnknownLocalNoncesEncoding,
+			encoding)
+	}
+}
+
+// DecodeLocalNoncesWith reads a set of recordLen bytes from r that was written
+// using the given encoding.
+func DecodeLocalNoncesWith(r io.Reader, recordLen uint64,
+	encoding LocalNoncesEncoding) (*LocalNoncesData, error) {
+
+	var (
+		lnd LocalNoncesData
+		err error
+	)
+	switch encoding {
+	case LocalNoncesEncodingFixed:
+		var buf [8]byte
+		err = decodeLocalNoncesData(r, &lnd, &buf, recordLen)
+
+	case LocalNoncesEncodingLenPrefixed:
+		err = decodeLocalNoncesLenPrefixed(r, &lnd, recordLen)
+
+	case LocalNoncesEncodingXOnly:
+		err = decodeLocalNoncesXOnly(r, &lnd, recordLen)
+
+	case LocalNoncesEncodingRoleTagged:
+		err = decodeLocalNoncesRoleTagged(r, &lnd, recordLen)
+
+	case LocalNoncesEncodingPrefixCompressed:
+		err = decodeLocalNoncesPrefixCompressed(r, &lnd, recordLen)
+
+	default:
+		err = fmt.Errorf("%w: %v", ErrUnknownLocalNoncesEncoding,
+			encoding)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &lnd, nil
+}
+
+// encodeLocalNoncesLenPrefixed writes the set to w using the length prefixed
+// encoding.
+func encodeLocalNoncesLenPrefixed(w io.Writer, lnd *LocalNoncesData) error {
+	if err := writeLocalNoncesCount(w, lnd.Len()); err != nil {
+		return err
+	}
+
+	for _, txid := range lnd.sortedTxids() {
+		if _, err := w.Write(txid[:]); err != nil {
+			return err
+		}
+
+		nonce := lnd.NoncesMap[txid]
+		if _, err := w.Write([]byte{byte(len(nonce))}); err != nil {
+			return err
+		}
+		if _, err := w.Write(nonce[:]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// localNoncesBodyReader reads the entries of a record that follow its count
+// field, never reading past the end of the record and keeping track of the
+// offset within the entries so errors can point at the offending byte.
+type localNoncesBodyReader struct {
+	lr      io.LimitedReader
+	bodyLen uint64
+	layout  localNoncesLayout
+}
+
+// newLocalNoncesBodyReader returns a reader for the entries of a record of
+// recordLen bytes laid out as described by layout, whose count field was
+// already read from r.
+func newLocalNoncesBodyReader(r io.Reader, recordLen uint64,
+	layout localNoncesLayout) *localNoncesBodyReader {
+
+	var bodyLen uint64
+	if recordLen > localNoncesCountSize {
+		bodyLen = recordLen - localNoncesCountSize
+	}
+
+	return &localNoncesBodyReader{
+		lr:      io.LimitedReader{R: r, N: int64(bodyLen)},
+		bodyLen: bodyLen,
+		layout:  layout,
+	}
+}
+
+// offset returns the offset of the next byte to be read within the entries.
+func (b *localNoncesBodyReader) offset() uint64 {
+	return b.bodyLen - uint64(b.lr.N)
+}
+
+// readFull fills p with the next bytes of the entries.
+func (b *localNoncesBodyReader) readFull(p []byte) error {
+	start := b.offset()
+	if n, err := io.ReadFull(&b.lr, p); err != nil {
+		return b.layout.entryError(start+uint64(n), err)
+	}
+
+	return nil
+}
+
+// finish makes sure that all entries of the record were read.
+func (b *localNoncesBodyReader) finish() error {
+	if b.lr.N == 0 {
+		return nil
+	}
+
+	return b.layout.entryError(b.offset(), fmt.Errorf("%w: %d trailing "+
+		"bytes", ErrLocalNoncesLengthMismatch, b.lr.N))
+}
+
+// decodeLocalNoncesLenPrefixed reads a set of recordLen bytes that was written
+// using the length prefixed encoding into lnd.
+func decodeLocalNoncesLenPrefixed(r io.Reader, lnd *LocalNoncesData,
+	recordLen uint64) error {
+
+	numEntries, err := readLocalNoncesHeaderWith(
+		r, recordLen, localNoncesLenPrefixedLayout,
+	)
+	if err != nil {
+		return err
+	}
+
+	// Never read past the end of the record, no matter what lengths the
+	// entries declare.
+	br := newLocalNoncesBodyReader(
+		r, recordLen, localNoncesLenPrefixedLayout,
+	)
+
+	nonces := make(map[chainhash.Hash]Musig2Nonce, numEntries)
+	for i := uint16(0); i < numEntries; i++ {
+		var (
+			txid     chainhash.Hash
+			nonceLen [1]byte
+			nonce    Musig2Nonce
+		)
+		start := br.offset()
+		if err := br.readFull(txid[:]); err != nil {
+			return err
+		}
+		if err := br.readFull(nonceLen[:]); err != nil {
+			return err
+		}
+
+		// The only nonce format we currently know of is the musig2
+		// public nonce, so anything else is rejected.
+		if nonceLen[0] != musig2.PubNonceSize {
+			return br.layout.entryError(br.offset()-1, fmt.Errorf(
+				"%w: entry %d declares %d bytes, expected %d",
+				ErrInvalidLocalNonceLength, i, nonceLen[0],
+				musig2.PubNonceSize,
+			))
+		}
+
+		if err := br.readFull(nonce[:]); err != nil {
+			return err
+		}
+
+		err := addDecodedLocalNonce(nonces, txid, nonce)
+		if err != nil {
+			return br.layout.entryError(start, err)
+		}
+	}
+
+	if err := br.finish(); err != nil {
+		return err
+	}
+
+	lnd.NoncesMap = nonces
+
+	return nil
+}
+
+// encodeLocalNoncesXOnly writes the set to w using the x-only encoding. All
+// nonces are checked up front, so nothing is written if any of them can't be
+// encoded.
+func encodeLocalNoncesXOnly(w io.Writer, lnd *LocalNoncesData) error {
+	txids := lnd.sortedTxids()
+	for _, txid := range txids {
+		nonce := lnd.NoncesMap[txid]
+		for _, prefix := range nonceXOnlyPrefixes(nonce) {
+			if prefix == compressedPointEven {
+				continue
+			}
+
+			return fmt.Errorf("%w: nonce for txid %v has a point "+
+				"with prefix %#x", ErrLocalNonceNotXOnly, txid,
+				prefix)
+		}
+	}
+
+	if err := writeLocalNoncesCount(w, len(txids)); err != nil {
+		return err
+	}
+
+	const pointSize = musig2.PubNonceSize / 2
+	for _, txid := range txids {
+		nonce := lnd.NoncesMap[txid]
+		if _, err := w.Write(txid[:]); err != nil {
+			return err
+		}
+		if _, err := w.Write(nonce[1:pointSize]); err != nil {
+			return err
+		}
+		if _, err := w.Write(nonce[pointSize+1:]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// nonceXOnlyPrefixes returns the compressed point prefixes of the two points
+// that make up the nonce.
+func nonceXOnlyPrefixes(nonce Musig2Nonce) [2]byte {
+	return [2]byte{nonce[0], nonce[musig2.PubNonceSize/2]}
+}
+
+// decodeLocalNoncesXOnly reads a set of recordLen bytes that was written using
+// the x-only encoding into lnd, reconstructing the full nonces with even
+// points.
+func decodeLocalNoncesXOnly(r io.Reader, lnd *LocalNoncesData,
+	recordLen uint64) error {
+
+	numEntries, err := readLocalNoncesHeaderWith(
+		r, recordLen, localNoncesXOnlyLayout,
+	)
+	if err != nil {
+		return err
+	}
+
+	br := newLocalNoncesBodyReader(r, recordLen, localNoncesXOnlyLayout)
+
+	const pointSize = musig2.PubNonceSize / 2
+	nonces := make(map[chainhash.Hash]Musig2Nonce, numEntries)
+	for i := uint16(0); i < numEntries; i++ {
+		var (
+			txid  chainhash.Hash
+			nonce Musig2Nonce
+		)
+		start := br.offset()
+		if err := br.readFull(txid[:]); err != nil {
+			return err
+		}
+
+		nonce[0] = compressedPointEven
+		if err := br.readFull(nonce[1:pointSize]); err != nil {
+			return err
+		}
+
+		nonce[pointSize] = compressedPointEven
+		if err := br.readFull(nonce[pointSize+1:]); err != nil {
+			return err
+		}
+
+		err := addDecodedLocalNonce(nonces, txid, nonce)
+		if err != nil {
+			return br.layout.entryError(start, err)
+		}
+	}
+
+	lnd.NoncesMap = nonces
+
+	return nil
+}
+
+// AddTagged adds the nonce for the given txid to the set, tagged with the role
+// of the transaction it signs. The role is only encoded when using
+// LocalNoncesEncodingRoleTagged, every other encoding ignores it.
+func (lnd *LocalNoncesData) AddTagged(txid chainhash.Hash, nonce Musig2Nonce,
+	role LocalNonceRole) {
+
+	if lnd.NoncesMap == nil {
+		lnd.NoncesMap = make(map[chainhash.Hash]Musig2Nonce)
+	}
+	lnd.NoncesMap[txid] = nonce
+
+	if lnd.roles == nil {
+		lnd.roles = make(map[chainhash.Hash]LocalNonceRole)
+	}
+	lnd.roles[txid] = role
+}
+
+// Role returns the role the entry of the given txid was tagged with, which is
+// LocalNonceRoleUnspecified for untagged entries.
+func (lnd *LocalNoncesData) Role(txid chainhash.Hash) LocalNonceRole {
+	if lnd == nil {
+		return LocalNonceRoleUnspecified
+	}
+
+	return lnd.roles[txid]
+}
+
+// encodeLocalNoncesRoleTagged writes the set to w using the role tagged
+// encoding.
+func encodeLocalNoncesRoleTagged(w io.Writer, lnd *LocalNoncesData) error {
+	if err := writeLocalNoncesCount(w, lnd.Len()); err != nil {
+		return err
+	}
+
+	for _, txid := range lnd.sortedTxids() {
+		if _, err := w.Write(txid[:]); err != nil {
+			return err
+		}
+		if _, err := w.Write([]byte{byte(lnd.Role(txid))}); err != nil {
+			return err
+		}
+
+		nonce := lnd.NoncesMap[txid]
+		if _, err := w.Write(nonce[:]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// decodeLocalNoncesRoleTagged reads a set of recordLen bytes that was written
+// using the role tagged encoding into lnd. Roles we don't know of are kept as
+// is rather than rejected, so that new roles can be introduced without
+// breaking older nodes.
+func decodeLocalNoncesRoleTagged(r io.Reader, lnd *LocalNoncesData,
+	recordLen uint64) error {
+
+	numEntries, err := readLocalNoncesHeaderWith(
+		r, recordLen, localNoncesRoleTaggedLayout,
+	)
+	if err != nil {
+		return err
+	}
+
+	br := newLocalNoncesBodyReader(
+		r, recordLen, localNoncesRoleTaggedLayout,
+	)
+
+	var (
+		nonces = make(map[chainhash.Hash]Musig2Nonce, numEntries)
+		roles  = make(map[chainhash.Hash]LocalNonceRole)
+	)
+	for i := uint16(0); i < numEntries; i++ {
+		var (
+			txid  chainhash.Hash
+			role  [1]byte
+			nonce Musig2Nonce
+		)
+		start := br.offset()
+		if err := br.readFull(txid[:]); err != nil {
+			return err
+		}
+		if err := br.readFull(role[:]); err != nil {
+			return err
+		}
+		if err := br.readFull(nonce[:]); err != nil {
+			return err
+		}
+
+		err := addDecodedLocalNonce(nonces, txid, nonce)
+		if err != nil {
+			return br.layout.entryError(start, err)
+		}
+
+		// Untagged entries aren't tracked, just like when they were
+		// added in the first place.
+		if LocalNonceRole(role[0]) != LocalNonceRoleUnspecified {
+			roles[txid] = LocalNonceRole(role[0])
+		}
+	}
+
+	lnd.NoncesMap = nonces
+	lnd.roles = roles
+
+	return nil
+}
+
+// sharedTxidPrefixLen returns the number of leading bytes a and b have in
+// common.
+func sharedTxidPrefixLen(a, b chainhash.Hash) int {
+	var n int
+	for n < chainhash.HashSize && a[n] == b[n] {
+		n++
+	}
+
+	return n
+}
+
+// encodeLocalNoncesPrefixCompressed writes the set to w using the prefix
+// compressed encoding.
+func encodeLocalNoncesPrefixCompressed(w io.Writer,
+	lnd *LocalNoncesData) error {
+
+	if err := writeLocalNoncesCount(w, lnd.Len()); err != nil {
+		return err
+	}
+
+	var prev chainhash.Hash
+	for i, txid := range lnd.sortedTxids() {
+		// The first entry has no previous txid to share a prefix with.
+		var shared int
+		if i > 0 {
+			shared = sharedTxidPrefixLen(prev, txid)
+		}
+		prev = txid
+
+		if _, err := w.Write([]byte{byte(shared)}); err != nil {
+			return err
+		}
+		if _, err := w.Write(txid[shared:]); err != nil {
+			return err
+		}
+
+		nonce := lnd.NoncesMap[txid]
+		if _, err := w.Write(nonce[:]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// decodeLocalNoncesPrefixCompressed reads a set of recordLen bytes that was
+// written using the prefix compressed encoding into lnd.
+func decodeLocalNoncesPrefixCompressed(r io.Reader, lnd *LocalNoncesData,
+	recordLen uint64) error {
+
+	numEntries, err := readLocalNoncesHeaderWith(
+		r, recordLen, localNoncesPrefixCompressedLayout,
+	)
+	if err != nil {
+		return err
+	}
+
+	// Never read past the end of the record, no matter what prefixes the
+	// entries declare.
+	br := newLocalNoncesBodyReader(
+		r, recordLen, localNoncesPrefixCompressedLayout,
+	)
+
+	var (
+		nonces = make(map[chainhash.Hash]Musig2Nonce, numEntries)
+		txid   chainhash.Hash
+	)
+	for i := uint16(0); i < numEntries; i++ {
+		var (
+			shared [1]byte
+			nonce  Musig2Nonce
+		)
+		start := br.offset()
+		if err := br.readFull(shared[:]); err != nil {
+			return err
+		}
+
+		// The first entry can't share anything, and no later one can
+		// share its entire txid, as that would make it a duplicate.
+		if (i == 0 && shared[0] != 0) ||
+			shared[0] >= chainhash.HashSize {
+
+			return br.layout.entryError(start, fmt.Errorf("%w: "+
+				"entry %d declares %d bytes",
+				ErrInvalidLocalNonceSharedPrefix, i, shared[0]))
+		}
+
+		// The shared prefix is still in place from the previous
+		// entry, so only the rest of the txid needs to be read.
+		if err := br.readFull(txid[shared[0]:]); err != nil {
+			return err
+		}
+		if err := br.readFull(nonce[:]); err != nil {
+			return err
+		}
+
+		err := addDecodedLocalNonce(nonces, txid, nonce)
+		if err != nil {
+			return br.layout.entryError(start, err)
+		}
+	}
+
+	if err := br.finish(); err != nil {
+		return err
+	}
+
+	lnd.NoncesMap = nonces
+
+	return nil
+}
+
+// encodedSizeWith returns the number of bytes the set takes up when written
+// using the given encoding, without actually encoding it. The returned bool
+// is false if the set can't be written using the encoding at all.
+func (lnd *LocalNoncesData) encodedSizeWith(
+	encoding LocalNoncesEncoding) (uint64, bool) {
+
+	numEntries := uint64(lnd.Len())
+
+	switch encoding {
+	case LocalNoncesEncodingFixed:
+		return localNoncesCountSize + numEntries*localNonceEntrySize,
+			true
+
+	case LocalNoncesEncodingLenPrefixed:
+		return localNoncesCountSize +
+			numEntries*(localNonceEntrySize+1), true
+
+	case LocalNoncesEncodingXOnly:
+		for _, nonce := range lnd.NoncesMap {
+			for _, prefix := range nonceXOnlyPrefixes(nonce) {
+				if prefix != compressedPointEven {
+					return 0, false
+				}
+			}
+		}
+
+		return localNoncesCountSize +
+			numEntries*localNonceXOnlyEntrySize, true
+
+	case LocalNoncesEncodingRoleTagged:
+		return localNoncesCountSize +
+			numEntries*localNonceRoleTaggedEntrySize, true
+
+	case LocalNoncesEncodingPrefixCompressed:
+		size := uint64(localNoncesCountSize)
+
+		var prev chainhash.Hash
+		for i, txid := range lnd.sortedTxids() {
+			var shared int
+			if i > 0 {
+				shared = sharedTxidPrefixLen(prev, txid)
+			}
+			prev = txid
+
+			size += uint64(1 + localNonceEntrySize - shared)
+		}
+
+		return size, true
+
+	default:
+		return 0, false
+	}
+}
+
+// BestEncoding returns the encoding among the given candidates that results
+// in the smallest encoding of the set, along with its size in bytes, without
+// actually encoding the set. Candidates that can't encode the set, such as
+// LocalNoncesEncodingXOnly for a set with odd nonces, are skipped, and ties
+// go to the candidate listed first. If no candidate can encode the set,
+// LocalNoncesEncodingFixed is returned, as every peer understands it.
+func (lnd *LocalNoncesData) BestEncoding(
+	candidates ...LocalNoncesEncoding) (LocalNoncesEncoding, uint64) {
+
+	best := LocalNoncesEncodingFixed
+	bestSize, _ := lnd.encodedSizeWith(best)
+
+	found := false
+	for _, encoding := range candidates {
+		size, ok := lnd.encodedSizeWith(encoding)
+		if !ok || (found && size >= bestSize) {
+			continue
+		}
+
+		best, bestSize, found = encoding, size, true
+	}
+
+	return best, bestSize
+}
+
+// localNoncesAdaptiveEncodings are the candidate encodings EncodeAdaptive
+// picks from, in order of preference for sets where they tie.
+var localNoncesAdaptiveEncodings = []LocalNoncesEncoding{
+	LocalNoncesEncodingFixed,
+	LocalNoncesEncodingLenPrefixed,
+	LocalNoncesEncodingXOnly,
+	LocalNoncesEncodingRoleTagged,
+	LocalNoncesEncodingPrefixCompressed,
+}
+
+// EncodeAdaptive writes the set to w using whichever encoding results in the
+// smallest encoding, as picked by BestEncoding. The encoding is written as a
+// single tag byte ahead of the body, which makes the result self-describing,
+// so it can be read back using DecodeLocalNoncesAdaptive. A set of more than
+// MaxLocalNonces entries is rejected with ErrTooManyLocalNonces before
+// anything is written, not even the tag byte.
+func (lnd *LocalNoncesData) EncodeAdaptive(w io.Writer) error {
+	if lnd.Len() > MaxLocalNonces {
+		return fmt.Errorf("%w: %d entries, max is %d",
+			ErrTooManyLocalNonces, lnd.Len(), MaxLocalNonces)
+	}
+
+	encoding, _ := lnd.BestEncoding(localNoncesAdaptiveEncodings...)
+
+	if _, err := w.Write([]byte{byte(encoding)}); err != nil {
+		return err
+	}
+
+	return lnd.EncodeWith(w, encoding)
+}
+
+// DecodeLocalNoncesAdaptive reads a set of recordLen bytes from r that was
+// written using EncodeAdaptive, decoding the body using the encoding named by
+// its tag byte. An unknown tag results in ErrUnknownLocalNoncesEncoding.
+func DecodeLocalNoncesAdaptive(r io.Reader,
+	recordLen uint64) (*LocalNoncesData, error) {
+
+	if recordLen == 0 {
+		return nil, fmt.Errorf("%w: missing encoding tag",
+			ErrLocalNoncesRecordTooShort)
+	}
+
+	var tag [1]byte
+	if _, err := io.ReadFull(r, tag[:]); err != nil {
+		return nil, err
+	}
+
+	return DecodeLocalNoncesWith(
+		r, recordLen-1, LocalNoncesEncoding(tag[0]),
+	)
+}
@@ -0,0 +1,630 @@
+package lnwire
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2/schnorr/musig2"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/stretchr/testify/require"
+)
+
+// encodeTestLocalNoncesWith returns the encoding of the given set using the
+// given encoding.
+func encodeTestLocalNoncesWith(t *testing.T, lnd *LocalNoncesData,
+	encoding LocalNoncesEncoding) []byte {
+
+	t.Helper()
+
+	var b bytes.Buffer
+	require.NoError(t, lnd.EncodeWith(&b, encoding))
+
+	return b.Bytes()
+}
+
+// TestLocalNoncesLenPrefixedEncoding tests that a set can be round-tripped
+// through the length prefixed encoding.
+func TestLocalNoncesLenPrefixedEncoding(t *testing.T) {
+	t.Parallel()
+
+	for _, n := range []int{0, 1, 5} {
+		nonces := makeTestLocalNonces(n)
+
+		encoded := encodeTestLocalNoncesWith(
+			t, nonces, LocalNoncesEncodingLenPrefixed,
+		)
+		require.Len(
+			t, encoded,
+			localNoncesCountSize+n*(localNonceEntrySize+1),
+		)
+
+		decoded, err := DecodeLocalNoncesWith(
+			bytes.NewReader(encoded), uint64(len(encoded)),
+			LocalNoncesEncodingLenPrefixed,
+		)
+		require.NoError(t, err)
+		require.Equal(t, nonces.NoncesMap, decoded.NoncesMap)
+		requireStrictlyAscending(t, decoded.SortedEntries())
+	}
+
+	// The fixed encoding must match the TLV record encoding.
+	nonces := makeTestLocalNonces(3)
+	require.Equal(
+		t, encodeTestLocalNonces(t, nonces),
+		encodeTestLocalNoncesWith(t, nonces, LocalNoncesEncodingFixed),
+	)
+}
+
+// TestLocalNoncesLenPrefixedDecodeFailures tests that malformed length
+// prefixed encodings are rejected.
+func TestLocalNoncesLenPrefixedDecodeFailures(t *testing.T) {
+	t.Parallel()
+
+	valid := encodeTestLocalNoncesWith(
+		t, makeTestLocalNonces(2), LocalNoncesEncodingLenPrefixed,
+	)
+	lenIdx := localNoncesCountSize + chainhash.HashSize
+
+	badLen := bytes.Clone(valid)
+	badLen[lenIdx] = musig2.PubNonceSize + 1
+
+	zeroLen := bytes.Clone(valid)
+	zeroLen[lenIdx] = 0
+
+	testCases := []struct {
+		name      string
+		value     []byte
+		recordLen uint64
+		expErr    error
+	}{
+		{
+			name:      "implausible nonce length",
+			value:     badLen,
+			recordLen: uint64(len(badLen)),
+			expErr:    ErrInvalidLocalNonceLength,
+		},
+		{
+			name:      "zero nonce length",
+			value:     zeroLen,
+			recordLen: uint64(len(zeroLen)),
+			expErr:    ErrInvalidLocalNonceLength,
+		},
+		{
+			name:      "too many entries",
+			value:     []byte{0xff, 0xff, 0x00},
+			recordLen: 3,
+			expErr:    ErrTooManyLocalNonces,
+		},
+		{
+			name:      "trailing bytes",
+			value:     append(bytes.Clone(valid), 0x00),
+			recordLen: uint64(len(valid)) + 1,
+			expErr:    ErrLocalNoncesLengthMismatch,
+		},
+		{
+			name:      "count too large for record",
+			value:     []byte{0x00, 0x02, 0x00},
+			recordLen: 3,
+			expErr:    ErrLocalNoncesLengthMismatch,
+		},
+		{
+			name:      "one byte record",
+			value:     []byte{0x00},
+			recordLen: 1,
+			expErr:    ErrLocalNoncesRecordTooShort,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := DecodeLocalNoncesWith(
+				bytes.NewReader(tc.value), tc.recordLen,
+				LocalNoncesEncodingLenPrefixed,
+			)
+			require.ErrorIs(t, err, tc.expErr)
+		})
+	}
+}
+
+// TestLocalNoncesEncodingHeaderChecks tests that every encoding rejects a
+// count above MaxLocalNonces, and reports the offset of a truncated entry.
+func TestLocalNoncesEncodingHeaderChecks(t *testing.T) {
+	t.Parallel()
+
+	nonces := &LocalNoncesData{
+		NoncesMap: map[chainhash.Hash]Musig2Nonce{
+			makeTestTxId(1): makeTestEvenNonce(3),
+			makeTestTxId(2): makeTestEvenNonce(4),
+		},
+	}
+
+	testCases := []struct {
+		encoding LocalNoncesEncoding
+		layout   localNoncesLayout
+	}{
+		{LocalNoncesEncodingFixed, fixedLocalNoncesLayout},
+		{LocalNoncesEncodingLenPrefixed, localNoncesLenPrefixedLayout},
+		{LocalNoncesEncodingXOnly, localNoncesXOnlyLayout},
+		{LocalNoncesEncodingRoleTagged, localNoncesRoleTaggedLayout},
+		{
+			LocalNoncesEncodingPrefixCompressed,
+			localNoncesPrefixCompressedLayout,
+		},
+	}
+
+	for _, tc := range testCases {
+		encoding := tc.encoding
+		t.Run(encoding.String(), func(t *testing.T) {
+			t.Parallel()
+
+			// A count above the max is rejected even if the record
+			// is long enough to hold that many entries.
+			numEntries := uint64(MaxLocalNonces + 1)
+			tooMany := make(
+				[]byte, localNoncesCountSize+
+					numEntries*tc.layout.entrySize,
+			)
+			binary.BigEndian.PutUint16(
+				tooMany, uint16(numEntries),
+			)
+
+			_, err := DecodeLocalNoncesWith(
+				bytes.NewReader(tooMany),
+				uint64(len(tooMany)), encoding,
+			)
+			require.ErrorIs(t, err, ErrTooManyLocalNonces)
+
+			var decodeErr *LocalNoncesDecodeError
+			require.ErrorAs(t, err, &decodeErr)
+			require.Zero(t, decodeErr.Offset)
+
+			// A record that's cut short points at the first
+			// missing byte, whether or not the reader knows how
+			// many bytes it has left.
+			valid := encodeTestLocalNoncesWith(
+				t, nonces, encoding,
+			)
+			truncated := valid[:len(valid)-1]
+			readers := []io.Reader{
+				bytes.NewReader(truncated),
+				io.MultiReader(bytes.NewReader(truncated)),
+			}
+			for _, r := range readers {
+				_, err := DecodeLocalNoncesWith(
+					r, uint64(len(valid)), encoding,
+				)
+				require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+				require.ErrorAs(t, err, &decodeErr)
+				require.EqualValues(
+					t, len(truncated), decodeErr.Offset,
+				)
+			}
+		})
+	}
+}
+
+// TestLocalNoncesEncodeWithMaxEntries tests that a set of more than
+// MaxLocalNonces entries is rejected by every encoding before anything is
+// written.
+func TestLocalNoncesEncodeWithMaxEntries(t *testing.T) {
+	t.Parallel()
+
+	r := rand.New(rand.NewSource(42))
+	tooMany := makeRandomLocalNonces(t, r, MaxLocalNonces+1)
+
+	encodings := []LocalNoncesEncoding{
+		LocalNoncesEncodingFixed,
+		LocalNoncesEncodingLenPrefixed,
+		LocalNoncesEncodingXOnly,
+		LocalNoncesEncodingRoleTagged,
+		LocalNoncesEncodingPrefixCompressed,
+	}
+	for _, encoding := range encodings {
+		var b bytes.Buffer
+		err := tooMany.EncodeWith(&b, encoding)
+		require.ErrorIs(t, err, ErrTooManyLocalNonces, encoding)
+		require.Zero(t, b.Len(), encoding)
+	}
+}
+
+// TestLocalNoncesUnknownEncoding tests that an unknown encoding is rejected
+// on both encode and decode.
+func TestLocalNoncesUnknownEncoding(t *testing.T) {
+	t.Parallel()
+
+	unknown := LocalNoncesEncoding(0xff)
+
+	var b bytes.Buffer
+	err := makeTestLocalNonces(1).EncodeWith(&b, unknown)
+	require.ErrorIs(t, err, ErrUnknownLocalNoncesEncoding)
+
+	_, err = DecodeLocalNoncesWith(bytes.NewReader(nil), 0, unknown)
+	require.ErrorIs(t, err, ErrUnknownLocalNoncesEncoding)
+}
+
+// makeTestEvenNonce returns a nonce whose two points both carry the even
+// compressed point prefix.
+func makeTestEvenNonce(b byte) Musig2Nonce {
+	nonce := makeTestNonce(b)
+	nonce[0] = compressedPointEven
+	nonce[musig2.PubNonceSize/2] = compressedPointEven
+
+	return nonce
+}
+
+// TestLocalNoncesXOnlyEncoding tests that nonces with even points can be
+// round-tripped through the x-only encoding, and that the full points are
+// reconstructed on decode.
+func TestLocalNoncesXOnlyEncoding(t *testing.T) {
+	t.Parallel()
+
+	for _, n := range []int{0, 1, 5} {
+		nonces := &LocalNoncesData{
+			NoncesMap: make(map[chainhash.Hash]Musig2Nonce, n),
+		}
+		for i := 1; i <= n; i++ {
+			txid := makeTestTxId(byte(i))
+			nonces.NoncesMap[txid] = makeTestEvenNonce(byte(n + i))
+		}
+
+		encoded := encodeTestLocalNoncesWith(
+			t, nonces, LocalNoncesEncodingXOnly,
+		)
+		require.Len(
+			t, encoded,
+			localNoncesCountSize+n*(localNonceEntrySize-2),
+		)
+
+		decoded, err := DecodeLocalNoncesWith(
+			bytes.NewReader(encoded), uint64(len(encoded)),
+			LocalNoncesEncodingXOnly,
+		)
+		require.NoError(t, err)
+		require.Equal(t, nonces.NoncesMap, decoded.NoncesMap)
+		requireStrictlyAscending(t, decoded.SortedEntries())
+	}
+}
+
+// TestLocalNoncesXOnlyEncodeFailures tests that nonces with a point that
+// doesn't carry the even prefix are rejected without writing anything.
+func TestLocalNoncesXOnlyEncodeFailures(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name     string
+		prefixes [2]byte
+	}{
+		{
+			name:     "odd first point",
+			prefixes: [2]byte{0x03, compressedPointEven},
+		},
+		{
+			name:     "odd second point",
+			prefixes: [2]byte{compressedPointEven, 0x03},
+		},
+		{
+			name:     "invalid prefix",
+			prefixes: [2]byte{0x04, compressedPointEven},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			nonce := makeTestEvenNonce(1)
+			nonce[0] = tc.prefixes[0]
+			nonce[musig2.PubNonceSize/2] = tc.prefixes[1]
+
+			nonces := &LocalNoncesData{
+				NoncesMap: map[chainhash.Hash]Musig2Nonce{
+					makeTestTxId(1): makeTestEvenNonce(2),
+					makeTestTxId(2): nonce,
+				},
+			}
+
+			var b bytes.Buffer
+			err := nonces.EncodeWith(&b, LocalNoncesEncodingXOnly)
+			require.ErrorIs(t, err, ErrLocalNonceNotXOnly)
+			require.Zero(t, b.Len())
+		})
+	}
+}
+
+// TestLocalNoncesXOnlyDecodeFailures tests that malformed x-only encodings are
+// rejected.
+func TestLocalNoncesXOnlyDecodeFailures(t *testing.T) {
+	t.Parallel()
+
+	nonces := SingleLocalNonce(makeTestTxId(1), makeTestEvenNonce(1))
+	valid := encodeTestLocalNoncesWith(t, nonces, LocalNoncesEncodingXOnly)
+
+	// A record using the fixed encoding has the wrong length.
+	fixed := encodeTestLocalNonces(t, nonces)
+	_, err := DecodeLocalNoncesWith(
+		bytes.NewReader(fixed), uint64(len(fixed)),
+		LocalNoncesEncodingXOnly,
+	)
+	require.ErrorIs(t, err, ErrLocalNoncesLengthMismatch)
+
+	// So does a truncated record.
+	_, err = DecodeLocalNoncesWith(
+		bytes.NewReader(valid), uint64(len(valid))-1,
+		LocalNoncesEncodingXOnly,
+	)
+	require.ErrorIs(t, err, ErrLocalNoncesLengthMismatch)
+
+	_, err = DecodeLocalNoncesWith(
+		bytes.NewReader([]byte{0x00}), 1, LocalNoncesEncodingXOnly,
+	)
+	require.ErrorIs(t, err, ErrLocalNoncesRecordTooShort)
+}
+
+// TestLocalNoncesRoleTaggedEncoding tests that a set with a mix of roles can
+// be round-tripped through the role tagged encoding, while the default
+// encoding ignores the roles.
+func TestLocalNoncesRoleTaggedEncoding(t *testing.T) {
+	t.Parallel()
+
+	// A role we don't know of yet must survive the round trip.
+	unknownRole := LocalNonceRole(0x7f)
+
+	roles := []LocalNonceRole{
+		LocalNonceRoleFunding, LocalNonceRoleClosing, unknownRole,
+	}
+
+	nonces := makeTestLocalNonces(1)
+	for i, role := range roles {
+		id := byte(i + 2)
+		nonces.AddTagged(makeTestTxId(id), makeTestNonce(id), role)
+	}
+
+	encoded := encodeTestLocalNoncesWith(
+		t, nonces, LocalNoncesEncodingRoleTagged,
+	)
+	expectedLen := localNoncesCountSize + 4*localNonceRoleTaggedEntrySize
+	require.Len(t, encoded, expectedLen)
+
+	decoded, err := DecodeLocalNoncesWith(
+		bytes.NewReader(encoded), uint64(len(encoded)),
+		LocalNoncesEncodingRoleTagged,
+	)
+	require.NoError(t, err)
+	require.Equal(t, nonces.NoncesMap, decoded.NoncesMap)
+
+	expectedRoles := map[chainhash.Hash]LocalNonceRole{
+		makeTestTxId(1): LocalNonceRoleUnspecified,
+		makeTestTxId(2): LocalNonceRoleFunding,
+		makeTestTxId(3): LocalNonceRoleClosing,
+		makeTestTxId(4): unknownRole,
+	}
+	for txid, role := range expectedRoles {
+		require.Equal(t, role, decoded.Role(txid))
+	}
+
+	// The default encoding is unaffected by the roles.
+	untagged := &LocalNoncesData{NoncesMap: nonces.NoncesMap}
+	require.Equal(
+		t, encodeTestLocalNonces(t, untagged),
+		encodeTestLocalNonces(t, nonces),
+	)
+
+	// A truncated record is rejected.
+	_, err = DecodeLocalNoncesWith(
+		bytes.NewReader(encoded), uint64(len(encoded))-1,
+		LocalNoncesEncodingRoleTagged,
+	)
+	require.ErrorIs(t, err, ErrLocalNoncesLengthMismatch)
+}
+
+// makeClusteredTestLocalNonces returns a set of n entries whose txids all
+// share the same long prefix.
+func makeClusteredTestLocalNonces(n int) *LocalNoncesData {
+	nonces := &LocalNoncesData{
+		NoncesMap: make(map[chainhash.Hash]Musig2Nonce, n),
+	}
+	for i := 0; i < n; i++ {
+		txid := makeTestTxId(0xaa)
+		txid[chainhash.HashSize-1] = byte(i)
+		nonces.NoncesMap[txid] = makeTestNonce(byte(i))
+	}
+
+	return nonces
+}
+
+// TestLocalNoncesPrefixCompressedEncoding tests that a set can be
+// round-tripped through the prefix compressed encoding, and that malformed
+// prefixes are rejected.
+func TestLocalNoncesPrefixCompressedEncoding(t *testing.T) {
+	t.Parallel()
+
+	r := rand.New(rand.NewSource(3))
+	sets := []*LocalNoncesData{
+		makeTestLocalNonces(0),
+		makeTestLocalNonces(1),
+		makeClusteredTestLocalNonces(10),
+		makeRandomLocalNonces(t, r, 50),
+	}
+	for _, nonces := range sets {
+		encoded := encodeTestLocalNoncesWith(
+			t, nonces, LocalNoncesEncodingPrefixCompressed,
+		)
+
+		decoded, err := DecodeLocalNoncesWith(
+			bytes.NewReader(encoded), uint64(len(encoded)),
+			LocalNoncesEncodingPrefixCompressed,
+		)
+		require.NoError(t, err)
+		require.Equal(t, nonces.NoncesMap, decoded.NoncesMap)
+		requireStrictlyAscending(t, decoded.SortedEntries())
+	}
+
+	// The first entry claiming to share a prefix is rejected, as is an
+	// entry sharing its entire txid with the previous one.
+	encoded := encodeTestLocalNoncesWith(
+		t, makeClusteredTestLocalNonces(2),
+		LocalNoncesEncodingPrefixCompressed,
+	)
+	secondEntry := localNoncesCountSize + 1 + localNonceEntrySize
+	require.EqualValues(t, chainhash.HashSize-1, encoded[secondEntry])
+
+	for _, offset := range []int{localNoncesCountSize, secondEntry} {
+		malformed := bytes.Clone(encoded)
+		malformed[offset] = chainhash.HashSize
+
+		_, err := DecodeLocalNoncesWith(
+			bytes.NewReader(malformed), uint64(len(malformed)),
+			LocalNoncesEncodingPrefixCompressed,
+		)
+		require.ErrorIs(t, err, ErrInvalidLocalNonceSharedPrefix)
+	}
+}
+
+// TestLocalNoncesBestEncoding tests that BestEncoding picks the smallest
+// candidate encoding, and that the sizes it reports match the actual
+// encodings.
+func TestLocalNoncesBestEncoding(t *testing.T) {
+	t.Parallel()
+
+	candidates := []LocalNoncesEncoding{
+		LocalNoncesEncodingFixed,
+		LocalNoncesEncodingLenPrefixed,
+		LocalNoncesEncodingXOnly,
+		LocalNoncesEncodingRoleTagged,
+		LocalNoncesEncodingPrefixCompressed,
+	}
+
+	r := rand.New(rand.NewSource(4))
+	clustered := makeClusteredTestLocalNonces(20)
+	random := makeRandomLocalNonces(t, r, 20)
+
+	// Clustered txids favor prefix compression.
+	encoding, size := clustered.BestEncoding(candidates...)
+	require.Equal(t, LocalNoncesEncodingPrefixCompressed, encoding)
+	encoded := encodeTestLocalNoncesWith(t, clustered, encoding)
+	require.Len(t, encoded, int(size))
+
+	// Random txids hardly share a prefix, and random nonces can't be
+	// x-only encoded, so the fixed encoding wins.
+	encoding, size = random.BestEncoding(candidates...)
+	require.Equal(t, LocalNoncesEncodingFixed, encoding)
+	encoded = encodeTestLocalNoncesWith(t, random, encoding)
+	require.Len(t, encoded, int(size))
+
+	// The reported sizes match the actual encodings for every candidate
+	// that can encode the set.
+	for _, nonces := range []*LocalNoncesData{clustered, random} {
+		for _, candidate := range candidates {
+			size, ok := nonces.encodedSizeWith(candidate)
+			if !ok {
+				continue
+			}
+
+			encoded := encodeTestLocalNoncesWith(
+				t, nonces, candidate,
+			)
+			require.Len(t, encoded, int(size), candidate)
+		}
+	}
+
+	// Without any usable candidate, we fall back to the fixed encoding.
+	encoding, size = random.BestEncoding(LocalNoncesEncodingXOnly)
+	require.Equal(t, LocalNoncesEncodingFixed, encoding)
+	require.EqualValues(t, random.EncodedSize(), size)
+}
+
+// TestLocalNoncesAdaptiveEncoding tests that sets favoring different
+// encodings are tagged accordingly and round-trip through the adaptive
+// encoding, while an unknown tag and an oversized set are rejected.
+func TestLocalNoncesAdaptiveEncoding(t *testing.T) {
+	t.Parallel()
+
+	evenNonces := &LocalNoncesData{
+		NoncesMap: map[chainhash.Hash]Musig2Nonce{
+			makeTestTxId(1): makeTestEvenNonce(1),
+			makeTestTxId(2): makeTestEvenNonce(2),
+		},
+	}
+
+	tests := []struct {
+		name     string
+		nonces   *LocalNoncesData
+		encoding LocalNoncesEncoding
+	}{
+		{
+			name:     "empty",
+			nonces:   makeTestLocalNonces(0),
+			encoding: LocalNoncesEncodingFixed,
+		},
+		{
+			name: "random",
+			nonces: makeRandomLocalNonces(
+				t, rand.New(rand.NewSource(5)), 10,
+			),
+			encoding: LocalNoncesEncodingFixed,
+		},
+		{
+			name:     "even nonces",
+			nonces:   evenNonces,
+			encoding: LocalNoncesEncodingXOnly,
+		},
+		{
+			name:     "clustered txids",
+			nonces:   makeClusteredTestLocalNonces(10),
+			encoding: LocalNoncesEncodingPrefixCompressed,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var b bytes.Buffer
+			require.NoError(t, tc.nonces.EncodeAdaptive(&b))
+			require.EqualValues(t, tc.encoding, b.Bytes()[0])
+
+			decoded, err := DecodeLocalNoncesAdaptive(
+				&b, uint64(b.Len()),
+			)
+			require.NoError(t, err)
+			require.Equal(t, tc.nonces.NoncesMap, decoded.NoncesMap)
+		})
+	}
+
+	// An unknown tag is rejected, as is a record without any tag.
+	encoded := []byte{0xff, 0x00, 0x00}
+	_, err := DecodeLocalNoncesAdaptive(
+		bytes.NewReader(encoded), uint64(len(encoded)),
+	)
+	require.ErrorIs(t, err, ErrUnknownLocalNoncesEncoding)
+
+	_, err = DecodeLocalNoncesAdaptive(bytes.NewReader(nil), 0)
+	require.ErrorIs(t, err, ErrLocalNoncesRecordTooShort)
+
+	// A set of more than MaxLocalNonces entries can't be encoded, as no
+	// encoding would be read back, so not even the tag is written. The
+	// txids are clustered so the prefix compressed encoding would be
+	// picked otherwise.
+	tooMany := &LocalNoncesData{
+		NoncesMap: make(
+			map[chainhash.Hash]Musig2Nonce, MaxLocalNonces+1,
+		),
+	}
+	for i := range MaxLocalNonces + 1 {
+		txid := makeTestTxId(0xaa)
+		binary.BigEndian.PutUint16(
+			txid[chainhash.HashSize-2:], uint16(i),
+		)
+		tooMany.NoncesMap[txid] = makeTestNonce(byte(i))
+	}
+
+	var b bytes.Buffer
+	err = tooMany.EncodeAdaptive(&b)
+	require.ErrorIs(t, err, ErrTooManyLocalNonces)
+	require.Zero(t, b.Len())
+}
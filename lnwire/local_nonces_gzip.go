@@ -0,0 +1,63 @@
+package lnwire
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+)
+
+// EncodeGzip writes the standalone encoding of the set, as written by Encode,
+// to w as a gzip stream. This is a helper for persisting large sets at rest,
+// and must never be used on the wire. As nonces are essentially random, the
+// savings mostly stem from clustered txids and the framing.
+func (lnd *LocalNoncesData) EncodeGzip(w io.Writer) error {
+	zw := gzip.NewWriter(w)
+	if err := lnd.Encode(zw); err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
+
+// DecodeGzip reads a set written by EncodeGzip from r, replacing the contents
+// of lnd. The whole gzip stream is consumed, so that its checksum is
+// verified, and the stream must not hold anything beyond the set. On failure,
+// lnd is left untouched.
+func (lnd *LocalNoncesData) DecodeGzip(r io.Reader) error {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	var decoded LocalNoncesData
+	if err := decoded.Decode(zr); err != nil {
+		return err
+	}
+
+	// The decoded set is charged against the budget, which must be given
+	// back unless the set is handed over to lnd.
+	handedOver := false
+	defer func() {
+		if !handedOver {
+			decoded.ReleaseBudget()
+		}
+	}()
+
+	// The checksum is only verified once the end of the stream is read.
+	trailing, err := io.Copy(io.Discard, zr)
+	switch {
+	case err != nil:
+		return err
+
+	case trailing != 0:
+		return fmt.Errorf("%w: %d trailing bytes",
+			ErrLocalNoncesLengthMismatch, trailing)
+	}
+
+	lnd.ReleaseBudget()
+	*lnd = decoded
+	handedOver = true
+
+	return nil
+}
@@ -0,0 +1,130 @@
+package lnwire
+
+import (
+	"bytes"
+	"compress/gzip"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestLocalNoncesGzipRoundTrip tests that a set can be round-tripped through
+// the gzip helpers.
+func TestLocalNoncesGzipRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	for _, n := range []int{0, 1, 50} {
+		nonces := makeClusteredTestLocalNonces(n)
+
+		var b bytes.Buffer
+		require.NoError(t, nonces.EncodeGzip(&b))
+
+		var decoded LocalNoncesData
+		require.NoError(t, decoded.DecodeGzip(&b))
+		require.Equal(t, nonces.NoncesMap, decoded.NoncesMap)
+		requireStrictlyAscending(t, decoded.SortedEntries())
+	}
+}
+
+// TestLocalNoncesGzipCorrupt tests that corrupt gzip streams are rejected,
+// leaving the target untouched.
+func TestLocalNoncesGzipCorrupt(t *testing.T) {
+	t.Parallel()
+
+	var b bytes.Buffer
+	require.NoError(t, makeClusteredTestLocalNonces(20).EncodeGzip(&b))
+	encoded := b.Bytes()
+
+	// Flipping a bit of the trailing CRC-32 is only detected once the
+	// whole stream is read.
+	badChecksum := bytes.Clone(encoded)
+	badChecksum[len(badChecksum)-8] ^= 0x01
+
+	// A valid gzip stream holding more than just the set.
+	var trailing bytes.Buffer
+	zw := gzip.NewWriter(&trailing)
+	require.NoError(t, makeTestLocalNonces(2).Encode(zw))
+	_, err := zw.Write([]byte{0x00})
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+
+	tests := []struct {
+		name    string
+		encoded []byte
+		err     error
+	}{
+		{
+			name:    "bad header",
+			encoded: append([]byte{0x00}, encoded[1:]...),
+			err:     gzip.ErrHeader,
+		},
+		{
+			name:    "bad checksum",
+			encoded: badChecksum,
+			err:     gzip.ErrChecksum,
+		},
+		{
+			name:    "trailing data",
+			encoded: trailing.Bytes(),
+			err:     ErrLocalNoncesLengthMismatch,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			existing := makeTestLocalNonces(1)
+			err := existing.DecodeGzip(bytes.NewReader(tc.encoded))
+			require.ErrorIs(t, err, tc.err)
+			require.Equal(t, makeTestLocalNonces(1), existing)
+		})
+	}
+
+	// So is a truncated stream.
+	var decoded LocalNoncesData
+	err = decoded.DecodeGzip(bytes.NewReader(encoded[:len(encoded)/2]))
+	require.Error(t, err)
+}
+
+// TestLocalNoncesGzipBudget tests that a gzip stream that's rejected after
+// the set it holds was decoded gives back the room the set was charged.
+//
+// NOTE: This test must not run in parallel, as the budget is global.
+func TestLocalNoncesGzipBudget(t *testing.T) {
+	SetLocalNoncesBudget(MaxLocalNonces)
+	t.Cleanup(func() {
+		SetLocalNoncesBudget(0)
+	})
+
+	var b bytes.Buffer
+	require.NoError(t, makeTestLocalNonces(3).EncodeGzip(&b))
+	encoded := b.Bytes()
+
+	// Both a bad checksum and trailing data are only detected once the
+	// set was decoded.
+	badChecksum := bytes.Clone(encoded)
+	badChecksum[len(badChecksum)-8] ^= 0x01
+
+	var trailing bytes.Buffer
+	zw := gzip.NewWriter(&trailing)
+	require.NoError(t, makeTestLocalNonces(3).Encode(zw))
+	_, err := zw.Write([]byte{0x00})
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+
+	inFlight := localNoncesInFlight.Load()
+	for _, bad := range [][]byte{badChecksum, trailing.Bytes()} {
+		var decoded LocalNoncesData
+		require.Error(t, decoded.DecodeGzip(bytes.NewReader(bad)))
+		require.Equal(t, inFlight, localNoncesInFlight.Load())
+	}
+
+	// A set that's handed over stays charged until it's released.
+	var decoded LocalNoncesData
+	require.NoError(t, decoded.DecodeGzip(bytes.NewReader(encoded)))
+	require.Equal(t, inFlight+3, localNoncesInFlight.Load())
+
+	decoded.ReleaseBudget()
+	require.Equal(t, inFlight, localNoncesInFlight.Load())
+}
@@ -0,0 +1,56 @@
+package lnwire
+
+import (
+	"io"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+// InternedLocalNonceEntry is a single entry of a decoded record whose nonce
+// is shared with every other entry of the same record holding an identical
+// nonce.
+type InternedLocalNonceEntry struct {
+	// TXID is the txid of the transaction the nonce is used to sign.
+	TXID chainhash.Hash
+
+	// Nonce points to the local musig2 public nonce, which must not be
+	// modified, as it may be shared with other entries.
+	Nonce *Musig2Nonce
+}
+
+// DecodeLocalNoncesInterned decodes a record of recordLen bytes read from r
+// into entries in ascending txid order, where entries with identical nonces
+// point to a single shared copy of the nonce. The same malformed records are
+// rejected as by DecodeLocalNoncesAsEntries.
+//
+// This is a niche memory optimization. As a Musig2Nonce is stored by value in
+// a map or slice, interning only pays off for callers that hold on to the
+// nonces by pointer anyway, and only for records that repeat the same nonce
+// under many txids, which a conforming peer never does.
+func DecodeLocalNoncesInterned(r io.Reader,
+	recordLen uint64) ([]InternedLocalNonceEntry, error) {
+
+	entries, err := DecodeLocalNoncesAsEntries(r, recordLen)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		interned = make([]InternedLocalNonceEntry, len(entries))
+		pool     = make(map[Musig2Nonce]*Musig2Nonce)
+	)
+	for i, entry := range entries {
+		nonce, ok := pool[entry.Nonce]
+		if !ok {
+			nonce = &entry.Nonce
+			pool[entry.Nonce] = nonce
+		}
+
+		interned[i] = InternedLocalNonceEntry{
+			TXID:  entry.TXID,
+			Nonce: nonce,
+		}
+	}
+
+	return interned, nil
+}
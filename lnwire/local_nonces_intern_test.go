@@ -0,0 +1,50 @@
+package lnwire
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/stretchr/testify/require"
+)
+
+// TestDecodeLocalNoncesInterned tests that entries with identical nonces share
+// a single copy of the nonce, while the decoded values stay correct.
+func TestDecodeLocalNoncesInterned(t *testing.T) {
+	t.Parallel()
+
+	// The txids 1 and 3 share a nonce, as do 2 and 4, while 5 has a nonce
+	// of its own.
+	nonces := &LocalNoncesData{
+		NoncesMap: map[chainhash.Hash]Musig2Nonce{
+			makeTestTxId(1): makeTestNonce(0xaa),
+			makeTestTxId(2): makeTestNonce(0xbb),
+			makeTestTxId(3): makeTestNonce(0xaa),
+			makeTestTxId(4): makeTestNonce(0xbb),
+			makeTestTxId(5): makeTestNonce(0xcc),
+		},
+	}
+	encoded := encodeTestLocalNonces(t, nonces)
+
+	entries, err := DecodeLocalNoncesInterned(
+		bytes.NewReader(encoded), uint64(len(encoded)),
+	)
+	require.NoError(t, err)
+	require.Len(t, entries, 5)
+
+	for i, entry := range nonces.SortedEntries() {
+		require.Equal(t, entry.TXID, entries[i].TXID)
+		require.Equal(t, entry.Nonce, *entries[i].Nonce)
+	}
+
+	require.Same(t, entries[0].Nonce, entries[2].Nonce)
+	require.Same(t, entries[1].Nonce, entries[3].Nonce)
+	require.NotSame(t, entries[0].Nonce, entries[1].Nonce)
+	require.NotSame(t, entries[0].Nonce, entries[4].Nonce)
+
+	// Malformed records are rejected.
+	_, err = DecodeLocalNoncesInterned(
+		bytes.NewReader(encoded), uint64(len(encoded))-1,
+	)
+	require.ErrorIs(t, err, ErrLocalNoncesLengthMismatch)
+}
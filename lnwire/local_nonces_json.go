@@ -0,0 +1,145 @@
+package lnwire
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec/v2/schnorr/musig2"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+// ErrInvalidLocalNoncesHex is returned when a hex encoded txid, nonce or
+// record can't be parsed.
+var ErrInvalidLocalNoncesHex = errors.New("invalid local nonces hex")
+
+// LocalNoncesFromHex parses the hex encoding of a complete LocalNoncesData
+// record, as produced by hex encoding the output of AppendTo. Both upper and
+// lower case hex digits are accepted.
+func LocalNoncesFromHex(s string) (*LocalNoncesData, error) {
+	if len(s)%2 != 0 {
+		return nil, fmt.Errorf("%w: odd length %d",
+			ErrInvalidLocalNoncesHex, len(s))
+	}
+
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidLocalNoncesHex, err)
+	}
+
+	return ParseLocalNoncesRecord(localNoncesRecordType, b)
+}
+
+// MarshalJSON encodes the set as a JSON object that maps the string form of
+// each txid to the hex encoded nonce. The keys are written in sorted order,
+// so the result is deterministic.
+func (lnd *LocalNoncesData) MarshalJSON() ([]byte, error) {
+	nonces := make(map[string]string, len(lnd.NoncesMap))
+	for txid, nonce := range lnd.NoncesMap {
+		nonces[txid.String()] = hex.EncodeToString(nonce[:])
+	}
+
+	return json.Marshal(nonces)
+}
+
+// UnmarshalJSON decodes a JSON object produced by MarshalJSON, replacing the
+// current entries of the set. Both upper and lower case hex digits are
+// accepted for txids and nonces alike, as different tools emit different
+// casing. Just like for the wire decoder, no more than MaxLocalNonces entries
+// are accepted, and any local metadata of the previous entries is dropped. On
+// failure, the set is left untouched.
+func (lnd *LocalNoncesData) UnmarshalJSON(b []byte) error {
+	var encoded map[string]string
+	if err := json.Unmarshal(b, &encoded); err != nil {
+		return err
+	}
+
+	if len(encoded) > MaxLocalNonces {
+		return fmt.Errorf("%w: %d entries, max is %d",
+			ErrTooManyLocalNonces, len(encoded), MaxLocalNonces)
+	}
+
+	nonces := make(map[chainhash.Hash]Musig2Nonce, len(encoded))
+	for txidStr, nonceStr := range encoded {
+		txid, err := parseLocalNonceTxidHex(txidStr)
+		if err != nil {
+			return err
+		}
+
+		nonce, err := parseLocalNonceHex(nonceStr)
+		if err != nil {
+			return fmt.Errorf("txid %v: %w", txid, err)
+		}
+
+		// Keys that only differ in casing name the same txid.
+		if _, ok := nonces[txid]; ok {
+			return fmt.Errorf("%w: %v", ErrLocalNoncesDuplicateTxid,
+				txid)
+		}
+		nonces[txid] = nonce
+	}
+
+	// The previous entries are replaced, so whatever they were charged
+	// is given back.
+	lnd.ReleaseBudget()
+
+	lnd.NoncesMap = nonces
+	lnd.sources = nil
+	lnd.roles = nil
+	lnd.createdAt = nil
+
+	return nil
+}
+
+// parseLocalNonceTxidHex parses the string form of a txid, which must be
+// exactly chainhash.MaxHashStringSize hex digits long.
+func parseLocalNonceTxidHex(s string) (chainhash.Hash, error) {
+	if err := checkLocalNoncesHexLen(
+		s, chainhash.MaxHashStringSize,
+	); err != nil {
+		return chainhash.Hash{}, fmt.Errorf("txid: %w", err)
+	}
+
+	txid, err := chainhash.NewHashFromStr(s)
+	if err != nil {
+		return chainhash.Hash{}, fmt.Errorf("%w: txid: %w",
+			ErrInvalidLocalNoncesHex, err)
+	}
+
+	return *txid, nil
+}
+
+// parseLocalNonceHex parses a hex encoded nonce, which must be exactly
+// musig2.PubNonceSize bytes long.
+func parseLocalNonceHex(s string) (Musig2Nonce, error) {
+	var nonce Musig2Nonce
+	if err := checkLocalNoncesHexLen(
+		s, hex.EncodedLen(musig2.PubNonceSize),
+	); err != nil {
+		return nonce, fmt.Errorf("nonce: %w", err)
+	}
+
+	if _, err := hex.Decode(nonce[:], []byte(s)); err != nil {
+		return nonce, fmt.Errorf("%w: nonce: %w",
+			ErrInvalidLocalNoncesHex, err)
+	}
+
+	return nonce, nil
+}
+
+// checkLocalNoncesHexLen checks that s holds exactly the expected number of
+// hex digits.
+func checkLocalNoncesHexLen(s string, expected int) error {
+	switch {
+	case len(s)%2 != 0:
+		return fmt.Errorf("%w: odd length %d", ErrInvalidLocalNoncesHex,
+			len(s))
+
+	case len(s) != expected:
+		return fmt.Errorf("%w: expected %d hex digits, got %d",
+			ErrInvalidLocalNoncesHex, expected, len(s))
+	}
+
+	return nil
+}
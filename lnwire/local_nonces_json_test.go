@@ -0,0 +1,248 @@
+package lnwire
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/stretchr/testify/require"
+)
+
+// TestLocalNoncesJSON tests that a set can be round-tripped through JSON.
+func TestLocalNoncesJSON(t *testing.T) {
+	t.Parallel()
+
+	nonces := makeTestLocalNonces(3)
+
+	// Use a txid that differs from its byte reversal, so the string form
+	// is actually exercised.
+	var txid chainhash.Hash
+	for i := range txid {
+		txid[i] = byte(i)
+	}
+	nonces.NoncesMap[txid] = makeTestNonce(0xab)
+
+	encoded, err := json.Marshal(nonces)
+	require.NoError(t, err)
+
+	var decoded LocalNoncesData
+	require.NoError(t, json.Unmarshal(encoded, &decoded))
+	require.Equal(t, nonces.NoncesMap, decoded.NoncesMap)
+
+	// The encoding is deterministic.
+	again, err := json.Marshal(&decoded)
+	require.NoError(t, err)
+	require.Equal(t, encoded, again)
+}
+
+// TestLocalNoncesJSONMixedCase tests that upper and lower case hex parse
+// identically.
+func TestLocalNoncesJSONMixedCase(t *testing.T) {
+	t.Parallel()
+
+	txid := makeTestTxId(0xab)
+	nonce := makeTestNonce(0xcd)
+	expected := SingleLocalNonce(txid, nonce).NoncesMap
+
+	txidHex := txid.String()
+	nonceHex := hex.EncodeToString(nonce[:])
+
+	testCases := []struct {
+		name  string
+		txid  string
+		nonce string
+	}{
+		{
+			name:  "lower case",
+			txid:  txidHex,
+			nonce: nonceHex,
+		},
+		{
+			name:  "upper case txid, lower case nonce",
+			txid:  strings.ToUpper(txidHex),
+			nonce: nonceHex,
+		},
+		{
+			name:  "upper case",
+			txid:  strings.ToUpper(txidHex),
+			nonce: strings.ToUpper(nonceHex),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			obj := fmt.Sprintf(`{%q: %q}`, tc.txid, tc.nonce)
+
+			var decoded LocalNoncesData
+			err := json.Unmarshal([]byte(obj), &decoded)
+			require.NoError(t, err)
+			require.Equal(t, expected, decoded.NoncesMap)
+		})
+	}
+
+	// Keys that only differ in casing are the same txid.
+	obj := fmt.Sprintf(
+		`{%q: %q, %q: %q}`, txidHex, nonceHex,
+		strings.ToUpper(txidHex), nonceHex,
+	)
+	var decoded LocalNoncesData
+	err := json.Unmarshal([]byte(obj), &decoded)
+	require.ErrorIs(t, err, ErrLocalNoncesDuplicateTxid)
+}
+
+// TestLocalNoncesJSONFailures tests that malformed hex is rejected without
+// modifying the set.
+func TestLocalNoncesJSONFailures(t *testing.T) {
+	t.Parallel()
+
+	txidHex := makeTestTxId(1).String()
+	nonce := makeTestNonce(2)
+	nonceHex := hex.EncodeToString(nonce[:])
+
+	testCases := []struct {
+		name  string
+		txid  string
+		nonce string
+	}{
+		{
+			name:  "odd length txid",
+			txid:  txidHex[1:],
+			nonce: nonceHex,
+		},
+		{
+			name:  "short txid",
+			txid:  txidHex[2:],
+			nonce: nonceHex,
+		},
+		{
+			name:  "invalid txid digit",
+			txid:  "zz" + txidHex[2:],
+			nonce: nonceHex,
+		},
+		{
+			name:  "odd length nonce",
+			txid:  txidHex,
+			nonce: nonceHex + "0",
+		},
+		{
+			name:  "long nonce",
+			txid:  txidHex,
+			nonce: nonceHex + "00",
+		},
+		{
+			name:  "invalid nonce digit",
+			txid:  txidHex,
+			nonce: "0g" + nonceHex[2:],
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			obj := fmt.Sprintf(`{%q: %q}`, tc.txid, tc.nonce)
+
+			decoded := makeTestLocalNonces(1)
+			err := json.Unmarshal([]byte(obj), decoded)
+			require.ErrorIs(t, err, ErrInvalidLocalNoncesHex)
+			require.Equal(
+				t, makeTestLocalNonces(1).NoncesMap,
+				decoded.NoncesMap,
+			)
+		})
+	}
+}
+
+// TestLocalNoncesJSONReset tests that unmarshaling into a set drops the local
+// metadata of its previous entries, and that too many entries are rejected.
+func TestLocalNoncesJSONReset(t *testing.T) {
+	t.Parallel()
+
+	txid := makeTestTxId(1)
+	decoded := makeTestLocalNonces(1)
+	decoded.SetSource(txid, "peer")
+	decoded.AddTagged(txid, decoded.NoncesMap[txid], LocalNonceRoleFunding)
+	decoded.SetCreatedAt(txid, time.Unix(1_700_000_000, 0))
+
+	b, err := json.Marshal(
+		SingleLocalNonce(makeTestTxId(2), makeTestNonce(2)),
+	)
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(b, decoded))
+
+	_, ok := decoded.Source(txid)
+	require.False(t, ok)
+	require.Equal(t, LocalNonceRoleUnspecified, decoded.Role(txid))
+	_, ok = decoded.CreatedAt(txid)
+	require.False(t, ok)
+
+	// Re-adding the txid doesn't bring back its stale metadata.
+	merged := &LocalNoncesData{}
+	require.NoError(t, merged.Merge(decoded))
+	require.NoError(t, merged.Merge(makeTestLocalNonces(1)))
+	_, ok = merged.Source(txid)
+	require.False(t, ok)
+
+	// A set that the encoder would refuse can't be unmarshaled either.
+	oversized, err := json.Marshal(makeRandomLocalNonces(
+		t, rand.New(rand.NewSource(9)), MaxLocalNonces+1,
+	))
+	require.NoError(t, err)
+
+	existing := makeTestLocalNonces(1)
+	err = json.Unmarshal(oversized, existing)
+	require.ErrorIs(t, err, ErrTooManyLocalNonces)
+	require.Equal(t, makeTestLocalNonces(1), existing)
+}
+
+// TestLocalNoncesJSONReleasesBudget tests that unmarshaling into a set gives
+// back the room its previous entries were charged.
+//
+// NOTE: This test must not run in parallel, as the budget is global.
+func TestLocalNoncesJSONReleasesBudget(t *testing.T) {
+	SetLocalNoncesBudget(MaxLocalNonces)
+	t.Cleanup(func() {
+		SetLocalNoncesBudget(0)
+	})
+
+	encoded := encodeTestLocalNonces(t, makeTestLocalNonces(3))
+	decoded, err := ParseLocalNoncesRecord(localNoncesRecordType, encoded)
+	require.NoError(t, err)
+	require.EqualValues(t, 3, localNoncesInFlight.Load())
+
+	b, err := json.Marshal(makeTestLocalNonces(2))
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal(b, decoded))
+	require.Zero(t, localNoncesInFlight.Load())
+}
+
+// TestLocalNoncesFromHex tests that the hex encoding of a record is parsed in
+// any casing.
+func TestLocalNoncesFromHex(t *testing.T) {
+	t.Parallel()
+
+	nonces := makeTestLocalNonces(2)
+	encoded := hex.EncodeToString(encodeTestLocalNonces(t, nonces))
+
+	for _, s := range []string{encoded, strings.ToUpper(encoded)} {
+		decoded, err := LocalNoncesFromHex(s)
+		require.NoError(t, err)
+		require.Equal(t, nonces.NoncesMap, decoded.NoncesMap)
+	}
+
+	_, err := LocalNoncesFromHex(encoded[1:])
+	require.ErrorIs(t, err, ErrInvalidLocalNoncesHex)
+
+	_, err = LocalNoncesFromHex("zz" + encoded[2:])
+	require.ErrorIs(t, err, ErrInvalidLocalNoncesHex)
+
+	_, err = LocalNoncesFromHex(encoded[:len(encoded)-2])
+	require.ErrorIs(t, err, ErrLocalNoncesLengthMismatch)
+}
@@ -0,0 +1,89 @@
+package lnwire
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec/v2/schnorr/musig2"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+// ErrMissingLocalPubNonce is returned when a signing session doesn't have a
+// public nonce yet.
+var ErrMissingLocalPubNonce = errors.New("signing session has no public " +
+	"nonce")
+
+// ErrNoLocalNoncesToAggregate is returned when an aggregate nonce is requested
+// for an empty list of txids.
+var ErrNoLocalNoncesToAggregate = errors.New("no local nonces to aggregate")
+
+// LocalNonceSession pairs the txid of a transaction with the nonces of the
+// musig2 signing session that'll be used to sign it.
+type LocalNonceSession struct {
+	// TXID is the txid of the transaction being signed.
+	TXID chainhash.Hash
+
+	// Nonces are the nonces of the signing session. Only the public nonce
+	// is used, the secret nonce is never touched.
+	Nonces *musig2.Nonces
+}
+
+// LocalNoncesFromSessions builds a set from the public nonces of the given
+// signing sessions. A session without nonces, or with an all zero public
+// nonce, hasn't generated its nonces yet and results in
+// ErrMissingLocalPubNonce. A txid that shows up more than once results in
+// ErrLocalNoncesDuplicateTxid.
+func LocalNoncesFromSessions(
+	sessions []LocalNonceSession) (*LocalNoncesData, error) {
+
+	nonces := make(map[chainhash.Hash]Musig2Nonce, len(sessions))
+	for _, session := range sessions {
+		if session.Nonces == nil ||
+			session.Nonces.PubNonce == [musig2.PubNonceSize]byte{} {
+
+			return nil, fmt.Errorf("%w: txid %v",
+				ErrMissingLocalPubNonce, session.TXID)
+		}
+
+		if _, ok := nonces[session.TXID]; ok {
+			return nil, fmt.Errorf("%w: %v",
+				ErrLocalNoncesDuplicateTxid, session.TXID)
+		}
+
+		nonces[session.TXID] = session.Nonces.PubNonce
+	}
+
+	return &LocalNoncesData{NoncesMap: nonces}, nil
+}
+
+// AggregateNonce gathers the nonces of the given txids in order, and combines
+// them into the aggregate nonce of a musig2 signing session. Every txid must
+// be part of the set, otherwise ErrLocalNonceNotFound is returned. The
+// aggregate is a public nonce just like the ones it's made of, so unlike
+// musig2.Nonces it has no secret counterpart.
+func (lnd *LocalNoncesData) AggregateNonce(
+	order []chainhash.Hash) (Musig2Nonce, error) {
+
+	if len(order) == 0 {
+		return Musig2Nonce{}, ErrNoLocalNoncesToAggregate
+	}
+
+	pubNonces := make([][musig2.PubNonceSize]byte, 0, len(order))
+	for _, txid := range order {
+		nonce, ok := lnd.Get(txid)
+		if !ok {
+			return Musig2Nonce{}, fmt.Errorf("%w: %v",
+				ErrLocalNonceNotFound, txid)
+		}
+
+		pubNonces = append(pubNonces, nonce)
+	}
+
+	aggNonce, err := musig2.AggregateNonces(pubNonces)
+	if err != nil {
+		return Musig2Nonce{}, fmt.Errorf("unable to aggregate "+
+			"nonces: %w", err)
+	}
+
+	return aggNonce, nil
+}
@@ -0,0 +1,111 @@
+package lnwire
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2/schnorr/musig2"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/stretchr/testify/require"
+)
+
+// makeTestSessionNonces returns the nonces of a signing session whose public
+// nonce is makeTestNonce(b).
+func makeTestSessionNonces(b byte) *musig2.Nonces {
+	return &musig2.Nonces{
+		PubNonce: makeTestNonce(b),
+		SecNonce: [musig2.SecNonceSize]byte{0xee},
+	}
+}
+
+// TestLocalNoncesFromSessions tests that the public nonces of signing
+// sessions are extracted into a set.
+func TestLocalNoncesFromSessions(t *testing.T) {
+	t.Parallel()
+
+	nonces, err := LocalNoncesFromSessions([]LocalNonceSession{
+		{TXID: makeTestTxId(1), Nonces: makeTestSessionNonces(0xaa)},
+		{TXID: makeTestTxId(2), Nonces: makeTestSessionNonces(0xbb)},
+	})
+	require.NoError(t, err)
+	require.Equal(t, map[chainhash.Hash]Musig2Nonce{
+		makeTestTxId(1): makeTestNonce(0xaa),
+		makeTestTxId(2): makeTestNonce(0xbb),
+	}, nonces.NoncesMap)
+
+	nonces, err = LocalNoncesFromSessions(nil)
+	require.NoError(t, err)
+	require.True(t, nonces.IsEmpty())
+
+	// Sessions that haven't generated their nonces yet are rejected.
+	_, err = LocalNoncesFromSessions([]LocalNonceSession{
+		{TXID: makeTestTxId(1), Nonces: makeTestSessionNonces(0xaa)},
+		{TXID: makeTestTxId(2)},
+	})
+	require.ErrorIs(t, err, ErrMissingLocalPubNonce)
+
+	_, err = LocalNoncesFromSessions([]LocalNonceSession{
+		{TXID: makeTestTxId(1), Nonces: &musig2.Nonces{}},
+	})
+	require.ErrorIs(t, err, ErrMissingLocalPubNonce)
+
+	// As is a txid with more than one session.
+	_, err = LocalNoncesFromSessions([]LocalNonceSession{
+		{TXID: makeTestTxId(1), Nonces: makeTestSessionNonces(0xaa)},
+		{TXID: makeTestTxId(1), Nonces: makeTestSessionNonces(0xbb)},
+	})
+	require.ErrorIs(t, err, ErrLocalNoncesDuplicateTxid)
+}
+
+// TestLocalNoncesAggregateNonce tests that the aggregate nonce of a set of
+// txids matches the aggregate computed by the musig2 package, and that
+// missing txids are reported.
+func TestLocalNoncesAggregateNonce(t *testing.T) {
+	t.Parallel()
+
+	pubKey, err := pubkeyFromHex(
+		"0228f2af0abe322403480fb3ee172f7f1601e67d1da6cad40b54c4468d4" +
+			"8236c39",
+	)
+	require.NoError(t, err)
+
+	nonces := &LocalNoncesData{
+		NoncesMap: make(map[chainhash.Hash]Musig2Nonce),
+	}
+	for i := byte(1); i <= 3; i++ {
+		sessionNonces, err := musig2.GenNonces(
+			musig2.WithPublicKey(pubKey),
+		)
+		require.NoError(t, err)
+
+		nonces.NoncesMap[makeTestTxId(i)] = sessionNonces.PubNonce
+	}
+
+	order := []chainhash.Hash{
+		makeTestTxId(2), makeTestTxId(3), makeTestTxId(1),
+	}
+	aggNonce, err := nonces.AggregateNonce(order)
+	require.NoError(t, err)
+
+	expected, err := musig2.AggregateNonces([][musig2.PubNonceSize]byte{
+		nonces.NoncesMap[makeTestTxId(2)],
+		nonces.NoncesMap[makeTestTxId(3)],
+		nonces.NoncesMap[makeTestTxId(1)],
+	})
+	require.NoError(t, err)
+	require.Equal(t, Musig2Nonce(expected), aggNonce)
+
+	// The aggregate is deterministic.
+	again, err := nonces.AggregateNonce(order)
+	require.NoError(t, err)
+	require.Equal(t, aggNonce, again)
+
+	// A txid that isn't part of the set is reported.
+	_, err = nonces.AggregateNonce([]chainhash.Hash{
+		makeTestTxId(1), makeTestTxId(4),
+	})
+	require.ErrorIs(t, err, ErrLocalNonceNotFound)
+	require.ErrorContains(t, err, makeTestTxId(4).String())
+
+	_, err = nonces.AggregateNonce(nil)
+	require.ErrorIs(t, err, ErrNoLocalNoncesToAggregate)
+}
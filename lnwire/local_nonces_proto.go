@@ -0,0 +1,81 @@
+package lnwire
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+// ErrInvalidLocalNonceProtoEntry is returned when a LocalNonceProtoEntry
+// holds a txid or nonce of the wrong length.
+var ErrInvalidLocalNonceProtoEntry = errors.New("invalid local nonce proto " +
+	"entry")
+
+// LocalNonceProtoEntry is a single entry of a LocalNoncesData with plain byte
+// slices rather than array types, which can be assigned directly to the
+// fields of generated protobuf messages.
+type LocalNonceProtoEntry struct {
+	// TXID is the txid of the transaction the nonce is used to sign.
+	TXID []byte
+
+	// Nonce is the local musig2 public nonce.
+	Nonce []byte
+}
+
+// ToProtoEntries returns the entries of the set in ascending txid order, in a
+// form that bridges the set to the RPC layer without having to import any
+// protobuf packages here. The byte slices are copies, so they can be handed
+// out freely.
+func (lnd *LocalNoncesData) ToProtoEntries() []LocalNonceProtoEntry {
+	entries := make([]LocalNonceProtoEntry, 0, lnd.Len())
+	for _, txid := range lnd.sortedTxids() {
+		nonce := lnd.NoncesMap[txid]
+		entries = append(entries, LocalNonceProtoEntry{
+			TXID:  bytes.Clone(txid[:]),
+			Nonce: bytes.Clone(nonce[:]),
+		})
+	}
+
+	return entries
+}
+
+// LocalNoncesFromProtoEntries builds a set from entries received over RPC, in
+// any order. Each txid must be exactly chainhash.HashSize bytes and each
+// nonce exactly musig2.PubNonceSize bytes, and no txid may be repeated. The
+// error names the index of the first offending entry.
+func LocalNoncesFromProtoEntries(
+	entries []LocalNonceProtoEntry) (*LocalNoncesData, error) {
+
+	nonces := make(map[chainhash.Hash]Musig2Nonce, len(entries))
+	for i, entry := range entries {
+		var (
+			txid  chainhash.Hash
+			nonce Musig2Nonce
+		)
+		if len(entry.TXID) != len(txid) {
+			return nil, fmt.Errorf("%w: entry %d has a %d byte "+
+				"txid, expected %d",
+				ErrInvalidLocalNonceProtoEntry, i,
+				len(entry.TXID), len(txid))
+		}
+		if len(entry.Nonce) != len(nonce) {
+			return nil, fmt.Errorf("%w: entry %d has a %d byte "+
+				"nonce, expected %d",
+				ErrInvalidLocalNonceProtoEntry, i,
+				len(entry.Nonce), len(nonce))
+		}
+
+		copy(txid[:], entry.TXID)
+		copy(nonce[:], entry.Nonce)
+
+		if _, ok := nonces[txid]; ok {
+			return nil, fmt.Errorf("%w: entry %d repeats %v",
+				ErrLocalNoncesDuplicateTxid, i, txid)
+		}
+		nonces[txid] = nonce
+	}
+
+	return &LocalNoncesData{NoncesMap: nonces}, nil
+}
@@ -0,0 +1,100 @@
+package lnwire
+
+import (
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2/schnorr/musig2"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/stretchr/testify/require"
+)
+
+// TestLocalNoncesToProtoEntries tests that the proto entries are sorted,
+// correctly sized copies of the entries of the set.
+func TestLocalNoncesToProtoEntries(t *testing.T) {
+	t.Parallel()
+
+	nonces := makeTestLocalNonces(3)
+	entries := nonces.ToProtoEntries()
+	require.Len(t, entries, 3)
+
+	for i, entry := range nonces.SortedEntries() {
+		require.Len(t, entries[i].TXID, chainhash.HashSize)
+		require.Len(t, entries[i].Nonce, musig2.PubNonceSize)
+		require.Equal(t, entry.TXID[:], entries[i].TXID)
+		require.Equal(t, entry.Nonce[:], entries[i].Nonce)
+	}
+
+	// Mutating the copies leaves the set untouched.
+	for _, entry := range entries {
+		entry.TXID[0] ^= 0xff
+		entry.Nonce[0] ^= 0xff
+	}
+	require.Equal(t, makeTestLocalNonces(3), nonces)
+
+	require.Empty(t, (*LocalNoncesData)(nil).ToProtoEntries())
+}
+
+// TestLocalNoncesFromProtoEntries tests that a set is built from well formed
+// proto entries, while malformed or repeated ones are rejected along with
+// their index.
+func TestLocalNoncesFromProtoEntries(t *testing.T) {
+	t.Parallel()
+
+	nonces := makeTestLocalNonces(3)
+
+	// The entries don't need to be sorted.
+	entries := nonces.ToProtoEntries()
+	entries[0], entries[2] = entries[2], entries[0]
+
+	decoded, err := LocalNoncesFromProtoEntries(entries)
+	require.NoError(t, err)
+	require.Equal(t, nonces.NoncesMap, decoded.NoncesMap)
+
+	shortTxid := nonces.ToProtoEntries()
+	shortTxid[1].TXID = shortTxid[1].TXID[1:]
+
+	longNonce := nonces.ToProtoEntries()
+	longNonce[2].Nonce = append(longNonce[2].Nonce, 0x00)
+
+	duplicate := nonces.ToProtoEntries()
+	duplicate = append(duplicate, LocalNonceProtoEntry{
+		TXID:  duplicate[0].TXID,
+		Nonce: duplicate[1].Nonce,
+	})
+
+	tests := []struct {
+		name    string
+		entries []LocalNonceProtoEntry
+		err     error
+		index   string
+	}{
+		{
+			name:    "short txid",
+			entries: shortTxid,
+			err:     ErrInvalidLocalNonceProtoEntry,
+			index:   "entry 1 ",
+		},
+		{
+			name:    "long nonce",
+			entries: longNonce,
+			err:     ErrInvalidLocalNonceProtoEntry,
+			index:   "entry 2 ",
+		},
+		{
+			name:    "duplicate txid",
+			entries: duplicate,
+			err:     ErrLocalNoncesDuplicateTxid,
+			index:   "entry 3 ",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := LocalNoncesFromProtoEntries(tc.entries)
+			require.ErrorIs(t, err, tc.err)
+			require.ErrorContains(t, err, tc.index)
+		})
+	}
+}
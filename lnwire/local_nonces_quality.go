@@ -0,0 +1,122 @@
+package lnwire
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"sync/atomic"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+// LocalNoncesQuality summarizes a record received from a peer, so that a peer
+// scoring subsystem can penalize senders of malformed or sloppy records.
+type LocalNoncesQuality struct {
+	// RecordLen is the length of the record in bytes.
+	RecordLen uint64
+
+	// NumEntries is the number of entries the count of the record claims.
+	// It's zero if the record was rejected before its entries were read,
+	// such as for a length mismatch.
+	NumEntries int
+
+	// Canonical is true if the entries of the record are in strictly
+	// ascending txid order, as written by a conforming encoder.
+	Canonical bool
+
+	// DuplicateTxids is the number of entries that repeat the txid of an
+	// earlier entry.
+	DuplicateTxids int
+
+	// ZeroNonces is the number of entries with an all zero nonce.
+	ZeroNonces int
+
+	// Oversized is true if the record claims more than MaxLocalNonces
+	// entries.
+	Oversized bool
+
+	// Err is the error the record was rejected with, if any.
+	Err error
+}
+
+// localNoncesQualityHook is the hook set with SetLocalNoncesQualityHook, if
+// any.
+var localNoncesQualityHook atomic.Pointer[func(LocalNoncesQuality)]
+
+// SetLocalNoncesQualityHook sets a hook that's handed a quality summary of
+// every record decoded as part of a TLV stream, including those that are
+// rejected. The hook is called synchronously by the decoding goroutine, so it
+// must be safe for concurrent use and return quickly. A nil hook, which is
+// the default, disables the summaries, in which case decoding has no extra
+// overhead.
+//
+// NOTE: Records are only fully inspected while a hook is set, which requires
+// buffering the entries of each record before decoding them.
+func SetLocalNoncesQualityHook(hook func(LocalNoncesQuality)) {
+	if hook == nil {
+		localNoncesQualityHook.Store(nil)
+		return
+	}
+
+	localNoncesQualityHook.Store(&hook)
+}
+
+// reportLocalNoncesQuality hands the quality summary of a decoded record to
+// the hook, if one is set, after recording the error the record was rejected
+// with, if any.
+func reportLocalNoncesQuality(quality *LocalNoncesQuality, err error) {
+	hook := localNoncesQualityHook.Load()
+	if hook == nil || quality == nil {
+		return
+	}
+
+	quality.Err = err
+	quality.Oversized = errors.Is(err, ErrTooManyLocalNonces)
+
+	(*hook)(*quality)
+}
+
+// decodeLocalNoncesBodyWithQuality reads the numEntries entries that follow
+// the count of a record from r, adding each of them to nonces just like
+// decodeLocalNoncesBody does. The entries are all inspected before any of
+// them is decoded, so that the quality summary covers the entire record even
+// if it's rejected.
+func decodeLocalNoncesBodyWithQuality(r io.Reader, numEntries uint16,
+	nonces map[chainhash.Hash]Musig2Nonce,
+	quality *LocalNoncesQuality) error {
+
+	body := make([]byte, uint64(numEntries)*localNonceEntrySize)
+	if n, err := io.ReadFull(r, body); err != nil {
+		return newLocalNoncesEntryError(uint64(n), err)
+	}
+
+	var (
+		seen      = make(map[chainhash.Hash]struct{}, numEntries)
+		zeroNonce Musig2Nonce
+	)
+	for offset := 0; offset < len(body); offset += localNonceEntrySize {
+		entry := body[offset : offset+localNonceEntrySize]
+		txid := chainhash.Hash(entry[:chainhash.HashSize])
+
+		if offset > 0 {
+			prevOffset := offset - localNonceEntrySize
+			prev := body[prevOffset : prevOffset+chainhash.HashSize]
+			if bytes.Compare(prev, txid[:]) >= 0 {
+				quality.Canonical = false
+			}
+		}
+
+		if _, ok := seen[txid]; ok {
+			quality.DuplicateTxids++
+		}
+		seen[txid] = struct{}{}
+
+		if bytes.Equal(entry[chainhash.HashSize:], zeroNonce[:]) {
+			quality.ZeroNonces++
+		}
+	}
+
+	return decodeLocalNonceEntriesBulk(
+		bytes.NewReader(body), numEntries, nonces,
+	)
+}
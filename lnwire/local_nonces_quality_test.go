@@ -0,0 +1,116 @@
+package lnwire
+
+import (
+	"encoding/binary"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestLocalNoncesQualityHook tests that the quality hook is told about the
+// issues of every decoded record, including rejected ones, and that it's no
+// longer called once it's cleared.
+//
+// NOTE: This test must not run in parallel, as the hook is global.
+func TestLocalNoncesQualityHook(t *testing.T) {
+	var (
+		mtx     sync.Mutex
+		reports []LocalNoncesQuality
+	)
+	SetLocalNoncesQualityHook(func(quality LocalNoncesQuality) {
+		mtx.Lock()
+		defer mtx.Unlock()
+
+		reports = append(reports, quality)
+	})
+	t.Cleanup(func() {
+		SetLocalNoncesQualityHook(nil)
+	})
+
+	// decode parses the record and returns the single report the hook
+	// was handed for it.
+	decode := func(encoded []byte) (LocalNoncesQuality, error) {
+		mtx.Lock()
+		reports = nil
+		mtx.Unlock()
+
+		_, err := ParseLocalNoncesRecord(localNoncesRecordType, encoded)
+
+		mtx.Lock()
+		defer mtx.Unlock()
+		require.Len(t, reports, 1)
+
+		return reports[0], err
+	}
+
+	// encodeEntries writes the entries in the given order, no matter
+	// whether that's canonical.
+	encodeEntries := func(entries ...LocalNonceEntry) []byte {
+		b := binary.BigEndian.AppendUint16(nil, uint16(len(entries)))
+		for _, entry := range entries {
+			b = append(b, entry.TXID[:]...)
+			b = append(b, entry.Nonce[:]...)
+		}
+
+		return b
+	}
+	entries := makeTestLocalNonces(3).SortedEntries()
+
+	// A canonical record has nothing to report.
+	canonical := encodeEntries(entries...)
+	quality, err := decode(canonical)
+	require.NoError(t, err)
+	require.Equal(t, LocalNoncesQuality{
+		RecordLen:  uint64(len(canonical)),
+		NumEntries: 3,
+		Canonical:  true,
+	}, quality)
+
+	// A record with its entries out of order and a zero nonce is still
+	// accepted, but reported.
+	zeroNonce := LocalNonceEntry{TXID: makeTestTxId(0xff)}
+	sloppy := encodeEntries(entries[2], entries[0], zeroNonce)
+	quality, err = decode(sloppy)
+	require.NoError(t, err)
+	require.Equal(t, LocalNoncesQuality{
+		RecordLen:  uint64(len(sloppy)),
+		NumEntries: 3,
+		ZeroNonces: 1,
+	}, quality)
+
+	// Every repeated txid is counted, even though the record is rejected
+	// at the first one.
+	duplicates := encodeEntries(
+		entries[0], entries[0], entries[1], entries[1],
+	)
+	quality, err = decode(duplicates)
+	require.ErrorIs(t, err, ErrLocalNoncesDuplicateTxid)
+	require.False(t, quality.Canonical)
+	require.Equal(t, 2, quality.DuplicateTxids)
+	require.ErrorIs(t, quality.Err, ErrLocalNoncesDuplicateTxid)
+
+	// A record claiming too many entries is rejected as oversized.
+	const numOversized = MaxLocalNonces + 1
+	oversized := binary.BigEndian.AppendUint16(nil, numOversized)
+	oversized = append(
+		oversized, make([]byte, numOversized*localNonceEntrySize)...,
+	)
+	quality, err = decode(oversized)
+	require.ErrorIs(t, err, ErrTooManyLocalNonces)
+	require.True(t, quality.Oversized)
+	require.ErrorIs(t, quality.Err, ErrTooManyLocalNonces)
+
+	// Once the hook is cleared, it's no longer called.
+	SetLocalNoncesQualityHook(nil)
+	mtx.Lock()
+	reports = nil
+	mtx.Unlock()
+
+	_, err = ParseLocalNoncesRecord(localNoncesRecordType, sloppy)
+	require.NoError(t, err)
+
+	mtx.Lock()
+	defer mtx.Unlock()
+	require.Empty(t, reports)
+}
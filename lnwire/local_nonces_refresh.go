@@ -0,0 +1,51 @@
+package lnwire
+
+import (
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+// SetCreatedAt records when the nonce of the entry of the given txid was
+// created, so that NeedingRefresh can tell when it has grown stale. Creation
+// times are never encoded. Just like source labels, they're carried along by
+// Merge and Apply, and dropped once their entry is removed by Apply, the set
+// is zeroized, or it is decoded into.
+func (lnd *LocalNoncesData) SetCreatedAt(txid chainhash.Hash,
+	created time.Time) {
+
+	if lnd.createdAt == nil {
+		lnd.createdAt = make(map[chainhash.Hash]time.Time)
+	}
+	lnd.createdAt[txid] = created
+}
+
+// CreatedAt returns the creation time of the nonce of the given txid, along
+// with a bool that indicates whether one was recorded at all.
+func (lnd *LocalNoncesData) CreatedAt(txid chainhash.Hash) (time.Time, bool) {
+	if lnd == nil {
+		return time.Time{}, false
+	}
+
+	created, ok := lnd.createdAt[txid]
+
+	return created, ok
+}
+
+// NeedingRefresh returns the txids, in ascending order, of all entries whose
+// nonce was created more than maxAge before now, and should therefore be
+// replaced with a fresh one. To be on the safe side, entries without a
+// recorded creation time are considered stale as well.
+func (lnd *LocalNoncesData) NeedingRefresh(now time.Time,
+	maxAge time.Duration) []chainhash.Hash {
+
+	var stale []chainhash.Hash
+	for _, txid := range lnd.sortedTxids() {
+		created, ok := lnd.CreatedAt(txid)
+		if !ok || now.Sub(created) > maxAge {
+			stale = append(stale, txid)
+		}
+	}
+
+	return stale
+}
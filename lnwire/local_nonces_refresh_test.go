@@ -0,0 +1,55 @@
+package lnwire
+
+import (
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/stretchr/testify/require"
+)
+
+// TestLocalNoncesNeedingRefresh tests that entries older than the max age, as
+// well as those without a creation time, need a refresh, while fresh ones
+// don't.
+func TestLocalNoncesNeedingRefresh(t *testing.T) {
+	t.Parallel()
+
+	var (
+		now    = time.Unix(1_700_000_000, 0)
+		maxAge = time.Hour
+	)
+
+	nonces := makeTestLocalNonces(5)
+	nonces.SetCreatedAt(makeTestTxId(1), now.Add(-2*time.Hour))
+	nonces.SetCreatedAt(makeTestTxId(2), now.Add(-time.Minute))
+	nonces.SetCreatedAt(makeTestTxId(3), now.Add(-maxAge))
+	nonces.SetCreatedAt(makeTestTxId(5), now.Add(-maxAge-time.Second))
+
+	// Entry 4 has no creation time, while entry 3 is exactly at the max
+	// age, which isn't stale yet.
+	require.Equal(t, []chainhash.Hash{
+		makeTestTxId(1), makeTestTxId(4), makeTestTxId(5),
+	}, nonces.NeedingRefresh(now, maxAge))
+
+	// Creation times are carried along by Merge, and dropped along with
+	// their entry by Apply.
+	merged := &LocalNoncesData{}
+	require.NoError(t, merged.Merge(nonces))
+	require.NoError(t, merged.Apply(nil, []chainhash.Hash{
+		makeTestTxId(1),
+	}))
+	require.Equal(t, []chainhash.Hash{
+		makeTestTxId(4), makeTestTxId(5),
+	}, merged.NeedingRefresh(now, maxAge))
+
+	created, ok := merged.CreatedAt(makeTestTxId(2))
+	require.True(t, ok)
+	require.Equal(t, now.Add(-time.Minute), created)
+
+	_, ok = merged.CreatedAt(makeTestTxId(1))
+	require.False(t, ok)
+
+	// An empty set needs no refresh.
+	var empty LocalNoncesData
+	require.Empty(t, empty.NeedingRefresh(now, maxAge))
+}
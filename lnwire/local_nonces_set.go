@@ -0,0 +1,544 @@
+package lnwire
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"maps"
+	"sort"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+var (
+	// ErrLocalNonceConflict is returned when two sets are combined that
+	// hold different nonces for the same txid.
+	ErrLocalNonceConflict = errors.New("conflicting local nonce")
+
+	// ErrInvalidLocalNoncesPrefixLen is returned when a txid prefix
+	// length isn't between 1 and chainhash.HashSize.
+	ErrInvalidLocalNoncesPrefixLen = errors.New("invalid local nonces " +
+		"prefix length")
+)
+
+// NonceDigests returns a snapshot of the set in the form of the sha256 digest
+// of each nonce, keyed by its txid. The snapshot can later be handed to
+// ChangedSince to find out which entries need to be re-sent.
+func (lnd *LocalNoncesData) NonceDigests() map[chainhash.Hash][32]byte {
+	digests := make(map[chainhash.Hash][32]byte, len(lnd.NoncesMap))
+	for txid, nonce := range lnd.NoncesMap {
+		digests[txid] = sha256.Sum256(nonce[:])
+	}
+
+	return digests
+}
+
+// ChangedSince returns the txids, in ascending order, of all entries whose
+// nonce digest differs from the one recorded in the prev snapshot, including
+// any entries that aren't part of the snapshot at all. Entries that only exist
+// in the snapshot aren't reported.
+func (lnd *LocalNoncesData) ChangedSince(
+	prev map[chainhash.Hash][32]byte) []chainhash.Hash {
+
+	var changed []chainhash.Hash
+	for _, txid := range lnd.sortedTxids() {
+		nonce := lnd.NoncesMap[txid]
+
+		prevDigest, ok := prev[txid]
+		if ok && prevDigest == sha256.Sum256(nonce[:]) {
+			continue
+		}
+
+		changed = append(changed, txid)
+	}
+
+	return changed
+}
+
+// StaleAgainst returns the txids, in ascending order, of all entries that
+// aren't referenced by the peer's set of txids. These nonces will never be
+// used and can be pruned. If the peer references nothing, every txid in the
+// set is returned.
+func (lnd *LocalNoncesData) StaleAgainst(
+	peerTXIDs []chainhash.Hash) []chainhash.Hash {
+
+	referenced := make(map[chainhash.Hash]struct{}, len(peerTXIDs))
+	for _, txid := range peerTXIDs {
+		referenced[txid] = struct{}{}
+	}
+
+	var stale []chainhash.Hash
+	for _, txid := range lnd.sortedTxids() {
+		if _, ok := referenced[txid]; !ok {
+			stale = append(stale, txid)
+		}
+	}
+
+	return stale
+}
+
+// DeltaFrom computes the delta that transforms prev into the current set. The
+// added set holds all entries that are new or whose nonce changed, while
+// removed holds the txids, in ascending order, of the entries that only exist
+// in prev. A nil prev is treated as an empty set.
+func (lnd *LocalNoncesData) DeltaFrom(prev *LocalNoncesData) (
+	*LocalNoncesData, []chainhash.Hash) {
+
+	var prevNonces map[chainhash.Hash]Musig2Nonce
+	if prev != nil {
+		prevNonces = prev.NoncesMap
+	}
+
+	added := &LocalNoncesData{
+		NoncesMap: make(map[chainhash.Hash]Musig2Nonce),
+	}
+	for txid, nonce := range lnd.NoncesMap {
+		prevNonce, ok := prevNonces[txid]
+		if !ok || prevNonce != nonce {
+			added.NoncesMap[txid] = nonce
+		}
+	}
+
+	var removed []chainhash.Hash
+	if prev != nil {
+		for _, txid := range prev.sortedTxids() {
+			if _, ok := lnd.NoncesMap[txid]; !ok {
+				removed = append(removed, txid)
+			}
+		}
+	}
+
+	return added, removed
+}
+
+// EntriesSortedBy returns the entries of the set ordered by the given less
+// function, for instance to present them to an operator. This only affects
+// the returned slice, the wire encoding is always sorted by txid.
+func (lnd *LocalNoncesData) EntriesSortedBy(
+	less func(a, b LocalNonceEntry) bool) []LocalNonceEntry {
+
+	entries := lnd.SortedEntries()
+	sort.SliceStable(entries, func(i, j int) bool {
+		return less(entries[i], entries[j])
+	})
+
+	return entries
+}
+
+// PartitionByConfirmed splits the set in two: live holds the entries whose
+// txid isn't part of the confirmed set, and stale holds the entries of
+// already confirmed transactions, whose nonces will never be used again.
+func (lnd *LocalNoncesData) PartitionByConfirmed(
+	confirmed map[chainhash.Hash]struct{}) (*LocalNoncesData,
+	*LocalNoncesData) {
+
+	live := &LocalNoncesData{
+		NoncesMap: make(map[chainhash.Hash]Musig2Nonce),
+	}
+	stale := &LocalNoncesData{
+		NoncesMap: make(map[chainhash.Hash]Musig2Nonce),
+	}
+	for txid, nonce := range lnd.NoncesMap {
+		if _, ok := confirmed[txid]; ok {
+			stale.NoncesMap[txid] = nonce
+			continue
+		}
+
+		live.NoncesMap[txid] = nonce
+	}
+
+	return live, stale
+}
+
+// TxIDRange returns the lowest and highest txid of the set in byte order,
+// found in a single pass without sorting. The returned bool is false if the
+// set is empty.
+func (lnd *LocalNoncesData) TxIDRange() (chainhash.Hash, chainhash.Hash,
+	bool) {
+
+	var (
+		minTxid, maxTxid chainhash.Hash
+		found            bool
+	)
+	for txid := range lnd.NoncesMap {
+		if !found || bytes.Compare(txid[:], minTxid[:]) < 0 {
+			minTxid = txid
+		}
+		if !found || bytes.Compare(txid[:], maxTxid[:]) > 0 {
+			maxTxid = txid
+		}
+		found = true
+	}
+
+	return minTxid, maxTxid, found
+}
+
+// Batches returns the entries of the set in ascending txid order, split into
+// consecutive batches of at most n entries each, for instance to page through
+// the set. Only the last batch may hold fewer than n entries. An empty set
+// results in no batches at all.
+//
+// NOTE: Batches panics if n isn't positive.
+func (lnd *LocalNoncesData) Batches(n int) [][]LocalNonceEntry {
+	if n <= 0 {
+		panic(fmt.Sprintf("invalid local nonces batch size: %d", n))
+	}
+
+	entries := lnd.SortedEntries()
+
+	batches := make([][]LocalNonceEntry, 0, (len(entries)+n-1)/n)
+	for len(entries) > 0 {
+		size := min(n, len(entries))
+
+		// Cap each batch so appending to it can't clobber the next.
+		batches = append(batches, entries[:size:size])
+		entries = entries[size:]
+	}
+
+	return batches
+}
+
+// ForEachChunk splits the set into chunks of at most maxEntries entries each,
+// in ascending txid order, and hands each of them to build, for instance to
+// attach each chunk to a separate message when a peer only understands the
+// record on specific message types. Source labels, roles and creation times
+// are carried over into the chunks. An empty set results in no calls at all.
+// The first error returned by build stops the iteration and is returned as
+// is.
+//
+// NOTE: ForEachChunk panics if maxEntries isn't positive.
+func (lnd *LocalNoncesData) ForEachChunk(maxEntries int,
+	build func(chunk *LocalNoncesData) error) error {
+
+	for _, batch := range lnd.Batches(maxEntries) {
+		chunk := &LocalNoncesData{
+			NoncesMap: make(
+				map[chainhash.Hash]Musig2Nonce, len(batch),
+			),
+		}
+		for _, entry := range batch {
+			chunk.NoncesMap[entry.TXID] = entry.Nonce
+
+			if source, ok := lnd.Source(entry.TXID); ok {
+				chunk.SetSource(entry.TXID, source)
+			}
+
+			role := lnd.Role(entry.TXID)
+			if role != LocalNonceRoleUnspecified {
+				chunk.AddTagged(entry.TXID, entry.Nonce, role)
+			}
+
+			created, ok := lnd.CreatedAt(entry.TXID)
+			if ok {
+				chunk.SetCreatedAt(entry.TXID, created)
+			}
+		}
+
+		if err := build(chunk); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// FoldLocalNonces folds the entries of the set into an accumulator in
+// ascending txid order, starting out with init. For each entry, f returns the
+// updated accumulator and whether to carry on, so that a search can stop as
+// soon as it has found what it's looking for. The final accumulator is
+// returned. A nil set results in init.
+//
+// NOTE: This is a function rather than a method of LocalNoncesData, as Go
+// methods can't have type parameters of their own, and the type parameter
+// keeps the accumulator type safe.
+func FoldLocalNonces[A any](lnd *LocalNoncesData, init A,
+	f func(acc A, txid chainhash.Hash, nonce Musig2Nonce) (A, bool)) A {
+
+	if lnd == nil {
+		return init
+	}
+
+	acc := init
+	for _, txid := range lnd.sortedTxids() {
+		var ok bool
+		acc, ok = f(acc, txid, lnd.NoncesMap[txid])
+		if !ok {
+			break
+		}
+	}
+
+	return acc
+}
+
+// Grow makes room for n more entries in the set, so that inserting them, for
+// instance when applying a delta, doesn't rehash the map along the way. The
+// map is allocated if it's nil. As a map can't be grown in place, one sized
+// for the current entries plus n replaces it, so callers should grow the set
+// once before a bulk insertion rather than before every insert.
+func (lnd *LocalNoncesData) Grow(n int) {
+	if n <= 0 && lnd.NoncesMap != nil {
+		return
+	}
+
+	grown := make(
+		map[chainhash.Hash]Musig2Nonce, len(lnd.NoncesMap)+max(n, 0),
+	)
+	maps.Copy(grown, lnd.NoncesMap)
+	lnd.NoncesMap = grown
+}
+
+// Merge adds the entries of other to the set. Entries that are already part of
+// the set with the same nonce are left as is, while a different nonce for a
+// known txid results in ErrLocalNonceConflict. The set is only modified if
+// there's no conflict at all. Any source labels, roles and creation times of
+// other are carried along.
+func (lnd *LocalNoncesData) Merge(other *LocalNoncesData) error {
+	return lnd.Apply(other, nil)
+}
+
+// DedupeLocalNonces merges all sets into a single new one, just like calling
+// Merge with each of them in turn would, for instance to combine the sets
+// received in several messages. Besides the merged set, the txids that are
+// part of more than one set with the same nonce are returned in ascending
+// order, which is purely informational. A txid that shows up with different
+// nonces results in ErrLocalNonceConflict. Nil sets are skipped.
+func DedupeLocalNonces(sets ...*LocalNoncesData) (*LocalNoncesData,
+	[]chainhash.Hash, error) {
+
+	var (
+		merged   = &LocalNoncesData{}
+		repeated = make(map[chainhash.Hash]struct{})
+	)
+	for i, set := range sets {
+		if set == nil {
+			continue
+		}
+
+		// Merge leaves the set untouched on a conflict, so the
+		// repeated txids are only recorded once it succeeded.
+		var seen []chainhash.Hash
+		for txid := range set.NoncesMap {
+			if merged.Contains(txid) {
+				seen = append(seen, txid)
+			}
+		}
+
+		if err := merged.Merge(set); err != nil {
+			return nil, nil, fmt.Errorf("set %d: %w", i, err)
+		}
+
+		for _, txid := range seen {
+			repeated[txid] = struct{}{}
+		}
+	}
+
+	if merged.NoncesMap == nil {
+		merged.NoncesMap = make(map[chainhash.Hash]Musig2Nonce)
+	}
+
+	var dupes []chainhash.Hash
+	for txid := range repeated {
+		dupes = append(dupes, txid)
+	}
+	sort.Slice(dupes, func(i, j int) bool {
+		return bytes.Compare(dupes[i][:], dupes[j][:]) < 0
+	})
+
+	return merged, dupes, nil
+}
+
+// Apply merges add into the set after deleting the entries of the txids in
+// remove, which is what reconnection logic needs to bring a set up to date in
+// a single step. As the removals happen first, a txid that's part of both
+// remove and add simply has its nonce replaced. Any other conflict between add
+// and the set aborts the whole operation. Either argument may be nil. The
+// source labels, roles and creation times of removed entries are dropped,
+// while those of add are carried over, replacing any existing ones of the same
+// txid.
+//
+// The operation is all-or-nothing: the result is computed on a copy of the
+// set, which only replaces NoncesMap once it's complete. On failure, the set
+// is left exactly as it was.
+func (lnd *LocalNoncesData) Apply(add *LocalNoncesData,
+	remove []chainhash.Hash) error {
+
+	result := maps.Clone(lnd.NoncesMap)
+	if result == nil {
+		result = make(map[chainhash.Hash]Musig2Nonce)
+	}
+
+	for _, txid := range remove {
+		delete(result, txid)
+	}
+
+	var addSources map[chainhash.Hash]string
+	var addRoles map[chainhash.Hash]LocalNonceRole
+	var addCreatedAt map[chainhash.Hash]time.Time
+	if add != nil {
+		for txid, nonce := range add.NoncesMap {
+			existing, ok := result[txid]
+			if ok && existing != nonce {
+				return fmt.Errorf("%w: txid %v",
+					ErrLocalNonceConflict, txid)
+			}
+
+			result[txid] = nonce
+		}
+
+		addSources, addRoles = add.sources, add.roles
+		addCreatedAt = add.createdAt
+	}
+
+	lnd.sources = applyLocalNonceMeta(lnd.sources, addSources, remove)
+	lnd.roles = applyLocalNonceMeta(lnd.roles, addRoles, remove)
+	lnd.createdAt = applyLocalNonceMeta(
+		lnd.createdAt, addCreatedAt, remove,
+	)
+	lnd.NoncesMap = result
+
+	return nil
+}
+
+// applyLocalNonceMeta returns a copy of the per-entry metadata cur, such as
+// source labels, roles or creation times, with the metadata of the removed
+// txids dropped and that of add carried over.
+func applyLocalNonceMeta[V any](cur, add map[chainhash.Hash]V,
+	remove []chainhash.Hash) map[chainhash.Hash]V {
+
+	result := maps.Clone(cur)
+	for _, txid := range remove {
+		delete(result, txid)
+	}
+
+	if len(add) == 0 {
+		return result
+	}
+	if result == nil {
+		result = make(map[chainhash.Hash]V, len(add))
+	}
+	maps.Copy(result, add)
+
+	return result
+}
+
+// DeltaSavings returns the number of bytes needed to send the full set, and
+// the number of bytes needed to instead send the delta from prev as computed
+// by DeltaFrom. The delta is sized as the record encoding of the added
+// entries plus a 2-byte count followed by the removed txids. This lets
+// operators judge whether syncing deltas is worth it.
+func (lnd *LocalNoncesData) DeltaSavings(prev *LocalNoncesData) (fullBytes,
+	deltaBytes uint64) {
+
+	added, removed := lnd.DeltaFrom(prev)
+
+	fullBytes = uint64(lnd.EncodedSize())
+	deltaBytes = uint64(added.EncodedSize()) + localNoncesCountSize +
+		uint64(len(removed))*chainhash.HashSize
+
+	return fullBytes, deltaBytes
+}
+
+// SymmetricDiff returns the txids, in ascending order, that are only part of
+// this set and those that are only part of other. Txids that are part of both
+// sets are left out of both lists, no matter whether their nonces match. A nil
+// other is treated as an empty set.
+func (lnd *LocalNoncesData) SymmetricDiff(other *LocalNoncesData) (onlyHere,
+	onlyThere []chainhash.Hash) {
+
+	for _, txid := range lnd.sortedTxids() {
+		if !other.Contains(txid) {
+			onlyHere = append(onlyHere, txid)
+		}
+	}
+
+	for _, txid := range other.sortedTxids() {
+		if !lnd.Contains(txid) {
+			onlyThere = append(onlyThere, txid)
+		}
+	}
+
+	return onlyHere, onlyThere
+}
+
+// UnionSize returns the number of distinct txids across this set and other,
+// which is the size the set would grow to when merging other into it, without
+// allocating the union. A nil other is treated as an empty set.
+func (lnd *LocalNoncesData) UnionSize(other *LocalNoncesData) int {
+	size := lnd.Len()
+	if other == nil {
+		return size
+	}
+
+	for txid := range other.NoncesMap {
+		if !lnd.Contains(txid) {
+			size++
+		}
+	}
+
+	return size
+}
+
+// WithPrefix returns a new set holding the entries whose txid starts with the
+// given byte prefix, for instance to shard the set across storage backends.
+// An empty prefix selects every entry, resulting in a copy of the set.
+func (lnd *LocalNoncesData) WithPrefix(prefix []byte) *LocalNoncesData {
+	matching := &LocalNoncesData{
+		NoncesMap: make(map[chainhash.Hash]Musig2Nonce),
+	}
+	if lnd == nil {
+		return matching
+	}
+
+	for txid, nonce := range lnd.NoncesMap {
+		if bytes.HasPrefix(txid[:], prefix) {
+			matching.NoncesMap[txid] = nonce
+		}
+	}
+
+	return matching
+}
+
+// SortKey returns a key that identifies the set, made up of its entries in
+// ascending txid order. Two sets hold the same entries if and only if their
+// keys are equal, so the key can be used to deduplicate sets (converted to a
+// string when used as a map key) or to order them using bytes.Compare. Unlike
+// the record encoding, the key is available for sets of any size.
+func (lnd *LocalNoncesData) SortKey() []byte {
+	key := make([]byte, 0, lnd.Len()*localNonceEntrySize)
+	for _, txid := range lnd.sortedTxids() {
+		nonce := lnd.NoncesMap[txid]
+		key = append(key, txid[:]...)
+		key = append(key, nonce[:]...)
+	}
+
+	return key
+}
+
+// PrefixHistogram returns the number of entries for each distinct txid prefix
+// of prefixLen bytes, keyed by the hex encoding of the prefix. This shows how
+// the entries would be distributed when sharding the set by prefix. A
+// prefixLen that isn't between 1 and chainhash.HashSize results in
+// ErrInvalidLocalNoncesPrefixLen.
+func (lnd *LocalNoncesData) PrefixHistogram(
+	prefixLen int) (map[string]int, error) {
+
+	if prefixLen < 1 || prefixLen > chainhash.HashSize {
+		return nil, fmt.Errorf("%w: %d, must be between 1 and %d",
+			ErrInvalidLocalNoncesPrefixLen, prefixLen,
+			chainhash.HashSize)
+	}
+
+	histogram := make(map[string]int)
+	if lnd == nil {
+		return histogram, nil
+	}
+
+	for txid := range lnd.NoncesMap {
+		histogram[hex.EncodeToString(txid[:prefixLen])]++
+	}
+
+	return histogram, nil
+}
@@ -0,0 +1,825 @@
+package lnwire
+
+import (
+	"bytes"
+	"errors"
+	"maps"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/stretchr/testify/require"
+)
+
+// TestLocalNoncesChangedSince tests that ChangedSince reports exactly the
+// entries that were added or modified after a snapshot was taken.
+func TestLocalNoncesChangedSince(t *testing.T) {
+	t.Parallel()
+
+	nonces := makeTestLocalNonces(3)
+	snapshot := nonces.NonceDigests()
+
+	// Nothing has changed yet.
+	require.Empty(t, nonces.ChangedSince(snapshot))
+
+	// Modify one entry, add a new one and remove another. Only the first
+	// two should be reported.
+	nonces.NoncesMap[makeTestTxId(2)] = makeTestNonce(0xaa)
+	nonces.NoncesMap[makeTestTxId(9)] = makeTestNonce(0xbb)
+	delete(nonces.NoncesMap, makeTestTxId(3))
+
+	require.Equal(
+		t, []chainhash.Hash{makeTestTxId(2), makeTestTxId(9)},
+		nonces.ChangedSince(snapshot),
+	)
+
+	// Against an empty snapshot, every entry is new.
+	require.Equal(
+		t, nonces.sortedTxids(),
+		nonces.ChangedSince(map[chainhash.Hash][32]byte{}),
+	)
+}
+
+// TestLocalNoncesStaleAgainst tests that StaleAgainst reports the entries the
+// peer no longer references.
+func TestLocalNoncesStaleAgainst(t *testing.T) {
+	t.Parallel()
+
+	nonces := makeTestLocalNonces(4)
+
+	// The peer references a subset of our txids, plus one we don't know
+	// of, which must not show up in the result.
+	peerTXIDs := []chainhash.Hash{
+		makeTestTxId(3), makeTestTxId(1), makeTestTxId(7),
+	}
+	require.Equal(
+		t, []chainhash.Hash{makeTestTxId(2), makeTestTxId(4)},
+		nonces.StaleAgainst(peerTXIDs),
+	)
+
+	// If the peer references everything, nothing is stale.
+	require.Empty(t, nonces.StaleAgainst(nonces.sortedTxids()))
+
+	// If the peer references nothing, everything is stale.
+	require.Equal(t, nonces.sortedTxids(), nonces.StaleAgainst(nil))
+}
+
+// TestLocalNoncesDeltaFrom tests that the delta computed by DeltaFrom
+// transforms the previous set into the current one.
+func TestLocalNoncesDeltaFrom(t *testing.T) {
+	t.Parallel()
+
+	prev := makeTestLocalNonces(4)
+
+	// Starting from prev, change one nonce, remove two entries and add a
+	// new one.
+	current := &LocalNoncesData{
+		NoncesMap: maps.Clone(prev.NoncesMap),
+	}
+	current.NoncesMap[makeTestTxId(1)] = makeTestNonce(0xaa)
+	delete(current.NoncesMap, makeTestTxId(2))
+	delete(current.NoncesMap, makeTestTxId(4))
+	current.NoncesMap[makeTestTxId(9)] = makeTestNonce(0xbb)
+
+	added, removed := current.DeltaFrom(prev)
+	require.Equal(t, map[chainhash.Hash]Musig2Nonce{
+		makeTestTxId(1): makeTestNonce(0xaa),
+		makeTestTxId(9): makeTestNonce(0xbb),
+	}, added.NoncesMap)
+	require.Equal(
+		t, []chainhash.Hash{makeTestTxId(2), makeTestTxId(4)}, removed,
+	)
+
+	// Applying the delta to prev must result in the current set.
+	result := maps.Clone(prev.NoncesMap)
+	for _, txid := range removed {
+		delete(result, txid)
+	}
+	maps.Copy(result, added.NoncesMap)
+	require.Equal(t, current.NoncesMap, result)
+
+	// The delta from an identical set is empty.
+	added, removed = current.DeltaFrom(current)
+	require.Empty(t, added.NoncesMap)
+	require.Empty(t, removed)
+
+	// The delta from nothing holds the entire set.
+	added, removed = current.DeltaFrom(nil)
+	require.Equal(t, current.NoncesMap, added.NoncesMap)
+	require.Empty(t, removed)
+}
+
+// TestLocalNoncesEntriesSortedBy tests that entries can be ordered by a custom
+// comparator without affecting the wire order.
+func TestLocalNoncesEntriesSortedBy(t *testing.T) {
+	t.Parallel()
+
+	// Nonces descend as txids ascend.
+	nonces := &LocalNoncesData{
+		NoncesMap: map[chainhash.Hash]Musig2Nonce{
+			makeTestTxId(1): makeTestNonce(30),
+			makeTestTxId(2): makeTestNonce(20),
+			makeTestTxId(3): makeTestNonce(10),
+		},
+	}
+	encoded := encodeTestLocalNonces(t, nonces)
+
+	byNonce := nonces.EntriesSortedBy(func(a, b LocalNonceEntry) bool {
+		return bytes.Compare(a.Nonce[:], b.Nonce[:]) < 0
+	})
+	require.Equal(t, []LocalNonceEntry{
+		{TXID: makeTestTxId(3), Nonce: makeTestNonce(10)},
+		{TXID: makeTestTxId(2), Nonce: makeTestNonce(20)},
+		{TXID: makeTestTxId(1), Nonce: makeTestNonce(30)},
+	}, byNonce)
+
+	// The order differs from the txid order, while the entries are the
+	// same.
+	sorted := nonces.SortedEntries()
+	require.NotEqual(t, sorted, byNonce)
+	require.ElementsMatch(t, sorted, byNonce)
+
+	// The wire encoding is unaffected.
+	require.Equal(t, encoded, encodeTestLocalNonces(t, nonces))
+}
+
+// TestLocalNoncesPartitionByConfirmed tests that the set is split by
+// confirmation status without losing any entries.
+func TestLocalNoncesPartitionByConfirmed(t *testing.T) {
+	t.Parallel()
+
+	nonces := makeTestLocalNonces(5)
+	confirmed := map[chainhash.Hash]struct{}{
+		makeTestTxId(2): {},
+		makeTestTxId(5): {},
+
+		// A confirmed transaction we hold no nonce for is ignored.
+		makeTestTxId(9): {},
+	}
+
+	live, stale := nonces.PartitionByConfirmed(confirmed)
+	require.Equal(t, []chainhash.Hash{
+		makeTestTxId(1), makeTestTxId(3), makeTestTxId(4),
+	}, live.sortedTxids())
+	require.Equal(t, []chainhash.Hash{
+		makeTestTxId(2), makeTestTxId(5),
+	}, stale.sortedTxids())
+
+	// Together, both halves make up the original set.
+	union := maps.Clone(live.NoncesMap)
+	maps.Copy(union, stale.NoncesMap)
+	require.Equal(t, nonces.NoncesMap, union)
+
+	// Without any confirmations, everything is live.
+	live, stale = nonces.PartitionByConfirmed(nil)
+	require.Equal(t, nonces.NoncesMap, live.NoncesMap)
+	require.Empty(t, stale.NoncesMap)
+}
+
+// TestLocalNoncesTxIDRange tests that the lowest and highest txids are found.
+func TestLocalNoncesTxIDRange(t *testing.T) {
+	t.Parallel()
+
+	nonces := &LocalNoncesData{
+		NoncesMap: map[chainhash.Hash]Musig2Nonce{
+			makeTestTxId(0x40): makeTestNonce(1),
+			makeTestTxId(0x07): makeTestNonce(2),
+			makeTestTxId(0xf0): makeTestNonce(3),
+			makeTestTxId(0x10): makeTestNonce(4),
+		},
+	}
+
+	minTxid, maxTxid, ok := nonces.TxIDRange()
+	require.True(t, ok)
+	require.Equal(t, makeTestTxId(0x07), minTxid)
+	require.Equal(t, makeTestTxId(0xf0), maxTxid)
+
+	// A single entry is both the lowest and the highest.
+	minTxid, maxTxid, ok = SingleLocalNonce(
+		makeTestTxId(5), makeTestNonce(5),
+	).TxIDRange()
+	require.True(t, ok)
+	require.Equal(t, makeTestTxId(5), minTxid)
+	require.Equal(t, makeTestTxId(5), maxTxid)
+
+	_, _, ok = (&LocalNoncesData{}).TxIDRange()
+	require.False(t, ok)
+}
+
+// TestLocalNoncesBatches tests that the sorted entries are split into batches
+// of the requested size.
+func TestLocalNoncesBatches(t *testing.T) {
+	t.Parallel()
+
+	nonces := makeTestLocalNonces(7)
+	entries := nonces.SortedEntries()
+
+	batches := nonces.Batches(3)
+	require.Equal(t, [][]LocalNonceEntry{
+		entries[0:3], entries[3:6], entries[6:7],
+	}, batches)
+
+	// Appending to a batch must not affect the one after it.
+	_ = append(batches[0], LocalNonceEntry{})
+	require.Equal(t, entries[3], batches[1][0])
+
+	// A batch size covering the whole set results in a single batch.
+	require.Equal(t, [][]LocalNonceEntry{entries}, nonces.Batches(7))
+	require.Equal(t, [][]LocalNonceEntry{entries}, nonces.Batches(100))
+
+	require.Empty(t, (&LocalNoncesData{}).Batches(3))
+
+	require.Panics(t, func() { nonces.Batches(0) })
+	require.Panics(t, func() { nonces.Batches(-1) })
+}
+
+// TestLocalNoncesForEachChunk tests that the chunks handed to the callback
+// reassemble to the original set, and that an error stops the iteration.
+func TestLocalNoncesForEachChunk(t *testing.T) {
+	t.Parallel()
+
+	nonces := makeTestLocalNonces(7)
+	nonces.SetSource(makeTestTxId(1), "alice")
+	nonces.AddTagged(
+		makeTestTxId(7), makeTestNonce(14), LocalNonceRoleFunding,
+	)
+
+	var chunks []*LocalNoncesData
+	err := nonces.ForEachChunk(3, func(chunk *LocalNoncesData) error {
+		chunks = append(chunks, chunk)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Len(t, chunks, 3)
+
+	reassembled := &LocalNoncesData{}
+	for i, chunk := range chunks {
+		require.LessOrEqual(t, chunk.Len(), 3)
+		require.Equal(t, nonces.Batches(3)[i], chunk.SortedEntries())
+		require.NoError(t, reassembled.Merge(chunk))
+	}
+	require.Equal(t, nonces, reassembled)
+
+	// An error returned by the callback is passed through as is, and no
+	// further chunks are built.
+	errBuild := errors.New("unable to build message")
+	var calls int
+	err = nonces.ForEachChunk(3, func(*LocalNoncesData) error {
+		calls++
+		return errBuild
+	})
+	require.ErrorIs(t, err, errBuild)
+	require.Equal(t, 1, calls)
+
+	// An empty set results in no calls at all.
+	empty := &LocalNoncesData{}
+	err = empty.ForEachChunk(3, func(*LocalNoncesData) error {
+		calls++
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, calls)
+}
+
+// TestLocalNoncesMerge tests that sets are merged unless they conflict.
+func TestLocalNoncesMerge(t *testing.T) {
+	t.Parallel()
+
+	nonces := makeTestLocalNonces(2)
+
+	// Overlapping entries with the same nonce are fine.
+	other := &LocalNoncesData{NoncesMap: maps.Clone(nonces.NoncesMap)}
+	other.NoncesMap[makeTestTxId(3)] = makeTestNonce(0xbb)
+	require.NoError(t, nonces.Merge(other))
+	require.Equal(t, other.NoncesMap, nonces.NoncesMap)
+
+	// A different nonce for a known txid isn't.
+	conflict := SingleLocalNonce(makeTestTxId(1), makeTestNonce(0xaa))
+	err := nonces.Merge(conflict)
+	require.ErrorIs(t, err, ErrLocalNonceConflict)
+	require.Equal(t, other.NoncesMap, nonces.NoncesMap)
+
+	// Merging into an empty set allocates its map.
+	var empty LocalNoncesData
+	require.NoError(t, empty.Merge(other))
+	require.Equal(t, other.NoncesMap, empty.NoncesMap)
+}
+
+// TestLocalNoncesApply tests that a delta is applied in a single step, and
+// that a conflicting delta isn't applied at all.
+func TestLocalNoncesApply(t *testing.T) {
+	t.Parallel()
+
+	nonces := makeTestLocalNonces(4)
+
+	// Add a new entry, replace the nonce of one that's also removed, and
+	// remove two more, one of which we don't know about.
+	add := &LocalNoncesData{
+		NoncesMap: map[chainhash.Hash]Musig2Nonce{
+			makeTestTxId(9): makeTestNonce(0xaa),
+			makeTestTxId(2): makeTestNonce(0xbb),
+		},
+	}
+	remove := []chainhash.Hash{
+		makeTestTxId(2), makeTestTxId(3), makeTestTxId(8),
+	}
+	require.NoError(t, nonces.Apply(add, remove))
+	require.Equal(t, map[chainhash.Hash]Musig2Nonce{
+		makeTestTxId(1): makeTestNonce(5),
+		makeTestTxId(2): makeTestNonce(0xbb),
+		makeTestTxId(4): makeTestNonce(8),
+		makeTestTxId(9): makeTestNonce(0xaa),
+	}, nonces.NoncesMap)
+
+	// A conflicting add aborts the whole operation, including the
+	// removals.
+	before := maps.Clone(nonces.NoncesMap)
+	add = &LocalNoncesData{
+		NoncesMap: map[chainhash.Hash]Musig2Nonce{
+			makeTestTxId(7): makeTestNonce(0xcc),
+			makeTestTxId(1): makeTestNonce(0xdd),
+		},
+	}
+	err := nonces.Apply(add, []chainhash.Hash{makeTestTxId(4)})
+	require.ErrorIs(t, err, ErrLocalNonceConflict)
+	require.Equal(t, before, nonces.NoncesMap)
+
+	// Either side may be omitted.
+	require.NoError(t, nonces.Apply(nil, []chainhash.Hash{makeTestTxId(4)}))
+	require.NotContains(t, nonces.NoncesMap, makeTestTxId(4))
+	require.NoError(t, nonces.Apply(nil, nil))
+}
+
+// TestLocalNoncesApplyAtomic tests that a conflict detected after the removals
+// and part of the additions were processed leaves the set unchanged.
+func TestLocalNoncesApplyAtomic(t *testing.T) {
+	t.Parallel()
+
+	nonces := makeTestLocalNonces(8)
+	before := encodeTestLocalNonces(t, nonces)
+
+	// Every entry but the last one of add is valid, so whatever order the
+	// entries are processed in, some of the changes were already made by
+	// the time the conflict is hit.
+	add := &LocalNoncesData{
+		NoncesMap: make(map[chainhash.Hash]Musig2Nonce),
+	}
+	for i := byte(20); i < 40; i++ {
+		add.NoncesMap[makeTestTxId(i)] = makeTestNonce(i)
+	}
+	add.NoncesMap[makeTestTxId(8)] = makeTestNonce(0xff)
+
+	remove := []chainhash.Hash{
+		makeTestTxId(1), makeTestTxId(2), makeTestTxId(3),
+	}
+
+	err := nonces.Apply(add, remove)
+	require.ErrorIs(t, err, ErrLocalNonceConflict)
+	require.Equal(t, before, encodeTestLocalNonces(t, nonces))
+}
+
+// TestLocalNoncesDeltaSavings tests the size of a full send against a delta
+// send for a small change to a large set.
+func TestLocalNoncesDeltaSavings(t *testing.T) {
+	t.Parallel()
+
+	prev := makeTestLocalNonces(100)
+
+	// Change one nonce, add one entry and remove two.
+	current := &LocalNoncesData{NoncesMap: maps.Clone(prev.NoncesMap)}
+	current.NoncesMap[makeTestTxId(1)] = makeTestNonce(0xaa)
+	current.NoncesMap[makeTestTxId(200)] = makeTestNonce(0xbb)
+	delete(current.NoncesMap, makeTestTxId(2))
+	delete(current.NoncesMap, makeTestTxId(3))
+
+	fullBytes, deltaBytes := current.DeltaSavings(prev)
+	require.EqualValues(
+		t, localNoncesCountSize+99*localNonceEntrySize, fullBytes,
+	)
+	require.EqualValues(
+		t, localNoncesCountSize+2*localNonceEntrySize+
+			localNoncesCountSize+2*chainhash.HashSize, deltaBytes,
+	)
+	require.Less(t, deltaBytes, fullBytes)
+
+	// Without any changes, the delta is just the two empty counts.
+	fullBytes, deltaBytes = prev.DeltaSavings(prev)
+	require.EqualValues(t, prev.EncodedSize(), fullBytes)
+	require.EqualValues(t, 2*localNoncesCountSize, deltaBytes)
+}
+
+// TestLocalNoncesSymmetricDiff tests that the txids unique to either side of
+// two overlapping sets are found.
+func TestLocalNoncesSymmetricDiff(t *testing.T) {
+	t.Parallel()
+
+	// Both sets share txids 3 and 4, the latter with a different nonce.
+	here := makeTestLocalNonces(4)
+	there := &LocalNoncesData{
+		NoncesMap: map[chainhash.Hash]Musig2Nonce{
+			makeTestTxId(3): here.NoncesMap[makeTestTxId(3)],
+			makeTestTxId(4): makeTestNonce(0xaa),
+			makeTestTxId(6): makeTestNonce(0xbb),
+			makeTestTxId(5): makeTestNonce(0xcc),
+		},
+	}
+
+	onlyHere, onlyThere := here.SymmetricDiff(there)
+	require.Equal(
+		t, []chainhash.Hash{makeTestTxId(1), makeTestTxId(2)}, onlyHere,
+	)
+	require.Equal(
+		t, []chainhash.Hash{makeTestTxId(5), makeTestTxId(6)},
+		onlyThere,
+	)
+
+	// The diff is symmetric.
+	onlyThere, onlyHere = there.SymmetricDiff(here)
+	require.Equal(
+		t, []chainhash.Hash{makeTestTxId(1), makeTestTxId(2)}, onlyHere,
+	)
+	require.Equal(
+		t, []chainhash.Hash{makeTestTxId(5), makeTestTxId(6)},
+		onlyThere,
+	)
+
+	onlyHere, onlyThere = here.SymmetricDiff(here)
+	require.Empty(t, onlyHere)
+	require.Empty(t, onlyThere)
+
+	onlyHere, onlyThere = here.SymmetricDiff(nil)
+	require.Equal(t, here.sortedTxids(), onlyHere)
+	require.Empty(t, onlyThere)
+}
+
+// makeTestTxIdWithPrefix returns a test txid that starts with the given
+// prefix.
+func makeTestTxIdWithPrefix(prefix ...byte) chainhash.Hash {
+	txid := makeTestTxId(0xee)
+	copy(txid[:], prefix)
+
+	return txid
+}
+
+// TestLocalNoncesUnionSize tests that the union size counts the txids both
+// sets share only once.
+func TestLocalNoncesUnionSize(t *testing.T) {
+	t.Parallel()
+
+	// The txids 1..5 and 4..7 overlap in 4 and 5, no matter the nonces.
+	nonces := makeTestLocalNonces(5)
+	other := &LocalNoncesData{
+		NoncesMap: make(map[chainhash.Hash]Musig2Nonce),
+	}
+	for i := byte(4); i <= 7; i++ {
+		other.NoncesMap[makeTestTxId(i)] = makeTestNonce(0xff)
+	}
+
+	require.Equal(t, 7, nonces.UnionSize(other))
+	require.Equal(t, 7, other.UnionSize(nonces))
+
+	// The size matches the result of an actual merge of the txids.
+	merged := makeTestLocalNonces(5)
+	require.NoError(t, merged.Apply(other, other.sortedTxids()))
+	require.Equal(t, merged.Len(), nonces.UnionSize(other))
+
+	require.Equal(t, 5, nonces.UnionSize(nil))
+	require.Equal(t, 5, nonces.UnionSize(nonces))
+
+	var empty *LocalNoncesData
+	require.Equal(t, 4, empty.UnionSize(other))
+}
+
+// TestLocalNoncesWithPrefix tests that entries are selected by txid prefix.
+func TestLocalNoncesWithPrefix(t *testing.T) {
+	t.Parallel()
+
+	nonces := &LocalNoncesData{
+		NoncesMap: map[chainhash.Hash]Musig2Nonce{
+			makeTestTxIdWithPrefix(0xab, 0x01): makeTestNonce(1),
+			makeTestTxIdWithPrefix(0xab, 0x02): makeTestNonce(2),
+			makeTestTxIdWithPrefix(0xac, 0x01): makeTestNonce(3),
+			makeTestTxIdWithPrefix(0x01, 0xab): makeTestNonce(4),
+		},
+	}
+
+	require.Equal(t, map[chainhash.Hash]Musig2Nonce{
+		makeTestTxIdWithPrefix(0xab, 0x01): makeTestNonce(1),
+		makeTestTxIdWithPrefix(0xab, 0x02): makeTestNonce(2),
+	}, nonces.WithPrefix([]byte{0xab}).NoncesMap)
+
+	require.Equal(t, map[chainhash.Hash]Musig2Nonce{
+		makeTestTxIdWithPrefix(0xab, 0x02): makeTestNonce(2),
+	}, nonces.WithPrefix([]byte{0xab, 0x02}).NoncesMap)
+
+	require.Empty(t, nonces.WithPrefix([]byte{0xff}).NoncesMap)
+
+	// An empty prefix selects everything, without sharing the map.
+	all := nonces.WithPrefix(nil)
+	require.Equal(t, nonces.NoncesMap, all.NoncesMap)
+	delete(all.NoncesMap, makeTestTxIdWithPrefix(0xab, 0x01))
+	require.Len(t, nonces.NoncesMap, 4)
+}
+
+// TestLocalNoncesSortKey tests that equal sets share a key, while different
+// sets have keys that can be ordered.
+func TestLocalNoncesSortKey(t *testing.T) {
+	t.Parallel()
+
+	nonces := makeTestLocalNonces(3)
+
+	// A separate set with the same entries has the same key.
+	same := &LocalNoncesData{NoncesMap: maps.Clone(nonces.NoncesMap)}
+	require.Equal(t, nonces.SortKey(), same.SortKey())
+
+	// Changing a single nonce changes the key, and the two can be
+	// ordered.
+	changed := &LocalNoncesData{NoncesMap: maps.Clone(nonces.NoncesMap)}
+	changed.NoncesMap[makeTestTxId(3)] = makeTestNonce(0xff)
+	require.Equal(
+		t, -1, bytes.Compare(nonces.SortKey(), changed.SortKey()),
+	)
+
+	// The keys deduplicate sets when used in a map.
+	seen := map[string]struct{}{
+		string(nonces.SortKey()): {},
+		string(same.SortKey()):   {},
+	}
+	seen[string(changed.SortKey())] = struct{}{}
+	require.Len(t, seen, 2)
+
+	require.Empty(t, (&LocalNoncesData{}).SortKey())
+}
+
+// TestLocalNoncesSources tests that source labels are carried along when sets
+// are combined, while never being encoded.
+func TestLocalNoncesSources(t *testing.T) {
+	t.Parallel()
+
+	nonces := makeTestLocalNonces(2)
+	encoded := encodeTestLocalNonces(t, nonces)
+
+	nonces.SetSource(makeTestTxId(1), "alice")
+	nonces.SetSource(makeTestTxId(2), "bob")
+
+	label, ok := nonces.Source(makeTestTxId(1))
+	require.True(t, ok)
+	require.Equal(t, "alice", label)
+
+	_, ok = nonces.Source(makeTestTxId(3))
+	require.False(t, ok)
+
+	// Labels are local metadata that never make it onto the wire.
+	require.Equal(t, encoded, encodeTestLocalNonces(t, nonces))
+	appended, err := nonces.AppendTo(nil)
+	require.NoError(t, err)
+	require.Equal(t, encoded, appended)
+
+	// Labels survive a merge, which carries over the labels of the other
+	// set.
+	other := SingleLocalNonce(makeTestTxId(3), makeTestNonce(0xaa))
+	other.SetSource(makeTestTxId(3), "carol")
+	require.NoError(t, nonces.Merge(other))
+
+	for txid, expected := range map[chainhash.Hash]string{
+		makeTestTxId(1): "alice",
+		makeTestTxId(2): "bob",
+		makeTestTxId(3): "carol",
+	} {
+		label, ok := nonces.Source(txid)
+		require.True(t, ok)
+		require.Equal(t, expected, label)
+	}
+
+	// Removing an entry drops its label, even if it's added back.
+	readded := SingleLocalNonce(makeTestTxId(2), makeTestNonce(0xbb))
+	err = nonces.Apply(readded, []chainhash.Hash{makeTestTxId(2)})
+	require.NoError(t, err)
+	_, ok = nonces.Source(makeTestTxId(2))
+	require.False(t, ok)
+
+	// Decoding into the set replaces its entries, and with them their
+	// labels.
+	var buf [8]byte
+	err = decodeLocalNoncesData(
+		bytes.NewReader(encoded), nonces, &buf, uint64(len(encoded)),
+	)
+	require.NoError(t, err)
+	_, ok = nonces.Source(makeTestTxId(1))
+	require.False(t, ok)
+}
+
+// TestLocalNoncesPrefixHistogram tests that entries are counted by txid
+// prefix.
+func TestLocalNoncesPrefixHistogram(t *testing.T) {
+	t.Parallel()
+
+	prefixed := makeTestTxIdWithPrefix
+	nonces := &LocalNoncesData{
+		NoncesMap: map[chainhash.Hash]Musig2Nonce{
+			prefixed(0x00, 0x01):       makeTestNonce(1),
+			prefixed(0x00, 0x02):       makeTestNonce(2),
+			prefixed(0x00, 0x02, 0x01): makeTestNonce(3),
+			prefixed(0xab, 0x01):       makeTestNonce(4),
+		},
+	}
+
+	histogram, err := nonces.PrefixHistogram(1)
+	require.NoError(t, err)
+	require.Equal(t, map[string]int{
+		"00": 3,
+		"ab": 1,
+	}, histogram)
+
+	histogram, err = nonces.PrefixHistogram(2)
+	require.NoError(t, err)
+	require.Equal(t, map[string]int{
+		"0001": 1,
+		"0002": 2,
+		"ab01": 1,
+	}, histogram)
+
+	// With full length prefixes, every entry is counted on its own.
+	histogram, err = nonces.PrefixHistogram(chainhash.HashSize)
+	require.NoError(t, err)
+	require.Len(t, histogram, 4)
+	for _, count := range histogram {
+		require.Equal(t, 1, count)
+	}
+
+	histogram, err = (&LocalNoncesData{}).PrefixHistogram(1)
+	require.NoError(t, err)
+	require.Empty(t, histogram)
+
+	for _, prefixLen := range []int{-1, 0, chainhash.HashSize + 1} {
+		_, err := nonces.PrefixHistogram(prefixLen)
+		require.ErrorIs(t, err, ErrInvalidLocalNoncesPrefixLen)
+	}
+}
+
+// TestDedupeLocalNonces tests that merging several sets reports the txids
+// they share, and rejects txids with conflicting nonces.
+func TestDedupeLocalNonces(t *testing.T) {
+	t.Parallel()
+
+	t.Run("clean", func(t *testing.T) {
+		t.Parallel()
+
+		// The txids 2 and 3 are part of several sets, 3 even of all
+		// of them, with the same nonces.
+		a := makeTestLocalNonces(3)
+		b := &LocalNoncesData{NoncesMap: map[chainhash.Hash]Musig2Nonce{
+			makeTestTxId(2): a.NoncesMap[makeTestTxId(2)],
+			makeTestTxId(3): a.NoncesMap[makeTestTxId(3)],
+			makeTestTxId(4): makeTestNonce(0xaa),
+		}}
+		c := SingleLocalNonce(
+			makeTestTxId(3), a.NoncesMap[makeTestTxId(3)],
+		)
+
+		merged, dupes, err := DedupeLocalNonces(a, nil, b, c)
+		require.NoError(t, err)
+		require.Equal(t, 4, merged.Len())
+		require.Equal(t, []chainhash.Hash{
+			makeTestTxId(2), makeTestTxId(3),
+		}, dupes)
+
+		for _, set := range []*LocalNoncesData{a, b, c} {
+			for txid, nonce := range set.NoncesMap {
+				got, ok := merged.Get(txid)
+				require.True(t, ok)
+				require.Equal(t, nonce, got)
+			}
+		}
+	})
+
+	t.Run("conflict", func(t *testing.T) {
+		t.Parallel()
+
+		a := makeTestLocalNonces(2)
+		b := SingleLocalNonce(makeTestTxId(2), makeTestNonce(0xaa))
+
+		_, _, err := DedupeLocalNonces(a, b)
+		require.ErrorIs(t, err, ErrLocalNonceConflict)
+	})
+
+	t.Run("disjoint", func(t *testing.T) {
+		t.Parallel()
+
+		a := SingleLocalNonce(makeTestTxId(1), makeTestNonce(1))
+		b := SingleLocalNonce(makeTestTxId(2), makeTestNonce(2))
+
+		merged, dupes, err := DedupeLocalNonces(a, b)
+		require.NoError(t, err)
+		require.Empty(t, dupes)
+		require.Equal(t, 2, merged.Len())
+	})
+
+	t.Run("none", func(t *testing.T) {
+		t.Parallel()
+
+		merged, dupes, err := DedupeLocalNonces()
+		require.NoError(t, err)
+		require.Empty(t, dupes)
+		require.NotNil(t, merged.NoncesMap)
+		require.Zero(t, merged.Len())
+	})
+}
+
+// TestFoldLocalNonces tests that folding visits the entries in ascending txid
+// order, and stops as soon as the callback asks it to.
+func TestFoldLocalNonces(t *testing.T) {
+	t.Parallel()
+
+	nonces := makeTestLocalNonces(6)
+
+	// Count the entries whose nonce seed is even.
+	numEven := FoldLocalNonces(nonces, 0, func(acc int,
+		_ chainhash.Hash, nonce Musig2Nonce) (int, bool) {
+
+		if nonce[0]%2 == 0 {
+			acc++
+		}
+
+		return acc, true
+	})
+	require.Equal(t, 3, numEven)
+
+	// Find the first txid whose nonce seed exceeds 8, keeping track of
+	// the entries visited along the way.
+	var visited []chainhash.Hash
+	found := FoldLocalNonces(nonces, chainhash.Hash{}, func(
+		acc chainhash.Hash, txid chainhash.Hash,
+		nonce Musig2Nonce) (chainhash.Hash, bool) {
+
+		visited = append(visited, txid)
+		if nonce[0] > 8 {
+			return txid, false
+		}
+
+		return acc, true
+	})
+	require.Equal(t, makeTestTxId(3), found)
+	require.Equal(t, []chainhash.Hash{
+		makeTestTxId(1), makeTestTxId(2), makeTestTxId(3),
+	}, visited)
+
+	// Folding a nil set returns the initial accumulator.
+	require.Equal(t, 42, FoldLocalNonces(nil, 42, func(acc int,
+		_ chainhash.Hash, _ Musig2Nonce) (int, bool) {
+
+		return acc + 1, true
+	}))
+}
+
+// TestLocalNoncesGrow tests that growing a set keeps its entries, and
+// allocates the map of an empty set.
+func TestLocalNoncesGrow(t *testing.T) {
+	t.Parallel()
+
+	var empty LocalNoncesData
+	empty.Grow(0)
+	require.NotNil(t, empty.NoncesMap)
+	require.Zero(t, empty.Len())
+
+	nonces := makeTestLocalNonces(3)
+	expected := maps.Clone(nonces.NoncesMap)
+	for _, n := range []int{-1, 0, 10} {
+		nonces.Grow(n)
+		require.Equal(t, expected, nonces.NoncesMap)
+	}
+}
+
+// BenchmarkLocalNoncesGrow compares inserting a batch of entries into a set
+// with and without growing it first.
+func BenchmarkLocalNoncesGrow(b *testing.B) {
+	// The txids of the test entries are made up of a single repeated
+	// byte, which leaves room for 255 of them next to the zero txid.
+	const numEntries = 255
+
+	entries := makeTestLocalNonces(numEntries).SortedEntries()
+
+	// insert adds all entries to a set that holds a single entry to begin
+	// with, optionally growing it first.
+	insert := func(b *testing.B, grow bool) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			nonces := SingleLocalNonce(
+				makeTestTxId(0), makeTestNonce(0),
+			)
+			if grow {
+				nonces.Grow(len(entries))
+			}
+			for _, entry := range entries {
+				nonces.NoncesMap[entry.TXID] = entry.Nonce
+			}
+		}
+	}
+
+	b.Run("without Grow", func(b *testing.B) {
+		insert(b, false)
+	})
+
+	b.Run("with Grow", func(b *testing.B) {
+		insert(b, true)
+	})
+}
@@ -0,0 +1,121 @@
+package lnwire
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"slices"
+	"sort"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+// searchEncodedLocalNonces checks the framing of the encoded record and
+// binary searches its entries for the given txid. It returns the number of
+// entries of the record and the index of the entry holding the txid, or, if
+// there's none, the index a new entry for the txid would be inserted at to
+// keep the record canonical.
+func searchEncodedLocalNonces(encoded []byte,
+	txid chainhash.Hash) (int, int, bool, error) {
+
+	numEntries, err := readLocalNoncesHeader(
+		bytes.NewReader(encoded), uint64(len(encoded)),
+	)
+	if err != nil {
+		return 0, 0, false, err
+	}
+
+	i := sort.Search(int(numEntries), func(i int) bool {
+		entryTxid := encodedLocalNonceTxid(encoded, i)
+		return bytes.Compare(entryTxid, txid[:]) >= 0
+	})
+	found := i < int(numEntries) &&
+		bytes.Equal(encodedLocalNonceTxid(encoded, i), txid[:])
+
+	return int(numEntries), i, found, nil
+}
+
+// encodedLocalNonceOffset returns the offset of the i-th entry of an encoded
+// record.
+func encodedLocalNonceOffset(i int) int {
+	return localNoncesCountSize + i*localNonceEntrySize
+}
+
+// encodedLocalNonceTxid returns the txid of the i-th entry of an encoded
+// record.
+func encodedLocalNonceTxid(encoded []byte, i int) []byte {
+	offset := encodedLocalNonceOffset(i)
+	return encoded[offset : offset+chainhash.HashSize]
+}
+
+// AppendLocalNonceEncoded inserts an entry into the encoded record at the
+// position that keeps its txids in ascending order, updating the count, and
+// returns the resulting record. The entries are located with a binary search
+// and spliced in place, so that a single entry can be added to a large record
+// without decoding and re-encoding it. A zero length record is treated as an
+// empty set. ErrLocalNoncesDuplicateTxid is returned if the record already
+// holds the txid.
+//
+// NOTE: Just like with append, the result may share its backing array with
+// encoded, which must not be used afterwards. The record is expected to be
+// canonical, see IsCanonicalLocalNonces.
+func AppendLocalNonceEncoded(encoded []byte, txid chainhash.Hash,
+	nonce Musig2Nonce) ([]byte, error) {
+
+	numEntries, i, found, err := searchEncodedLocalNonces(encoded, txid)
+	switch {
+	case err != nil:
+		return nil, err
+
+	case found:
+		return nil, fmt.Errorf("%w: %v", ErrLocalNoncesDuplicateTxid,
+			txid)
+
+	case numEntries == MaxLocalNonces:
+		return nil, fmt.Errorf("%w: max is %d", ErrTooManyLocalNonces,
+			MaxLocalNonces)
+	}
+
+	if len(encoded) == 0 {
+		capacity := encodedLocalNonceOffset(1)
+		encoded = make([]byte, localNoncesCountSize, capacity)
+	}
+
+	entry := make([]byte, 0, localNonceEntrySize)
+	entry = append(entry, txid[:]...)
+	entry = append(entry, nonce[:]...)
+
+	encoded = slices.Insert(encoded, encodedLocalNonceOffset(i), entry...)
+	binary.BigEndian.PutUint16(encoded, uint16(numEntries+1))
+
+	return encoded, nil
+}
+
+// RemoveLocalNonceEncoded removes the entry for the given txid from the
+// encoded record, updating the count, and returns the resulting record along
+// with whether an entry was removed. Just like AppendLocalNonceEncoded, the
+// entry is located with a binary search and spliced out in place. If the
+// record doesn't hold the txid, it's returned unchanged. Removing the last
+// entry leaves a record with a zero count.
+//
+// NOTE: The entries are shifted within the backing array of encoded, which
+// must not be used afterwards. The record is expected to be canonical, see
+// IsCanonicalLocalNonces.
+func RemoveLocalNonceEncoded(encoded []byte,
+	txid chainhash.Hash) ([]byte, bool, error) {
+
+	numEntries, i, found, err := searchEncodedLocalNonces(encoded, txid)
+	switch {
+	case err != nil:
+		return nil, false, err
+
+	case !found:
+		return encoded, false, nil
+	}
+
+	offset := encodedLocalNonceOffset(i)
+	encoded = slices.Delete(encoded, offset, offset+localNonceEntrySize)
+	binary.BigEndian.PutUint16(encoded, uint16(numEntries-1))
+
+	return encoded, true, nil
+}
@@ -0,0 +1,164 @@
+package lnwire
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/stretchr/testify/require"
+)
+
+// makeSpacedTestLocalNonces returns a set holding the txids 2, 4 and 6, which
+// leaves room to insert entries before, between and after them.
+func makeSpacedTestLocalNonces() *LocalNoncesData {
+	return &LocalNoncesData{
+		NoncesMap: map[chainhash.Hash]Musig2Nonce{
+			makeTestTxId(2): makeTestNonce(2),
+			makeTestTxId(4): makeTestNonce(4),
+			makeTestTxId(6): makeTestNonce(6),
+		},
+	}
+}
+
+// TestAppendLocalNonceEncoded tests that an entry inserted into an encoded
+// record ends up exactly where the canonical encoding puts it, and that a
+// repeated txid is rejected.
+func TestAppendLocalNonceEncoded(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name string
+		txid chainhash.Hash
+	}{
+		{
+			name: "front",
+			txid: makeTestTxId(1),
+		},
+		{
+			name: "middle",
+			txid: makeTestTxId(3),
+		},
+		{
+			name: "end",
+			txid: makeTestTxId(7),
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			nonces := makeSpacedTestLocalNonces()
+			encoded := encodeTestLocalNonces(t, nonces)
+
+			nonce := makeTestNonce(0xaa)
+			encoded, err := AppendLocalNonceEncoded(
+				encoded, tc.txid, nonce,
+			)
+			require.NoError(t, err)
+
+			nonces.NoncesMap[tc.txid] = nonce
+			require.Equal(
+				t, encodeTestLocalNonces(t, nonces), encoded,
+			)
+		})
+	}
+
+	// Entries can be added to an empty record, no matter whether it
+	// carries a zero count or has a zero length.
+	expected := encodeTestLocalNonces(
+		t, SingleLocalNonce(makeTestTxId(1), makeTestNonce(1)),
+	)
+	for _, empty := range [][]byte{nil, {0x00, 0x00}} {
+		encoded, err := AppendLocalNonceEncoded(
+			empty, makeTestTxId(1), makeTestNonce(1),
+		)
+		require.NoError(t, err)
+		require.Equal(t, expected, encoded)
+	}
+
+	// A txid the record already holds is rejected, even with the same
+	// nonce.
+	encoded := encodeTestLocalNonces(t, makeSpacedTestLocalNonces())
+	_, err := AppendLocalNonceEncoded(
+		bytes.Clone(encoded), makeTestTxId(4), makeTestNonce(4),
+	)
+	require.ErrorIs(t, err, ErrLocalNoncesDuplicateTxid)
+
+	// So is a record that isn't framed correctly.
+	_, err = AppendLocalNonceEncoded(
+		encoded[:len(encoded)-1], makeTestTxId(1), makeTestNonce(1),
+	)
+	require.ErrorIs(t, err, ErrLocalNoncesLengthMismatch)
+}
+
+// TestRemoveLocalNonceEncoded tests that removing an entry from an encoded
+// record results in the canonical encoding of the remaining entries, while
+// removing an absent txid leaves the record unchanged.
+func TestRemoveLocalNonceEncoded(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name string
+		txid chainhash.Hash
+	}{
+		{
+			name: "first",
+			txid: makeTestTxId(2),
+		},
+		{
+			name: "middle",
+			txid: makeTestTxId(4),
+		},
+		{
+			name: "last",
+			txid: makeTestTxId(6),
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			nonces := makeSpacedTestLocalNonces()
+			encoded := encodeTestLocalNonces(t, nonces)
+
+			encoded, removed, err := RemoveLocalNonceEncoded(
+				encoded, tc.txid,
+			)
+			require.NoError(t, err)
+			require.True(t, removed)
+
+			delete(nonces.NoncesMap, tc.txid)
+			require.Equal(
+				t, encodeTestLocalNonces(t, nonces), encoded,
+			)
+		})
+	}
+
+	// Removing an absent txid leaves the record untouched.
+	encoded := encodeTestLocalNonces(t, makeSpacedTestLocalNonces())
+	for _, txid := range []chainhash.Hash{
+		makeTestTxId(1), makeTestTxId(3), makeTestTxId(7),
+	} {
+		result, removed, err := RemoveLocalNonceEncoded(
+			bytes.Clone(encoded), txid,
+		)
+		require.NoError(t, err)
+		require.False(t, removed)
+		require.Equal(t, encoded, result)
+	}
+
+	// Removing the only entry leaves a zero count.
+	single := encodeTestLocalNonces(
+		t, SingleLocalNonce(makeTestTxId(1), makeTestNonce(1)),
+	)
+	result, removed, err := RemoveLocalNonceEncoded(single, makeTestTxId(1))
+	require.NoError(t, err)
+	require.True(t, removed)
+	require.Equal(t, []byte{0x00, 0x00}, result)
+
+	// A record that isn't framed correctly is rejected.
+	_, _, err = RemoveLocalNonceEncoded(
+		encoded[:len(encoded)-1], makeTestTxId(2),
+	)
+	require.ErrorIs(t, err, ErrLocalNoncesLengthMismatch)
+}
@@ -0,0 +1,133 @@
+package lnwire
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+var (
+	// ErrLocalNoncesOutOfOrder is returned when entries are streamed into
+	// a LocalNoncesEncoder in anything but strictly ascending txid order.
+	ErrLocalNoncesOutOfOrder = errors.New("local nonces out of order")
+
+	// ErrLocalNoncesEncoderFinished is returned when a LocalNoncesEncoder
+	// is used after Finish was called.
+	ErrLocalNoncesEncoderFinished = errors.New("local nonces encoder " +
+		"already finished")
+)
+
+// LocalNoncesEncoder writes a LocalNoncesData record entry by entry, for when
+// the number of entries isn't known up front, such as when streaming them
+// from a LocalNoncesCursor. The result is identical to the record encoding of
+// a set holding the same entries.
+//
+// If the underlying writer implements io.WriteSeeker, a placeholder count is
+// written first and the entries are streamed straight through, after which
+// Finish seeks back to fill in the actual count. Any other writer has the
+// entries buffered in memory until Finish writes them along with the count.
+type LocalNoncesEncoder struct {
+	w io.Writer
+
+	// ws is the underlying writer if it implements io.WriteSeeker, in
+	// which case start is the offset the placeholder count was written
+	// at.
+	ws    io.WriteSeeker
+	start int64
+
+	// body buffers the entries if the writer can't seek.
+	body bytes.Buffer
+
+	numEntries int
+	prevTxid   chainhash.Hash
+	finished   bool
+}
+
+// NewLocalNoncesEncoder returns an encoder that writes a record to w. For
+// seekable writers, the placeholder count is written right away.
+func NewLocalNoncesEncoder(w io.Writer) (*LocalNoncesEncoder, error) {
+	e := &LocalNoncesEncoder{w: w}
+
+	ws, ok := w.(io.WriteSeeker)
+	if !ok {
+		return e, nil
+	}
+
+	start, err := ws.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeLocalNoncesCount(ws, 0); err != nil {
+		return nil, err
+	}
+	e.ws, e.start = ws, start
+
+	return e, nil
+}
+
+// Add writes the next entry of the record. Entries must be added in strictly
+// ascending txid order, just as they appear on the wire, and no more than
+// MaxLocalNonces of them can be added.
+func (e *LocalNoncesEncoder) Add(txid chainhash.Hash, nonce Musig2Nonce) error {
+	switch {
+	case e.finished:
+		return ErrLocalNoncesEncoderFinished
+
+	case e.numEntries == MaxLocalNonces:
+		return fmt.Errorf("%w: max is %d", ErrTooManyLocalNonces,
+			MaxLocalNonces)
+
+	case e.numEntries > 0 && bytes.Compare(txid[:], e.prevTxid[:]) <= 0:
+		return fmt.Errorf("%w: txid %v doesn't follow %v",
+			ErrLocalNoncesOutOfOrder, txid, e.prevTxid)
+	}
+
+	var w io.Writer = &e.body
+	if e.ws != nil {
+		w = e.ws
+	}
+	if err := writeLocalNonceEntry(w, txid, nonce); err != nil {
+		return err
+	}
+
+	e.numEntries++
+	e.prevTxid = txid
+
+	return nil
+}
+
+// Finish completes the record by writing its entry count. For seekable
+// writers, the position is restored to the end of the record afterwards. No
+// more entries can be added once Finish was called.
+func (e *LocalNoncesEncoder) Finish() error {
+	if e.finished {
+		return ErrLocalNoncesEncoderFinished
+	}
+	e.finished = true
+
+	if e.ws == nil {
+		if err := writeLocalNoncesCount(e.w, e.numEntries); err != nil {
+			return err
+		}
+		_, err := e.w.Write(e.body.Bytes())
+
+		return err
+	}
+
+	end, err := e.ws.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := e.ws.Seek(e.start, io.SeekStart); err != nil {
+		return err
+	}
+	if err := writeLocalNoncesCount(e.ws, e.numEntries); err != nil {
+		return err
+	}
+	_, err = e.ws.Seek(end, io.SeekStart)
+
+	return err
+}
@@ -0,0 +1,122 @@
+package lnwire
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// seekBuffer is an in-memory io.WriteSeeker.
+type seekBuffer struct {
+	buf []byte
+	pos int
+}
+
+// Write writes p at the current position, growing the buffer as needed.
+func (s *seekBuffer) Write(p []byte) (int, error) {
+	if end := s.pos + len(p); end > len(s.buf) {
+		s.buf = append(s.buf, make([]byte, end-len(s.buf))...)
+	}
+	n := copy(s.buf[s.pos:], p)
+	s.pos += n
+
+	return n, nil
+}
+
+// Seek moves the current position.
+func (s *seekBuffer) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += int64(s.pos)
+	case io.SeekEnd:
+		offset += int64(len(s.buf))
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	s.pos = int(offset)
+
+	return offset, nil
+}
+
+// TestLocalNoncesEncoder tests that streaming entries into the encoder results
+// in the record encoding, both for seekable and other writers.
+func TestLocalNoncesEncoder(t *testing.T) {
+	t.Parallel()
+
+	for _, n := range []int{0, 1, 5} {
+		nonces := makeTestLocalNonces(n)
+		expected := encodeTestLocalNonces(t, nonces)
+
+		// Stream the entries from a cursor over the encoded record,
+		// just like a relay would.
+		streamTo := func(w io.Writer) {
+			cursor, err := NewLocalNoncesCursor(
+				bytes.NewReader(expected),
+				uint64(len(expected)),
+			)
+			require.NoError(t, err)
+
+			enc, err := NewLocalNoncesEncoder(w)
+			require.NoError(t, err)
+			for cursor.Next() {
+				entry := cursor.Entry()
+				err := enc.Add(entry.TXID, entry.Nonce)
+				require.NoError(t, err)
+			}
+			require.NoError(t, cursor.Err())
+			require.NoError(t, enc.Finish())
+		}
+
+		// The seekable writer has a prefix ahead of the record, so
+		// the count must be backfilled where the record started.
+		prefix := []byte{0xaa, 0xbb, 0xcc}
+		seekable := &seekBuffer{}
+		_, err := seekable.Write(prefix)
+		require.NoError(t, err)
+		streamTo(seekable)
+		require.Equal(t, append(prefix, expected...), seekable.buf)
+
+		// Anything written next must follow the record.
+		require.Equal(t, len(seekable.buf), seekable.pos)
+
+		var buffered bytes.Buffer
+		streamTo(&buffered)
+		require.Equal(t, expected, buffered.Bytes())
+	}
+}
+
+// TestLocalNoncesEncoderFailures tests that entries that would result in a
+// non-canonical record are rejected.
+func TestLocalNoncesEncoderFailures(t *testing.T) {
+	t.Parallel()
+
+	var b bytes.Buffer
+	enc, err := NewLocalNoncesEncoder(&b)
+	require.NoError(t, err)
+
+	require.NoError(t, enc.Add(makeTestTxId(2), makeTestNonce(1)))
+
+	err = enc.Add(makeTestTxId(1), makeTestNonce(1))
+	require.ErrorIs(t, err, ErrLocalNoncesOutOfOrder)
+
+	err = enc.Add(makeTestTxId(2), makeTestNonce(1))
+	require.ErrorIs(t, err, ErrLocalNoncesOutOfOrder)
+
+	require.NoError(t, enc.Finish())
+	require.Equal(
+		t, encodeTestLocalNonces(
+			t, SingleLocalNonce(makeTestTxId(2), makeTestNonce(1)),
+		), b.Bytes(),
+	)
+
+	err = enc.Add(makeTestTxId(3), makeTestNonce(1))
+	require.ErrorIs(t, err, ErrLocalNoncesEncoderFinished)
+	require.ErrorIs(t, enc.Finish(), ErrLocalNoncesEncoderFinished)
+}
@@ -0,0 +1,59 @@
+package lnwire
+
+import (
+	"sync"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+// SyncLocalNonces wraps a LocalNoncesData so that it can safely be shared
+// between goroutines, for instance between the peer's read and write loops.
+type SyncLocalNonces struct {
+	// nonces is the wrapped set.
+	//
+	// NOTE: This must only be accessed while holding mtx.
+	nonces *LocalNoncesData
+
+	// mtx guards nonces.
+	mtx sync.RWMutex
+}
+
+// NewSyncLocalNonces returns a SyncLocalNonces holding a copy of the entries
+// of lnd, which may be nil to start out with an empty set.
+func NewSyncLocalNonces(lnd *LocalNoncesData) *SyncLocalNonces {
+	return &SyncLocalNonces{
+		nonces: copyLocalNonces(lnd),
+	}
+}
+
+// Get returns the nonce of the given txid. The returned bool is false if the
+// set holds no entry for the txid.
+func (s *SyncLocalNonces) Get(txid chainhash.Hash) (Musig2Nonce, bool) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	return s.nonces.Get(txid)
+}
+
+// Snapshot returns a copy of the set that can be used without holding any
+// lock, as later changes to the shared set don't affect it.
+func (s *SyncLocalNonces) Snapshot() *LocalNoncesData {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+
+	return copyLocalNonces(s.nonces)
+}
+
+// ApplyDelta deletes the entries of the txids in remove and merges add into
+// the set, following the semantics of LocalNoncesData.Apply. The whole delta
+// is applied while holding the write lock, so concurrent readers either
+// observe the set as it was before the call or with the delta fully applied,
+// never anything in between. On failure, the set is left as it was.
+func (s *SyncLocalNonces) ApplyDelta(add *LocalNoncesData,
+	remove []chainhash.Hash) error {
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return s.nonces.Apply(add, remove)
+}
@@ -0,0 +1,107 @@
+package lnwire
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/stretchr/testify/require"
+)
+
+// TestSyncLocalNoncesApplyDelta tests that concurrent readers of a
+// SyncLocalNonces only ever observe the set before or after a delta was
+// applied, and never a partially applied one.
+func TestSyncLocalNoncesApplyDelta(t *testing.T) {
+	t.Parallel()
+
+	const numDeltas = 50
+
+	// Each delta replaces all entries of the previous state with a new
+	// batch of entries, so that a half-applied delta would result in a
+	// set that isn't one of the expected states.
+	var (
+		adds    []*LocalNoncesData
+		removes [][]chainhash.Hash
+		states  = make(map[[32]byte]struct{})
+	)
+	state := makeTestLocalNonces(4)
+	digest, err := state.Digest()
+	require.NoError(t, err)
+	states[digest] = struct{}{}
+
+	s := NewSyncLocalNonces(state)
+	for i := 0; i < numDeltas; i++ {
+		add := &LocalNoncesData{
+			NoncesMap: make(map[chainhash.Hash]Musig2Nonce),
+		}
+		for j := 0; j < 4; j++ {
+			id := byte(i*4 + j + 10)
+			add.NoncesMap[makeTestTxId(id)] = makeTestNonce(id)
+		}
+
+		remove := state.sortedTxids()
+		state = add
+
+		digest, err := state.Digest()
+		require.NoError(t, err)
+		states[digest] = struct{}{}
+
+		adds = append(adds, add)
+		removes = append(removes, remove)
+	}
+
+	// The reader reports the first unexpected state it observes, if any.
+	var (
+		wg       sync.WaitGroup
+		done     = make(chan struct{})
+		observed = make(chan [32]byte, 1)
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+
+			digest, err := s.Snapshot().Digest()
+			if _, ok := states[digest]; err != nil || !ok {
+				observed <- digest
+				return
+			}
+		}
+	}()
+
+	for i := range adds {
+		require.NoError(t, s.ApplyDelta(adds[i], removes[i]))
+	}
+	close(done)
+	wg.Wait()
+
+	select {
+	case digest := <-observed:
+		t.Fatalf("reader observed unexpected state %x", digest)
+	default:
+	}
+
+	require.Equal(t, state.NoncesMap, s.Snapshot().NoncesMap)
+
+	// A conflicting delta leaves the set untouched, even if it came with
+	// removals.
+	txid := state.sortedTxids()[0]
+	conflict := &LocalNoncesData{
+		NoncesMap: map[chainhash.Hash]Musig2Nonce{
+			txid: makeTestNonce(0xff),
+		},
+	}
+	err = s.ApplyDelta(conflict, state.sortedTxids()[1:])
+	require.ErrorIs(t, err, ErrLocalNonceConflict)
+	require.Equal(t, state.NoncesMap, s.Snapshot().NoncesMap)
+
+	nonce, ok := s.Get(txid)
+	require.True(t, ok)
+	require.Equal(t, state.NoncesMap[txid], nonce)
+}
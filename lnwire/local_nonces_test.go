@@ -0,0 +1,2088 @@
+package lnwire
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"math/rand"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/lightningnetwork/lnd/tlv"
+	"github.com/stretchr/testify/require"
+)
+
+// makeTestNonce returns a nonce with every byte set to b.
+func makeTestNonce(b byte) Musig2Nonce {
+	var nonce Musig2Nonce
+	for i := range nonce {
+		nonce[i] = b
+	}
+
+	return nonce
+}
+
+// makeTestTxId returns a txid with every byte set to b.
+func makeTestTxId(b byte) chainhash.Hash {
+	var txid chainhash.Hash
+	for i := range txid {
+		txid[i] = b
+	}
+
+	return txid
+}
+
+// makeTestLocalNonces returns a set of n entries, keyed by the txids 1..n
+// with the matching nonces n+1..2n.
+func makeTestLocalNonces(n int) *LocalNoncesData {
+	nonces := make(map[chainhash.Hash]Musig2Nonce, n)
+	for i := 1; i <= n; i++ {
+		nonces[makeTestTxId(byte(i))] = makeTestNonce(byte(n + i))
+	}
+
+	return &LocalNoncesData{NoncesMap: nonces}
+}
+
+// encodeTestLocalNonces returns the record value encoding of the given set.
+func encodeTestLocalNonces(t testing.TB, lnd *LocalNoncesData) []byte {
+	t.Helper()
+
+	var b bytes.Buffer
+	record := lnd.Record()
+	require.NoError(t, record.Encode(&b))
+
+	return b.Bytes()
+}
+
+// requireStrictlyAscending asserts that the entries are in strictly ascending
+// txid order, which implies that no two of them share a txid. This guards the
+// assumption of the encoder that the txids it sorts are unique.
+func requireStrictlyAscending(t *testing.T, entries []LocalNonceEntry) {
+	t.Helper()
+
+	for i := 1; i < len(entries); i++ {
+		prev, txid := entries[i-1].TXID, entries[i].TXID
+		require.Less(
+			t, bytes.Compare(prev[:], txid[:]), 0,
+			"entry %d (%v) doesn't follow %v", i, txid, prev,
+		)
+	}
+}
+
+// plainReader hides any interfaces of the wrapped reader beyond io.Reader,
+// forcing the decoder onto its generic read path.
+type plainReader struct {
+	io.Reader
+}
+
+// TestLocalNoncesDataEncodeDecode tests that a set of local nonces can be
+// round-tripped through a TLV stream.
+func TestLocalNoncesDataEncodeDecode(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		name      string
+		inputData *LocalNoncesData
+	}{
+		{
+			name: "empty map",
+			inputData: &LocalNoncesData{
+				NoncesMap: map[chainhash.Hash]Musig2Nonce{},
+			},
+		},
+		{
+			name:      "single entry",
+			inputData: makeTestLocalNonces(1),
+		},
+		{
+			name:      "multiple entries",
+			inputData: makeTestLocalNonces(5),
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var b bytes.Buffer
+			stream, err := tlv.NewStream(tc.inputData.Record())
+			require.NoError(t, err)
+			require.NoError(t, stream.Encode(&b))
+
+			var decoded LocalNoncesData
+			stream, err = tlv.NewStream(decoded.Record())
+			require.NoError(t, err)
+			require.NoError(t, stream.Decode(&b))
+
+			require.Equal(t, tc.inputData.NoncesMap,
+				decoded.NoncesMap)
+
+			requireStrictlyAscending(
+				t, tc.inputData.SortedEntries(),
+			)
+			requireStrictlyAscending(t, decoded.SortedEntries())
+		})
+	}
+}
+
+// TestLocalNoncesDataSortedEncoding asserts that entries are always written
+// in ascending txid order, regardless of map iteration order.
+func TestLocalNoncesDataSortedEncoding(t *testing.T) {
+	t.Parallel()
+
+	input := &LocalNoncesData{
+		NoncesMap: map[chainhash.Hash]Musig2Nonce{
+			makeTestTxId(3): makeTestNonce(30),
+			makeTestTxId(1): makeTestNonce(10),
+			makeTestTxId(2): makeTestNonce(20),
+		},
+	}
+
+	encoded := encodeTestLocalNonces(t, input)
+	require.Len(t, encoded, localNoncesCountSize+3*localNonceEntrySize)
+	require.Equal(t, uint16(3), binary.BigEndian.Uint16(encoded))
+
+	body := encoded[localNoncesCountSize:]
+	for i := byte(1); i <= 3; i++ {
+		txid := makeTestTxId(i)
+		nonce := makeTestNonce(i * 10)
+		require.Equal(t, txid[:], body[:chainhash.HashSize])
+		require.Equal(
+			t, nonce[:],
+			body[chainhash.HashSize:localNonceEntrySize],
+		)
+
+		body = body[localNonceEntrySize:]
+	}
+}
+
+// TestLocalNoncesDataDecodeFailures tests that malformed records are rejected
+// by the decoder.
+func TestLocalNoncesDataDecodeFailures(t *testing.T) {
+	t.Parallel()
+
+	valid := encodeTestLocalNonces(t, makeTestLocalNonces(2))
+
+	duplicate := encodeTestLocalNonces(t, makeTestLocalNonces(2))
+	copy(
+		duplicate[localNoncesCountSize+localNonceEntrySize:],
+		duplicate[localNoncesCountSize:localNoncesCountSize+
+			chainhash.HashSize],
+	)
+
+	// A zero count followed by what looks like a full entry.
+	zeroCountWithData := bytes.Clone(valid[:localNoncesCountSize+
+		localNonceEntrySize])
+	zeroCountWithData[0], zeroCountWithData[1] = 0, 0
+
+	testCases := []struct {
+		name      string
+		value     []byte
+		recordLen uint64
+		expErr    error
+	}{
+		{
+			name:      "one byte record",
+			value:     []byte{0x00},
+			recordLen: 1,
+			expErr:    ErrLocalNoncesRecordTooShort,
+		},
+		{
+			name:      "record length too long",
+			value:     valid,
+			recordLen: uint64(len(valid)) + 1,
+			expErr:    ErrLocalNoncesLengthMismatch,
+		},
+		{
+			name:      "record length too short",
+			value:     valid,
+			recordLen: uint64(len(valid)) - 1,
+			expErr:    ErrLocalNoncesLengthMismatch,
+		},
+		{
+			name:      "truncated body",
+			value:     valid[:len(valid)-1],
+			recordLen: uint64(len(valid)),
+			expErr:    io.ErrUnexpectedEOF,
+		},
+		{
+			name:      "duplicate txid",
+			value:     duplicate,
+			recordLen: uint64(len(duplicate)),
+			expErr:    ErrLocalNoncesDuplicateTxid,
+		},
+		{
+			name:      "zero count with data",
+			value:     zeroCountWithData,
+			recordLen: uint64(len(zeroCountWithData)),
+			expErr:    ErrLocalNoncesZeroCountWithData,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			readers := []io.Reader{
+				bytes.NewReader(tc.value),
+				&plainReader{bytes.NewReader(tc.value)},
+			}
+			for _, r := range readers {
+				var decoded LocalNoncesData
+				var buf [8]byte
+				err := decodeLocalNoncesData(
+					r, &decoded, &buf, tc.recordLen,
+				)
+				require.ErrorIs(t, err, tc.expErr)
+			}
+
+			// The entries decoder must reject the same records.
+			_, err := DecodeLocalNoncesAsEntries(
+				bytes.NewReader(tc.value), tc.recordLen,
+			)
+			require.ErrorIs(t, err, tc.expErr)
+		})
+	}
+}
+
+// TestLocalNoncesDataDecodeFastPath asserts that the bulk decode path used for
+// io.ByteReader implementations produces the same result as the generic path.
+func TestLocalNoncesDataDecodeFastPath(t *testing.T) {
+	t.Parallel()
+
+	for _, n := range []int{0, 1, 2, 50} {
+		encoded := encodeTestLocalNonces(t, makeTestLocalNonces(n))
+
+		var (
+			fast, slow LocalNoncesData
+			buf        [8]byte
+		)
+		err := decodeLocalNoncesData(
+			bytes.NewReader(encoded), &fast, &buf,
+			uint64(len(encoded)),
+		)
+		require.NoError(t, err)
+
+		err = decodeLocalNoncesData(
+			&plainReader{bytes.NewReader(encoded)}, &slow, &buf,
+			uint64(len(encoded)),
+		)
+		require.NoError(t, err)
+
+		require.Equal(t, slow.NoncesMap, fast.NoncesMap)
+		require.Equal(
+			t, makeTestLocalNonces(n).NoncesMap, fast.NoncesMap,
+		)
+	}
+}
+
+// BenchmarkDecodeLocalNonces compares the bulk decode path taken for
+// in-memory readers against the generic per-entry path.
+func BenchmarkDecodeLocalNonces(b *testing.B) {
+	encoded := encodeTestLocalNonces(b, makeTestLocalNonces(100))
+	recordLen := uint64(len(encoded))
+
+	b.Run("bytes.Reader", func(b *testing.B) {
+		var buf [8]byte
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var decoded LocalNoncesData
+			err := decodeLocalNoncesData(
+				bytes.NewReader(encoded), &decoded, &buf,
+				recordLen,
+			)
+			require.NoError(b, err)
+		}
+	})
+
+	b.Run("io.Reader", func(b *testing.B) {
+		var buf [8]byte
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var decoded LocalNoncesData
+			err := decodeLocalNoncesData(
+				&plainReader{bytes.NewReader(encoded)},
+				&decoded, &buf, recordLen,
+			)
+			require.NoError(b, err)
+		}
+	})
+}
+
+// TestLocalNoncesDataCorruptStreamLength tests that when the record is
+// embedded in a larger TLV stream with a corrupted length prefix, the
+// decoder's own length check catches the mismatch instead of reading into
+// the neighbouring records.
+func TestLocalNoncesDataCorruptStreamLength(t *testing.T) {
+	t.Parallel()
+
+	const (
+		beforeType tlv.Type = 20
+		afterType  tlv.Type = 24
+	)
+
+	var (
+		before = uint64(1234)
+		after  = uint64(5678)
+		nonces = makeTestLocalNonces(2)
+	)
+
+	stream, err := tlv.NewStream(
+		tlv.MakePrimitiveRecord(beforeType, &before),
+		nonces.Record(),
+		tlv.MakePrimitiveRecord(afterType, &after),
+	)
+	require.NoError(t, err)
+
+	var b bytes.Buffer
+	require.NoError(t, stream.Encode(&b))
+	encoded := b.Bytes()
+
+	// The leading record is a single byte type, a single byte length and
+	// an 8 byte value, so our record's type follows at offset 10 and its
+	// (single byte) length right after it.
+	require.Equal(t, byte(localNoncesRecordType), encoded[10])
+	lengthIdx := 11
+	recordLen := uint64(localNoncesCountSize + 2*localNonceEntrySize)
+	require.Equal(t, byte(recordLen), encoded[lengthIdx])
+
+	// Sanity check that the stream decodes fine before corrupting it.
+	var (
+		decBefore, decAfter uint64
+		decoded             LocalNoncesData
+	)
+	decStream, err := tlv.NewStream(
+		tlv.MakePrimitiveRecord(beforeType, &decBefore),
+		decoded.Record(),
+		tlv.MakePrimitiveRecord(afterType, &decAfter),
+	)
+	require.NoError(t, err)
+	require.NoError(t, decStream.Decode(bytes.NewReader(encoded)))
+	require.Equal(t, nonces.NoncesMap, decoded.NoncesMap)
+
+	corruptLens := []uint64{
+		recordLen - 1,
+		recordLen + 1,
+		recordLen - localNonceEntrySize,
+		recordLen + 3,
+	}
+	for _, corruptLen := range corruptLens {
+		corrupted := bytes.Clone(encoded)
+		corrupted[lengthIdx] = byte(corruptLen)
+
+		err := decStream.Decode(bytes.NewReader(corrupted))
+		require.ErrorIs(t, err, ErrLocalNoncesLengthMismatch)
+	}
+}
+
+// TestSingleLocalNonce tests that SingleLocalNonce produces a one entry set
+// that encodes to a count followed by exactly one entry.
+func TestSingleLocalNonce(t *testing.T) {
+	t.Parallel()
+
+	txid, nonce := makeTestTxId(1), makeTestNonce(2)
+	single := SingleLocalNonce(txid, nonce)
+
+	require.Equal(
+		t, map[chainhash.Hash]Musig2Nonce{txid: nonce},
+		single.NoncesMap,
+	)
+
+	encoded := encodeTestLocalNonces(t, single)
+	require.Len(t, encoded, 100)
+	require.Equal(t, []byte{0x00, 0x01}, encoded[:localNoncesCountSize])
+	require.Equal(t, txid[:], encoded[2:34])
+	require.Equal(t, nonce[:], encoded[34:])
+
+	// The single entry fast path of AppendTo must produce the same bytes.
+	appended, err := single.AppendTo(nil)
+	require.NoError(t, err)
+	require.Equal(t, encoded, appended)
+}
+
+// TestLocalNoncesDataShortRecordLen pins the decoder's behavior for record
+// lengths that are too short to hold the entry count.
+func TestLocalNoncesDataShortRecordLen(t *testing.T) {
+	t.Parallel()
+
+	var buf [8]byte
+
+	// A single stray byte can't hold the count and must be rejected.
+	var decoded LocalNoncesData
+	err := decodeLocalNoncesData(
+		bytes.NewReader([]byte{0x01}), &decoded, &buf, 1,
+	)
+	require.ErrorIs(t, err, ErrLocalNoncesRecordTooShort)
+	require.Nil(t, decoded.NoncesMap)
+
+	// A zero length record is an empty set, and must not consume any of
+	// the bytes that follow it in the reader.
+	r := bytes.NewReader([]byte{0x00, 0x01, 0x02})
+	err = decodeLocalNoncesData(r, &decoded, &buf, 0)
+	require.NoError(t, err)
+	require.NotNil(t, decoded.NoncesMap)
+	require.Empty(t, decoded.NoncesMap)
+	require.Equal(t, 3, r.Len())
+}
+
+// TestLocalNoncesDataConcurrentDecode tests that the decoder can safely be
+// called from many goroutines at once. The decoder must not touch any shared
+// state, which this test verifies when run with the race detector.
+func TestLocalNoncesDataConcurrentDecode(t *testing.T) {
+	t.Parallel()
+
+	numWorkers, numRounds := 32, 50
+	if testing.Short() {
+		numRounds = 5
+	}
+
+	// Each worker decodes its own distinct record, so a decoder that
+	// leaks state between calls would produce the wrong set.
+	records := make([][]byte, numWorkers)
+	for i := range records {
+		records[i] = encodeTestLocalNonces(
+			t, makeTestLocalNonces(i+1),
+		)
+	}
+
+	// decodeRecord decodes the record of the given worker, alternating
+	// between the bulk and the generic decode paths.
+	decodeRecord := func(worker, round int) error {
+		var r io.Reader = bytes.NewReader(records[worker])
+		if round%2 == 1 {
+			r = &plainReader{r}
+		}
+
+		var (
+			decoded LocalNoncesData
+			buf     [8]byte
+		)
+		err := decodeLocalNoncesData(
+			r, &decoded, &buf, uint64(len(records[worker])),
+		)
+		if err != nil {
+			return err
+		}
+
+		expected := makeTestLocalNonces(worker + 1)
+		if !maps.Equal(decoded.NoncesMap, expected.NoncesMap) {
+			return fmt.Errorf("worker %d: decoded wrong set in "+
+				"round %d", worker, round)
+		}
+
+		return nil
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, numWorkers)
+	for i := 0; i < numWorkers; i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+
+			for round := 0; round < numRounds; round++ {
+				err := decodeRecord(worker, round)
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(i)
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		require.NoError(t, err)
+	}
+}
+
+// TestLocalNoncesDataAppendTo tests that AppendTo appends the exact record
+// encoding while leaving the existing contents of the buffer untouched.
+func TestLocalNoncesDataAppendTo(t *testing.T) {
+	t.Parallel()
+
+	for _, n := range []int{0, 1, 5} {
+		nonces := makeTestLocalNonces(n)
+		encoded := encodeTestLocalNonces(t, nonces)
+
+		prefix := []byte{0xde, 0xad, 0xbe, 0xef}
+		b, err := nonces.AppendTo(bytes.Clone(prefix))
+		require.NoError(t, err)
+
+		require.Equal(t, prefix, b[:len(prefix)])
+		require.Equal(t, encoded, b[len(prefix):])
+	}
+}
+
+// BenchmarkEncodeLocalNonces compares encoding through the TLV record's
+// io.Writer based encoder against appending to a reused buffer.
+func BenchmarkEncodeLocalNonces(b *testing.B) {
+	nonces := makeTestLocalNonces(100)
+
+	b.Run("io.Writer", func(b *testing.B) {
+		var w bytes.Buffer
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			w.Reset()
+			record := nonces.Record()
+			require.NoError(b, record.Encode(&w))
+		}
+	})
+
+	b.Run("AppendTo", func(b *testing.B) {
+		var (
+			buf []byte
+			err error
+		)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			buf, err = nonces.AppendTo(buf[:0])
+			require.NoError(b, err)
+		}
+	})
+}
+
+// TestLocalNoncesDataDecodeReplaces tests that decoding into a set that
+// already holds entries replaces them rather than merging into them.
+func TestLocalNoncesDataDecodeReplaces(t *testing.T) {
+	t.Parallel()
+
+	// existing returns a set of three entries that don't overlap with
+	// the ones being decoded.
+	existing := func() *LocalNoncesData {
+		return &LocalNoncesData{
+			NoncesMap: map[chainhash.Hash]Musig2Nonce{
+				makeTestTxId(10): makeTestNonce(10),
+				makeTestTxId(11): makeTestNonce(11),
+				makeTestTxId(12): makeTestNonce(12),
+			},
+		}
+	}
+
+	var buf [8]byte
+
+	// A two entry record leaves exactly those two entries.
+	twoEntries := makeTestLocalNonces(2)
+	encoded := encodeTestLocalNonces(t, twoEntries)
+	target := existing()
+	err := decodeLocalNoncesData(
+		bytes.NewReader(encoded), target, &buf, uint64(len(encoded)),
+	)
+	require.NoError(t, err)
+	require.Equal(t, twoEntries.NoncesMap, target.NoncesMap)
+
+	// Both the zero length and the zero count empty records leave an
+	// empty set.
+	empty := encodeTestLocalNonces(t, &LocalNoncesData{})
+	for _, value := range [][]byte{nil, empty} {
+		target := existing()
+		err := decodeLocalNoncesData(
+			bytes.NewReader(value), target, &buf,
+			uint64(len(value)),
+		)
+		require.NoError(t, err)
+		require.NotNil(t, target.NoncesMap)
+		require.Empty(t, target.NoncesMap)
+	}
+
+	// A failed decode leaves the target untouched.
+	target = existing()
+	err = decodeLocalNoncesData(
+		bytes.NewReader(encoded[:len(encoded)-1]), target, &buf,
+		uint64(len(encoded)),
+	)
+	require.Error(t, err)
+	require.Equal(t, existing().NoncesMap, target.NoncesMap)
+}
+
+// TestExtractLocalNonces tests that the local nonces record can be extracted
+// from a message's extra opaque data.
+func TestExtractLocalNonces(t *testing.T) {
+	t.Parallel()
+
+	const otherType tlv.Type = 23
+	otherValue := uint64(42)
+	otherRecord := tlv.MakePrimitiveRecord(otherType, &otherValue)
+
+	// When the record is present, it's returned alongside any other
+	// records in the stream.
+	nonces := makeTestLocalNonces(3)
+	record := tlv.NewRecordT[LocalNoncesRecordTypeT](*nonces)
+
+	var present ExtraOpaqueData
+	require.NoError(t, present.PackRecords(&record, &otherRecord))
+
+	extracted, err := ExtractLocalNonces(present)
+	require.NoError(t, err)
+	require.True(t, extracted.IsSome())
+	extracted.WhenSomeV(func(lnd LocalNoncesData) {
+		require.Equal(t, nonces.NoncesMap, lnd.NoncesMap)
+	})
+
+	// When the record is absent, None is returned without an error.
+	var absent ExtraOpaqueData
+	require.NoError(t, absent.PackRecords(&otherRecord))
+
+	extracted, err = ExtractLocalNonces(absent)
+	require.NoError(t, err)
+	require.True(t, extracted.IsNone())
+
+	extracted, err = ExtractLocalNonces(nil)
+	require.NoError(t, err)
+	require.True(t, extracted.IsNone())
+
+	// A present but malformed record results in an error.
+	malformed := ExtraOpaqueData{byte(localNoncesRecordType), 0x01, 0x00}
+	_, err = ExtractLocalNonces(malformed)
+	require.ErrorIs(t, err, ErrLocalNoncesRecordTooShort)
+}
+
+// TestParseLocalNoncesPrefix tests that a record can be parsed off the front
+// of a larger buffer, reporting where the record ends.
+func TestParseLocalNoncesPrefix(t *testing.T) {
+	t.Parallel()
+
+	nonces := makeTestLocalNonces(3)
+	encoded := encodeTestLocalNonces(t, nonces)
+	trailer := []byte{0x01, 0x02, 0x03}
+
+	b := append(bytes.Clone(encoded), trailer...)
+	parsed, n, err := ParseLocalNoncesPrefix(b)
+	require.NoError(t, err)
+	require.Equal(t, nonces.NoncesMap, parsed.NoncesMap)
+	require.Equal(t, len(encoded), n)
+	require.Equal(t, trailer, b[n:])
+
+	// An empty set only consumes the count.
+	parsed, n, err = ParseLocalNoncesPrefix([]byte{0x00, 0x00, 0xff})
+	require.NoError(t, err)
+	require.Empty(t, parsed.NoncesMap)
+	require.Equal(t, localNoncesCountSize, n)
+
+	// A buffer that can't hold the count, or the entries the count
+	// claims, is rejected.
+	_, _, err = ParseLocalNoncesPrefix([]byte{0x00})
+	require.ErrorIs(t, err, ErrLocalNoncesRecordTooShort)
+
+	_, _, err = ParseLocalNoncesPrefix(encoded[:len(encoded)-1])
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+// TestLocalNoncesDataZeroize tests that Zeroize leaves an empty, but still
+// usable, set behind.
+func TestLocalNoncesDataZeroize(t *testing.T) {
+	t.Parallel()
+
+	nonces := makeTestLocalNonces(3)
+	nonces.Zeroize()
+	require.NotNil(t, nonces.NoncesMap)
+	require.Empty(t, nonces.NoncesMap)
+
+	// The set can be reused afterwards.
+	nonces.NoncesMap[makeTestTxId(1)] = makeTestNonce(1)
+	require.Len(t, nonces.NoncesMap, 1)
+
+	// Zeroizing a set without a map is a no-op.
+	var empty LocalNoncesData
+	empty.Zeroize()
+	require.Nil(t, empty.NoncesMap)
+}
+
+// TestLocalNoncesDataRedacted tests that Redacted keeps the txids of the set
+// while replacing every nonce with the placeholder, leaving the original set
+// untouched.
+func TestLocalNoncesDataRedacted(t *testing.T) {
+	t.Parallel()
+
+	nonces := makeTestLocalNonces(3)
+	redacted := nonces.Redacted()
+
+	require.ElementsMatch(
+		t, slices.Collect(maps.Keys(nonces.NoncesMap)),
+		slices.Collect(maps.Keys(redacted.NoncesMap)),
+	)
+	for _, nonce := range redacted.NoncesMap {
+		require.Equal(t, Musig2Nonce{}, nonce)
+	}
+	require.Equal(t, makeTestLocalNonces(3), nonces)
+
+	// Redacting a nil set results in an empty one.
+	var empty *LocalNoncesData
+	require.Zero(t, empty.Redacted().Len())
+}
+
+// countingReader counts the number of reads made to the wrapped reader, and
+// optionally returns at most a single byte per read.
+type countingReader struct {
+	r       io.Reader
+	oneByte bool
+	reads   int
+}
+
+// Read implements io.Reader.
+func (c *countingReader) Read(p []byte) (int, error) {
+	c.reads++
+	if c.oneByte && len(p) > 1 {
+		p = p[:1]
+	}
+
+	return c.r.Read(p)
+}
+
+// TestLocalNoncesDataBufferedDecode tests that readers that aren't buffered
+// are decoded correctly through the internal buffered reader, which must not
+// read past the end of the record.
+func TestLocalNoncesDataBufferedDecode(t *testing.T) {
+	t.Parallel()
+
+	nonces := makeTestLocalNonces(100)
+	encoded := encodeTestLocalNonces(t, nonces)
+	trailer := []byte{0x01, 0x02, 0x03}
+
+	for _, oneByte := range []bool{false, true} {
+		underlying := bytes.NewReader(append(
+			bytes.Clone(encoded), trailer...,
+		))
+		r := &countingReader{r: underlying, oneByte: oneByte}
+
+		var (
+			decoded LocalNoncesData
+			buf     [8]byte
+		)
+		err := decodeLocalNoncesData(
+			r, &decoded, &buf, uint64(len(encoded)),
+		)
+		require.NoError(t, err)
+		require.Equal(t, nonces.NoncesMap, decoded.NoncesMap)
+
+		// Nothing beyond the record may have been consumed.
+		require.Equal(t, len(trailer), underlying.Len())
+
+		// Without a buffer, we'd make two reads per entry. With it,
+		// the count takes a single read and the body fits into a
+		// single fill of the buffer.
+		if !oneByte {
+			require.LessOrEqual(t, r.reads, 3)
+		}
+	}
+}
+
+// BenchmarkDecodeLocalNoncesUnbuffered measures the number of reads made to
+// an unbuffered reader when decoding, with and without the internal buffered
+// reader.
+func BenchmarkDecodeLocalNoncesUnbuffered(b *testing.B) {
+	nonces := makeTestLocalNonces(100)
+	encoded := encodeTestLocalNonces(b, nonces)
+	body := encoded[localNoncesCountSize:]
+
+	b.Run("buffered", func(b *testing.B) {
+		var (
+			buf   [8]byte
+			reads int
+		)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			r := &countingReader{r: bytes.NewReader(encoded)}
+
+			var decoded LocalNoncesData
+			err := decodeLocalNoncesData(
+				r, &decoded, &buf, uint64(len(encoded)),
+			)
+			require.NoError(b, err)
+
+			reads += r.reads
+		}
+		b.ReportMetric(float64(reads)/float64(b.N), "reads/op")
+	})
+
+	b.Run("unbuffered", func(b *testing.B) {
+		var reads int
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			r := &countingReader{r: bytes.NewReader(body)}
+
+			nonces := make(map[chainhash.Hash]Musig2Nonce, 100)
+			err := decodeLocalNonceEntries(r, 100, nonces)
+			require.NoError(b, err)
+
+			reads += r.reads
+		}
+		b.ReportMetric(float64(reads)/float64(b.N), "reads/op")
+	})
+}
+
+// TestParseLocalNoncesRecord tests that a record value is only parsed as a
+// LocalNoncesData when it comes from the local nonces TLV type.
+func TestParseLocalNoncesRecord(t *testing.T) {
+	t.Parallel()
+
+	nonces := makeTestLocalNonces(2)
+	encoded := encodeTestLocalNonces(t, nonces)
+
+	parsed, err := ParseLocalNoncesRecord(localNoncesRecordType, encoded)
+	require.NoError(t, err)
+	require.Equal(t, nonces.NoncesMap, parsed.NoncesMap)
+
+	// Values of any other type are rejected, whether odd or even, and
+	// even if they happen to be well formed.
+	for _, typ := range []tlv.Type{21, 23, 4} {
+		_, err := ParseLocalNoncesRecord(typ, encoded)
+		require.ErrorIs(t, err, ErrUnexpectedLocalNoncesType)
+	}
+}
+
+// chunkedByteReader returns at most chunkSize bytes per read, without an
+// error, while also implementing io.ByteReader so that the decoder takes its
+// bulk read path.
+type chunkedByteReader struct {
+	r         *bytes.Reader
+	chunkSize int
+}
+
+// Read implements io.Reader.
+func (c *chunkedByteReader) Read(p []byte) (int, error) {
+	if len(p) > c.chunkSize {
+		p = p[:c.chunkSize]
+	}
+
+	return c.r.Read(p)
+}
+
+// ReadByte implements io.ByteReader.
+func (c *chunkedByteReader) ReadByte() (byte, error) {
+	return c.r.ReadByte()
+}
+
+// TestLocalNoncesDataShortReads tests that a reader returning the record in
+// small chunks, without an error, is still decoded correctly by every decode
+// path, which must not assume a single read fills a buffer.
+func TestLocalNoncesDataShortReads(t *testing.T) {
+	t.Parallel()
+
+	nonces := makeTestLocalNonces(20)
+	encoded := encodeTestLocalNonces(t, nonces)
+	recordLen := uint64(len(encoded))
+
+	for _, chunkSize := range []int{1, 7, 97} {
+		readers := []io.Reader{
+			&chunkedByteReader{
+				r:         bytes.NewReader(encoded),
+				chunkSize: chunkSize,
+			},
+			&plainReader{&chunkedByteReader{
+				r:         bytes.NewReader(encoded),
+				chunkSize: chunkSize,
+			}},
+		}
+		for _, r := range readers {
+			var (
+				decoded LocalNoncesData
+				buf     [8]byte
+			)
+			err := decodeLocalNoncesData(
+				r, &decoded, &buf, recordLen,
+			)
+			require.NoError(t, err)
+			require.Equal(t, nonces.NoncesMap, decoded.NoncesMap)
+		}
+
+		cursor, err := NewLocalNoncesCursor(&chunkedByteReader{
+			r:         bytes.NewReader(encoded),
+			chunkSize: chunkSize,
+		}, recordLen)
+		require.NoError(t, err)
+
+		decoded := make(map[chainhash.Hash]Musig2Nonce)
+		for cursor.Next() {
+			decoded[cursor.Entry().TXID] = cursor.Entry().Nonce
+		}
+		require.NoError(t, cursor.Err())
+		require.Equal(t, nonces.NoncesMap, decoded)
+	}
+}
+
+// TestDecodeLocalNoncesInto tests that decoding into a caller provided map
+// fully replaces its contents.
+func TestDecodeLocalNoncesInto(t *testing.T) {
+	t.Parallel()
+
+	nonces := makeTestLocalNonces(2)
+	encoded := encodeTestLocalNonces(t, nonces)
+
+	dst := map[chainhash.Hash]Musig2Nonce{
+		makeTestTxId(1):  makeTestNonce(0xaa),
+		makeTestTxId(10): makeTestNonce(10),
+		makeTestTxId(11): makeTestNonce(11),
+	}
+	err := DecodeLocalNoncesInto(
+		bytes.NewReader(encoded), uint64(len(encoded)), dst,
+	)
+	require.NoError(t, err)
+	require.Equal(t, nonces.NoncesMap, dst)
+
+	// An empty record empties the map.
+	require.NoError(t, DecodeLocalNoncesInto(bytes.NewReader(nil), 0, dst))
+	require.NotNil(t, dst)
+	require.Empty(t, dst)
+
+	// The usual length checks apply, and a failure leaves the map empty.
+	maps.Copy(dst, nonces.NoncesMap)
+	err = DecodeLocalNoncesInto(
+		bytes.NewReader(encoded), uint64(len(encoded))+1, dst,
+	)
+	require.ErrorIs(t, err, ErrLocalNoncesLengthMismatch)
+	require.Empty(t, dst)
+
+	maps.Copy(dst, nonces.NoncesMap)
+	err = DecodeLocalNoncesInto(
+		bytes.NewReader(encoded[:len(encoded)-1]),
+		uint64(len(encoded)), dst,
+	)
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	require.Empty(t, dst)
+}
+
+// TestEmptyLocalNoncesData tests that an explicitly empty set is encoded as a
+// present record, distinguishable from an absent one.
+func TestEmptyLocalNoncesData(t *testing.T) {
+	t.Parallel()
+
+	empty := EmptyLocalNoncesData()
+	require.NotNil(t, empty.NoncesMap)
+	require.Empty(t, empty.NoncesMap)
+
+	// packOpt packs the optional record into extra opaque data, if it's
+	// present.
+	packOpt := func(opt OptLocalNonces) ExtraOpaqueData {
+		var eod ExtraOpaqueData
+		opt.WhenSome(func(record LocalNoncesTLV) {
+			require.NoError(t, eod.PackRecords(&record))
+		})
+
+		return eod
+	}
+
+	// The explicit empty set is encoded as the type, a length of two and
+	// a zero count.
+	present := packOpt(SomeLocalNonces(empty))
+	require.Equal(
+		t, ExtraOpaqueData{byte(localNoncesRecordType), 2, 0, 0},
+		present,
+	)
+
+	extracted, err := ExtractLocalNonces(present)
+	require.NoError(t, err)
+	require.True(t, extracted.IsSome())
+	require.True(t, IsPresentEmpty(extracted))
+
+	// Omitting the record is distinguishable from the explicit empty set.
+	var none OptLocalNonces
+	absent := packOpt(none)
+	require.Empty(t, absent)
+
+	extracted, err = ExtractLocalNonces(absent)
+	require.NoError(t, err)
+	require.True(t, extracted.IsNone())
+	require.False(t, IsPresentEmpty(extracted))
+
+	// A present set with entries isn't empty.
+	require.False(t, IsPresentEmpty(SomeLocalNonces(
+		*makeTestLocalNonces(1),
+	)))
+}
+
+// TestLocalNoncesFitsInMessage tests that the encoded size of a set along with
+// the rest of the payload is checked against the maximum message body size.
+func TestLocalNoncesFitsInMessage(t *testing.T) {
+	t.Parallel()
+
+	nonces := makeTestLocalNonces(10)
+	encoded := encodeTestLocalNonces(t, nonces)
+	require.Equal(t, len(encoded), nonces.EncodedSize())
+
+	// Fill the remainder of the message exactly, and then overflow it by
+	// a single byte.
+	otherBytes := MaxMsgBody - len(encoded)
+	require.True(t, nonces.FitsInMessage(otherBytes-1))
+	require.True(t, nonces.FitsInMessage(otherBytes))
+	require.False(t, nonces.FitsInMessage(otherBytes+1))
+
+	// A set with the maximum number of entries fits on its own.
+	maxNonces := &LocalNoncesData{
+		NoncesMap: make(map[chainhash.Hash]Musig2Nonce, MaxLocalNonces),
+	}
+	for i := 0; i < MaxLocalNonces; i++ {
+		var txid chainhash.Hash
+		binary.BigEndian.PutUint16(txid[:], uint16(i))
+		maxNonces.NoncesMap[txid] = makeTestNonce(byte(i))
+	}
+	require.Equal(t, MaxLocalNoncesRecordBytes, maxNonces.EncodedSize())
+	require.True(t, maxNonces.FitsInMessage(0))
+}
+
+// TestLocalNoncesDecodeZeroCountIntoDirty tests that decoding a record with a
+// zero count into a set that already holds entries leaves it empty, with a
+// freshly allocated map rather than the previous one cleared in place.
+func TestLocalNoncesDecodeZeroCountIntoDirty(t *testing.T) {
+	t.Parallel()
+
+	for _, value := range [][]byte{{0x00, 0x00}, nil} {
+		decoded := makeTestLocalNonces(3)
+		prev := decoded.NoncesMap
+		expected := maps.Clone(prev)
+
+		var buf [8]byte
+		err := decodeLocalNoncesData(
+			bytes.NewReader(value), decoded, &buf,
+			uint64(len(value)),
+		)
+		require.NoError(t, err)
+		require.NotNil(t, decoded.NoncesMap)
+		require.Empty(t, decoded.NoncesMap)
+
+		// The previous map still holds its entries, and isn't aliased
+		// by the new one.
+		require.Equal(t, expected, prev)
+		decoded.NoncesMap[makeTestTxId(0xff)] = makeTestNonce(0xff)
+		require.Equal(t, expected, prev)
+	}
+}
+
+// TestLocalNoncesZeroCountWithData tests that a zero count followed by data is
+// reported explicitly, while still being a length mismatch.
+func TestLocalNoncesZeroCountWithData(t *testing.T) {
+	t.Parallel()
+
+	value := make([]byte, localNoncesCountSize+localNonceEntrySize)
+
+	var (
+		decoded LocalNoncesData
+		buf     [8]byte
+	)
+	err := decodeLocalNoncesData(
+		bytes.NewReader(value), &decoded, &buf, uint64(len(value)),
+	)
+	require.ErrorIs(t, err, ErrLocalNoncesZeroCountWithData)
+	require.ErrorIs(t, err, ErrLocalNoncesLengthMismatch)
+
+	// A bare zero count is still a valid empty set.
+	err = decodeLocalNoncesData(
+		bytes.NewReader(value), &decoded, &buf, localNoncesCountSize,
+	)
+	require.NoError(t, err)
+	require.Empty(t, decoded.NoncesMap)
+}
+
+// TestLocalNoncesCountOverflowHint tests that a record whose length implies
+// more entries than the count field can hold, with the count wrapped around,
+// is rejected with a hint at the likely sender bug, while other length
+// mismatches aren't.
+func TestLocalNoncesCountOverflowHint(t *testing.T) {
+	t.Parallel()
+
+	// overflowed returns the record an old sender would have written for
+	// numEntries entries, with the count truncated to 16 bits.
+	overflowed := func(numEntries int) []byte {
+		bodyLen := numEntries * localNonceEntrySize
+		value := make([]byte, localNoncesCountSize+bodyLen)
+		binary.BigEndian.PutUint16(value, uint16(numEntries))
+
+		return value
+	}
+
+	const hint = "possible count overflow by the sender"
+
+	// The count wraps around to zero and one respectively.
+	for _, numEntries := range []int{
+		math.MaxUint16 + 1, math.MaxUint16 + 2,
+	} {
+		value := overflowed(numEntries)
+
+		var (
+			decoded LocalNoncesData
+			buf     [8]byte
+		)
+		err := decodeLocalNoncesData(
+			bytes.NewReader(value), &decoded, &buf,
+			uint64(len(value)),
+		)
+		require.ErrorIs(t, err, ErrLocalNoncesLengthMismatch)
+		require.ErrorContains(t, err, hint)
+		require.ErrorContains(
+			t, err, fmt.Sprintf("implies %d entries", numEntries),
+		)
+	}
+
+	// A count that wraps around to more than MaxLocalNonces is rejected
+	// as too many entries, but still comes with the hint.
+	numEntries := math.MaxUint16 + 1 + MaxLocalNonces + 1
+	value := overflowed(numEntries)
+
+	var (
+		decoded LocalNoncesData
+		buf     [8]byte
+	)
+	err := decodeLocalNoncesData(
+		bytes.NewReader(value), &decoded, &buf, uint64(len(value)),
+	)
+	require.ErrorIs(t, err, ErrTooManyLocalNonces)
+	require.ErrorContains(t, err, hint)
+	require.ErrorContains(
+		t, err, fmt.Sprintf("implies %d entries", numEntries),
+	)
+
+	// A plain length mismatch comes without the hint.
+	value = encodeTestLocalNonces(t, makeTestLocalNonces(2))
+	value = append(value, make([]byte, localNonceEntrySize)...)
+
+	err = decodeLocalNoncesData(
+		bytes.NewReader(value), &decoded, &buf, uint64(len(value)),
+	)
+	require.ErrorIs(t, err, ErrLocalNoncesLengthMismatch)
+	require.NotContains(t, err.Error(), hint)
+}
+
+// TestLocalNoncesMaxValue tests that the all-0xff txid and nonce survive a
+// round trip, which exercises the high bit of every byte.
+func TestLocalNoncesMaxValue(t *testing.T) {
+	t.Parallel()
+
+	txid := makeTestTxId(0xff)
+	nonce := makeTestNonce(0xff)
+	nonces := SingleLocalNonce(txid, nonce)
+
+	encoded := encodeTestLocalNonces(t, nonces)
+	expected := append([]byte{0x00, 0x01}, txid[:]...)
+	expected = append(expected, nonce[:]...)
+	require.Equal(t, expected, encoded)
+
+	var (
+		decoded LocalNoncesData
+		buf     [8]byte
+	)
+	err := decodeLocalNoncesData(
+		bytes.NewReader(encoded), &decoded, &buf, uint64(len(encoded)),
+	)
+	require.NoError(t, err)
+	require.Equal(t, nonce, decoded.NoncesMap[txid])
+
+	// The same must hold for every encoding that can represent the nonce.
+	// The x-only encoding can't, as 0xff isn't a valid point prefix.
+	for _, encoding := range []LocalNoncesEncoding{
+		LocalNoncesEncodingFixed, LocalNoncesEncodingLenPrefixed,
+	} {
+		encoded := encodeTestLocalNoncesWith(t, nonces, encoding)
+		decoded, err := DecodeLocalNoncesWith(
+			bytes.NewReader(encoded), uint64(len(encoded)),
+			encoding,
+		)
+		require.NoError(t, err)
+		require.Equal(t, nonces.NoncesMap, decoded.NoncesMap)
+	}
+}
+
+// TestLocalNoncesNilReceiver tests that the read-only methods and encoders
+// treat a nil set as an empty one rather than panicking.
+func TestLocalNoncesNilReceiver(t *testing.T) {
+	t.Parallel()
+
+	var nonces *LocalNoncesData
+
+	require.Zero(t, nonces.Len())
+	require.True(t, nonces.IsEmpty())
+	require.False(t, nonces.Contains(makeTestTxId(1)))
+
+	nonce, ok := nonces.Get(makeTestTxId(1))
+	require.False(t, ok)
+	require.Equal(t, Musig2Nonce{}, nonce)
+
+	require.Equal(t, localNoncesCountSize, nonces.EncodedSize())
+	require.Empty(t, nonces.SortedEntries())
+
+	// Every encoder writes the empty set.
+	empty := []byte{0x00, 0x00}
+	b, err := nonces.AppendTo(nil)
+	require.NoError(t, err)
+	require.Equal(t, empty, b)
+
+	var buf [8]byte
+	var w bytes.Buffer
+	require.NoError(t, encodeLocalNoncesData(&w, nonces, &buf))
+	require.Equal(t, empty, w.Bytes())
+
+	for _, encoding := range []LocalNoncesEncoding{
+		LocalNoncesEncodingFixed, LocalNoncesEncodingLenPrefixed,
+		LocalNoncesEncodingXOnly,
+	} {
+		w.Reset()
+		require.NoError(t, nonces.EncodeWith(&w, encoding))
+		require.Equal(t, empty, w.Bytes())
+	}
+}
+
+// TestLocalNoncesAccessors tests the read-only accessors of a populated set.
+func TestLocalNoncesAccessors(t *testing.T) {
+	t.Parallel()
+
+	nonces := makeTestLocalNonces(2)
+	require.Equal(t, 2, nonces.Len())
+	require.False(t, nonces.IsEmpty())
+	require.True(t, nonces.Contains(makeTestTxId(1)))
+	require.False(t, nonces.Contains(makeTestTxId(3)))
+
+	nonce, ok := nonces.Get(makeTestTxId(2))
+	require.True(t, ok)
+	require.Equal(t, makeTestNonce(4), nonce)
+
+	require.True(t, (&LocalNoncesData{}).IsEmpty())
+}
+
+// BenchmarkLocalNoncesSingleEntry benchmarks encoding and decoding a set with
+// a single entry, which is by far the most common case as most messages only
+// carry the nonce for a single transaction.
+func BenchmarkLocalNoncesSingleEntry(b *testing.B) {
+	nonces := SingleLocalNonce(makeTestTxId(1), makeTestNonce(2))
+	encoded := encodeTestLocalNonces(b, nonces)
+
+	b.Run("encode", func(b *testing.B) {
+		var (
+			w   bytes.Buffer
+			buf [8]byte
+		)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			w.Reset()
+			err := encodeLocalNoncesData(&w, nonces, &buf)
+			require.NoError(b, err)
+		}
+	})
+
+	b.Run("AppendTo", func(b *testing.B) {
+		var (
+			out []byte
+			err error
+		)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			out, err = nonces.AppendTo(out[:0])
+			require.NoError(b, err)
+		}
+	})
+
+	b.Run("decode", func(b *testing.B) {
+		var buf [8]byte
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			var decoded LocalNoncesData
+			err := decodeLocalNoncesData(
+				bytes.NewReader(encoded), &decoded, &buf,
+				uint64(len(encoded)),
+			)
+			require.NoError(b, err)
+		}
+	})
+}
+
+// TestDecodeLocalNoncesAsEntries tests that a record is decoded into entries
+// in ascending txid order, no matter the order they were written in.
+func TestDecodeLocalNoncesAsEntries(t *testing.T) {
+	t.Parallel()
+
+	nonces := makeTestLocalNonces(5)
+	encoded := encodeTestLocalNonces(t, nonces)
+
+	entries, err := DecodeLocalNoncesAsEntries(
+		bytes.NewReader(encoded), uint64(len(encoded)),
+	)
+	require.NoError(t, err)
+	require.Equal(t, nonces.SortedEntries(), entries)
+
+	// Swap the first and last entries on the wire. The result is still
+	// sorted.
+	swapped := bytes.Clone(encoded)
+	first := swapped[localNoncesCountSize:][:localNonceEntrySize]
+	last := swapped[len(swapped)-localNonceEntrySize:]
+	tmp := bytes.Clone(first)
+	copy(first, last)
+	copy(last, tmp)
+
+	entries, err = DecodeLocalNoncesAsEntries(
+		bytes.NewReader(swapped), uint64(len(swapped)),
+	)
+	require.NoError(t, err)
+	require.Equal(t, nonces.SortedEntries(), entries)
+
+	// The empty record results in no entries.
+	entries, err = DecodeLocalNoncesAsEntries(
+		bytes.NewReader([]byte{0x00, 0x00}), localNoncesCountSize,
+	)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
+
+// TestLocalNoncesDirtyScratchBuffer tests that the contents of the scratch
+// buffer passed in by the tlv package don't influence encoding or decoding.
+func TestLocalNoncesDirtyScratchBuffer(t *testing.T) {
+	t.Parallel()
+
+	for _, n := range []int{0, 1, 3} {
+		nonces := makeTestLocalNonces(n)
+
+		var cleanBuf [8]byte
+		var clean bytes.Buffer
+		require.NoError(t, encodeLocalNoncesData(
+			&clean, nonces, &cleanBuf,
+		))
+
+		for _, fill := range []byte{0x01, 0x80, 0xff} {
+			dirtyBuf := [8]byte{
+				fill, fill, fill, fill, fill, fill, fill, fill,
+			}
+
+			var dirty bytes.Buffer
+			require.NoError(t, encodeLocalNoncesData(
+				&dirty, nonces, &dirtyBuf,
+			))
+			require.Equal(t, clean.Bytes(), dirty.Bytes())
+
+			dirtyBuf = [8]byte{
+				fill, fill, fill, fill, fill, fill, fill, fill,
+			}
+
+			var decoded LocalNoncesData
+			err := decodeLocalNoncesData(
+				bytes.NewReader(dirty.Bytes()), &decoded,
+				&dirtyBuf, uint64(dirty.Len()),
+			)
+			require.NoError(t, err)
+			require.Equal(t, nonces.NoncesMap, decoded.NoncesMap)
+		}
+	}
+}
+
+// TestLocalNoncesUnknownOddNeighbors tests that our record is decoded intact
+// from a stream that surrounds it with unknown odd records, which decoders
+// are required to skip.
+func TestLocalNoncesUnknownOddNeighbors(t *testing.T) {
+	t.Parallel()
+
+	nonces := makeTestLocalNonces(3)
+
+	// The neighbors are sized like an entry, and the one before us even
+	// starts like our own record, so any confusion about where one record
+	// ends and the next starts would corrupt the result.
+	before := encodeTestLocalNonces(t, makeTestLocalNonces(1))
+	after := bytes.Repeat([]byte{0xff}, localNonceEntrySize)
+
+	var b bytes.Buffer
+	stream, err := tlv.NewStream(
+		tlv.MakePrimitiveRecord(localNoncesRecordType-1, &before),
+		nonces.Record(),
+		tlv.MakePrimitiveRecord(localNoncesRecordType+1, &after),
+	)
+	require.NoError(t, err)
+	require.NoError(t, stream.Encode(&b))
+
+	// A stream that only knows about our record skips the odd neighbors.
+	var decoded LocalNoncesData
+	stream, err = tlv.NewStream(decoded.Record())
+	require.NoError(t, err)
+
+	typeMap, err := stream.DecodeWithParsedTypesP2P(
+		bytes.NewReader(b.Bytes()),
+	)
+	require.NoError(t, err)
+	require.Equal(t, nonces.NoncesMap, decoded.NoncesMap)
+
+	// The skipped records are still reported, along with their values.
+	require.Equal(t, before, typeMap[localNoncesRecordType-1])
+	require.Equal(t, after, typeMap[localNoncesRecordType+1])
+
+	// The same holds when extracting from a message's extra data.
+	extracted, err := ExtractLocalNonces(ExtraOpaqueData(b.Bytes()))
+	require.NoError(t, err)
+	extracted.WhenSome(func(record LocalNoncesTLV) {
+		require.Equal(t, nonces.NoncesMap, record.Val.NoncesMap)
+	})
+	require.True(t, extracted.IsSome())
+}
+
+// TestMaxEntriesInBytes tests the number of entries that fit into a byte
+// budget, including around the point where the BigSize record length widens
+// from one to three bytes.
+func TestMaxEntriesInBytes(t *testing.T) {
+	t.Parallel()
+
+	// recordSize returns the size of the complete TLV record of a set
+	// with n entries.
+	recordSize := func(n int) uint64 {
+		var b bytes.Buffer
+		record := makeTestLocalNonces(n).Record()
+		stream, err := tlv.NewStream(record)
+		require.NoError(t, err)
+		require.NoError(t, stream.Encode(&b))
+
+		return uint64(b.Len())
+	}
+
+	// Two entries make for a 198 byte value with a single byte length,
+	// while three make for a 296 byte value with a three byte length.
+	require.EqualValues(t, 1+1+198, recordSize(2))
+	require.EqualValues(t, 1+3+296, recordSize(3))
+
+	testCases := []struct {
+		budget     uint64
+		numEntries int
+	}{
+		{budget: 0, numEntries: 0},
+		{budget: recordSize(0) - 1, numEntries: 0},
+		{budget: recordSize(0), numEntries: 0},
+		{budget: recordSize(1) - 1, numEntries: 0},
+		{budget: recordSize(1), numEntries: 1},
+		{budget: recordSize(2), numEntries: 2},
+		{budget: recordSize(3) - 1, numEntries: 2},
+		{budget: recordSize(3), numEntries: 3},
+		{budget: recordSize(10) + 97, numEntries: 10},
+		{budget: recordSize(11), numEntries: 11},
+		{budget: tlv.MaxRecordSize * 2, numEntries: MaxLocalNonces},
+	}
+	for _, tc := range testCases {
+		require.Equal(
+			t, tc.numEntries, MaxEntriesInBytes(tc.budget),
+			"budget %d", tc.budget,
+		)
+	}
+}
+
+// TestDecodeLocalNoncesRewind tests that a seekable reader is rewound to where
+// the record started when decoding fails.
+func TestDecodeLocalNoncesRewind(t *testing.T) {
+	t.Parallel()
+
+	nonces := makeTestLocalNonces(3)
+	encoded := encodeTestLocalNonces(t, nonces)
+
+	// Corrupt the last entry by duplicating the txid of the first, which
+	// is only detected once most of the record was read. A prefix ahead
+	// of the record makes sure we rewind to where we started rather than
+	// to the very beginning.
+	prefix := []byte{0xaa, 0xbb}
+	corrupted := append(bytes.Clone(prefix), encoded...)
+	lastEntry := len(corrupted) - localNonceEntrySize
+	copy(
+		corrupted[lastEntry:lastEntry+chainhash.HashSize],
+		encoded[localNoncesCountSize:][:chainhash.HashSize],
+	)
+
+	r := bytes.NewReader(corrupted)
+	_, err := r.Seek(int64(len(prefix)), io.SeekStart)
+	require.NoError(t, err)
+
+	_, err = DecodeLocalNoncesRewind(r, uint64(len(encoded)))
+	require.ErrorIs(t, err, ErrLocalNoncesDuplicateTxid)
+	require.Equal(t, len(encoded), r.Len())
+
+	// A truncated record is rewound as well.
+	truncated := bytes.NewReader(encoded[:len(encoded)-1])
+	_, err = DecodeLocalNoncesRewind(truncated, uint64(len(encoded)))
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	require.Equal(t, len(encoded)-1, truncated.Len())
+
+	// On success, the reader is left after the record.
+	r = bytes.NewReader(encoded)
+	decoded, err := DecodeLocalNoncesRewind(r, uint64(len(encoded)))
+	require.NoError(t, err)
+	require.Equal(t, nonces.NoncesMap, decoded.NoncesMap)
+	require.Zero(t, r.Len())
+
+	// A reader that can't seek is left where decoding stopped.
+	plain := &plainReader{bytes.NewReader(corrupted[len(prefix):])}
+	_, err = DecodeLocalNoncesRewind(plain, uint64(len(encoded)))
+	require.ErrorIs(t, err, ErrLocalNoncesDuplicateTxid)
+}
+
+// makeRandomLocalNonces returns a set of n entries with random txids and
+// nonces.
+func makeRandomLocalNonces(t *testing.T, r *rand.Rand, n int) *LocalNoncesData {
+	t.Helper()
+
+	nonces := &LocalNoncesData{
+		NoncesMap: make(map[chainhash.Hash]Musig2Nonce, n),
+	}
+	for len(nonces.NoncesMap) < n {
+		var (
+			txid  chainhash.Hash
+			nonce Musig2Nonce
+		)
+		_, err := r.Read(txid[:])
+		require.NoError(t, err)
+		_, err = r.Read(nonce[:])
+		require.NoError(t, err)
+
+		nonces.NoncesMap[txid] = nonce
+	}
+
+	return nonces
+}
+
+// TestLocalNoncesMaxEntries tests that a set of exactly MaxLocalNonces entries
+// can be round-tripped, while a single entry more is rejected on both encode
+// and decode.
+func TestLocalNoncesMaxEntries(t *testing.T) {
+	t.Parallel()
+
+	if testing.Short() {
+		t.Skip("skipping max size record in short mode")
+	}
+
+	r := rand.New(rand.NewSource(42))
+	nonces := makeRandomLocalNonces(t, r, MaxLocalNonces)
+
+	// The maximum set fits into a TLV record of a P2P stream.
+	var b bytes.Buffer
+	stream, err := tlv.NewStream(nonces.Record())
+	require.NoError(t, err)
+	require.NoError(t, stream.Encode(&b))
+
+	var decoded LocalNoncesData
+	stream, err = tlv.NewStream(decoded.Record())
+	require.NoError(t, err)
+	require.NoError(t, stream.DecodeP2P(bytes.NewReader(b.Bytes())))
+	require.Equal(t, nonces.NoncesMap, decoded.NoncesMap)
+
+	// A single entry more is rejected by the encoders.
+	tooMany := makeRandomLocalNonces(t, r, MaxLocalNonces+1)
+
+	var buf [8]byte
+	b.Reset()
+	err = encodeLocalNoncesData(&b, tooMany, &buf)
+	require.ErrorIs(t, err, ErrTooManyLocalNonces)
+	require.Zero(t, b.Len())
+
+	_, err = tooMany.AppendTo(nil)
+	require.ErrorIs(t, err, ErrTooManyLocalNonces)
+
+	// A record with one entry too many is rejected by the decoder, even
+	// though its length matches its count.
+	encoded := binary.BigEndian.AppendUint16(nil, MaxLocalNonces+1)
+	for _, entry := range tooMany.SortedEntries() {
+		encoded = append(encoded, entry.TXID[:]...)
+		encoded = append(encoded, entry.Nonce[:]...)
+	}
+	err = decodeLocalNoncesData(
+		bytes.NewReader(encoded), &decoded, &buf, uint64(len(encoded)),
+	)
+	require.ErrorIs(t, err, ErrTooManyLocalNonces)
+	require.Equal(t, nonces.NoncesMap, decoded.NoncesMap)
+}
+
+// TestDecodeLocalNoncesFlat tests that the legacy flat format, which lacks the
+// entry count, is decoded.
+func TestDecodeLocalNoncesFlat(t *testing.T) {
+	t.Parallel()
+
+	nonces := makeTestLocalNonces(3)
+	flat := encodeTestLocalNonces(t, nonces)[localNoncesCountSize:]
+
+	decoded, err := DecodeLocalNoncesFlat(flat)
+	require.NoError(t, err)
+	require.Equal(t, nonces.NoncesMap, decoded.NoncesMap)
+
+	// Empty input is an empty set.
+	decoded, err = DecodeLocalNoncesFlat(nil)
+	require.NoError(t, err)
+	require.NotNil(t, decoded.NoncesMap)
+	require.Empty(t, decoded.NoncesMap)
+
+	// A partial entry is rejected.
+	_, err = DecodeLocalNoncesFlat(flat[:len(flat)-1])
+	require.ErrorIs(t, err, ErrLocalNoncesLengthMismatch)
+
+	// As are duplicate txids.
+	dup := append(bytes.Clone(flat), flat[:localNonceEntrySize]...)
+	_, err = DecodeLocalNoncesFlat(dup)
+	require.ErrorIs(t, err, ErrLocalNoncesDuplicateTxid)
+}
+
+// TestCompareLocalNonceEntries tests that sorting a shuffled slice of entries
+// with CompareLocalNonceEntries results in the order the encoder writes them
+// in.
+func TestCompareLocalNonceEntries(t *testing.T) {
+	t.Parallel()
+
+	r := rand.New(rand.NewSource(7))
+	nonces := makeRandomLocalNonces(t, r, 100)
+
+	entries := make([]LocalNonceEntry, 0, nonces.Len())
+	for txid, nonce := range nonces.NoncesMap {
+		entries = append(entries, LocalNonceEntry{
+			TXID:  txid,
+			Nonce: nonce,
+		})
+	}
+	r.Shuffle(len(entries), func(i, j int) {
+		entries[i], entries[j] = entries[j], entries[i]
+	})
+	slices.SortFunc(entries, CompareLocalNonceEntries)
+
+	// Decoding the encoded set as entries yields them in wire order.
+	encoded := encodeTestLocalNonces(t, nonces)
+	wireOrder, err := DecodeLocalNoncesAsEntries(
+		plainReader{bytes.NewReader(encoded)}, uint64(len(encoded)),
+	)
+	require.NoError(t, err)
+	require.Equal(t, wireOrder, entries)
+
+	// The nonce breaks ties between entries of the same txid.
+	a := LocalNonceEntry{TXID: makeTestTxId(1), Nonce: makeTestNonce(1)}
+	b := LocalNonceEntry{TXID: makeTestTxId(1), Nonce: makeTestNonce(2)}
+	require.Less(t, CompareLocalNonceEntries(a, b), 0)
+	require.Greater(t, CompareLocalNonceEntries(b, a), 0)
+	require.Zero(t, CompareLocalNonceEntries(a, a))
+}
+
+// TestLocalNoncesStandaloneEncoding tests that a set can be round-tripped
+// through the length prefixed standalone encoding.
+func TestLocalNoncesStandaloneEncoding(t *testing.T) {
+	t.Parallel()
+
+	for _, n := range []int{0, 1, 3, MaxLocalNonces} {
+		r := rand.New(rand.NewSource(int64(n)))
+		nonces := makeRandomLocalNonces(t, r, n)
+
+		var b bytes.Buffer
+		require.NoError(t, nonces.Encode(&b))
+
+		// The record value is preceded by its BigSize length.
+		prefixLen := tlv.VarIntSize(uint64(nonces.EncodedSize()))
+		require.Equal(
+			t, encodeTestLocalNonces(t, nonces),
+			b.Bytes()[prefixLen:],
+		)
+
+		var decoded LocalNoncesData
+		require.NoError(t, decoded.Decode(&b))
+		require.Equal(t, nonces.NoncesMap, decoded.NoncesMap)
+		require.Zero(t, b.Len())
+		requireStrictlyAscending(t, decoded.SortedEntries())
+	}
+
+	// A set that's too large is rejected before anything is written.
+	tooMany := makeRandomLocalNonces(
+		t, rand.New(rand.NewSource(1)), MaxLocalNonces+1,
+	)
+	var b bytes.Buffer
+	require.ErrorIs(t, tooMany.Encode(&b), ErrTooManyLocalNonces)
+	require.Zero(t, b.Len())
+}
+
+// TestLocalNoncesStandaloneCorruptPrefix tests that a corrupted length prefix
+// of the standalone encoding is rejected cleanly, leaving the target as is.
+func TestLocalNoncesStandaloneCorruptPrefix(t *testing.T) {
+	t.Parallel()
+
+	body := encodeTestLocalNonces(t, makeTestLocalNonces(2))
+
+	withPrefix := func(prefix ...byte) []byte {
+		return append(prefix, body...)
+	}
+
+	tests := []struct {
+		name    string
+		encoded []byte
+		err     error
+	}{
+		{
+			name:    "too large",
+			encoded: withPrefix(0xfe, 0x00, 0x01, 0x00, 0x00),
+			err:     ErrLocalNoncesRecordTooLarge,
+		},
+		{
+			name:    "not canonical",
+			encoded: withPrefix(0xfd, 0x00, byte(len(body))),
+			err:     tlv.ErrVarIntNotCanonical,
+		},
+		{
+			name:    "too short",
+			encoded: withPrefix(byte(len(body) - 1)),
+			err:     ErrLocalNoncesLengthMismatch,
+		},
+		{
+			name:    "too long",
+			encoded: withPrefix(byte(len(body) + 1)),
+			err:     ErrLocalNoncesLengthMismatch,
+		},
+		{
+			name:    "truncated prefix",
+			encoded: []byte{0xfd, 0x01},
+			err:     io.ErrUnexpectedEOF,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			existing := makeTestLocalNonces(1)
+			err := existing.Decode(bytes.NewReader(tc.encoded))
+			require.ErrorIs(t, err, tc.err)
+			require.Equal(t, makeTestLocalNonces(1), existing)
+		})
+	}
+}
+
+// TestLocalNoncesRecordTruncated tests that a correctly framed record whose
+// entries don't fit into what's left of a bytes.Reader is rejected before any
+// of them are read, while a reader that can't report its length still fails
+// once it runs out of data.
+func TestLocalNoncesRecordTruncated(t *testing.T) {
+	t.Parallel()
+
+	encoded := encodeTestLocalNonces(t, makeTestLocalNonces(3))
+	truncated := encoded[:len(encoded)-1]
+	bodyLen := len(truncated) - localNoncesCountSize
+
+	var (
+		nonces LocalNoncesData
+		buf    [8]byte
+	)
+	r := bytes.NewReader(truncated)
+	err := decodeLocalNoncesData(r, &nonces, &buf, uint64(len(encoded)))
+	require.ErrorIs(t, err, ErrLocalNoncesRecordTruncated)
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	require.Equal(t, bodyLen, r.Len())
+
+	err = decodeLocalNoncesData(
+		plainReader{bytes.NewReader(truncated)}, &nonces, &buf,
+		uint64(len(encoded)),
+	)
+	require.NotErrorIs(t, err, ErrLocalNoncesRecordTruncated)
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+}
+
+// TestLocalNoncesDecodeErrorOffset tests that decoding failures report the
+// offset within the record at which they occurred.
+func TestLocalNoncesDecodeErrorOffset(t *testing.T) {
+	t.Parallel()
+
+	encoded := encodeTestLocalNonces(t, makeTestLocalNonces(3))
+
+	// The second entry is made a duplicate of the first one.
+	duplicate := bytes.Clone(encoded)
+	copy(
+		duplicate[localNoncesCountSize+localNonceEntrySize:],
+		duplicate[localNoncesCountSize:][:localNonceEntrySize],
+	)
+
+	tests := []struct {
+		name    string
+		encoded []byte
+		offset  uint64
+		reason  string
+		err     error
+	}{
+		{
+			name:    "truncated count",
+			encoded: encoded[:1],
+			offset:  0,
+			reason:  "count",
+			err:     io.ErrUnexpectedEOF,
+		},
+		{
+			name:    "truncated txid",
+			encoded: encoded[:localNoncesCountSize+10],
+			offset:  localNoncesCountSize + 10,
+			reason:  "entry 0 txid",
+			err:     io.ErrUnexpectedEOF,
+		},
+		{
+			name: "truncated nonce",
+			encoded: encoded[:localNoncesCountSize+
+				localNonceEntrySize+chainhash.HashSize+5],
+			offset: localNoncesCountSize + localNonceEntrySize +
+				chainhash.HashSize + 5,
+			reason: "entry 1 nonce",
+			err:    io.ErrUnexpectedEOF,
+		},
+		{
+			name:    "duplicate txid",
+			encoded: duplicate,
+			offset:  localNoncesCountSize + localNonceEntrySize,
+			reason:  "entry 1 txid",
+			err:     ErrLocalNoncesDuplicateTxid,
+		},
+	}
+
+	// Both the bulk decoder used for in-memory readers and the buffered
+	// one used for any other reader report the same offset.
+	readers := map[string]func([]byte) io.Reader{
+		"bytes": func(b []byte) io.Reader {
+			return bytes.NewReader(b)
+		},
+		"plain": func(b []byte) io.Reader {
+			return plainReader{bytes.NewReader(b)}
+		},
+	}
+
+	for _, tc := range tests {
+		for readerName, newReader := range readers {
+			name := fmt.Sprintf("%s/%s", tc.name, readerName)
+			t.Run(name, func(t *testing.T) {
+				t.Parallel()
+
+				var (
+					nonces LocalNoncesData
+					buf    [8]byte
+				)
+				err := decodeLocalNoncesData(
+					newReader(tc.encoded), &nonces, &buf,
+					uint64(len(encoded)),
+				)
+				require.ErrorIs(t, err, tc.err)
+
+				var decodeErr *LocalNoncesDecodeError
+				require.ErrorAs(t, err, &decodeErr)
+				require.Equal(t, tc.offset, decodeErr.Offset)
+				require.Equal(t, tc.reason, decodeErr.Reason)
+			})
+		}
+	}
+}
+
+// TestLocalNoncesCountBoundary pins down how records of up to three bytes are
+// decoded, depending on what the reader holds, including how many bytes are
+// consumed. This serves as a safety net for any future change to the format
+// of the count field.
+func TestLocalNoncesCountBoundary(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name      string
+		recordLen uint64
+		reader    []byte
+		err       error
+		consumed  int
+	}{
+		{
+			name:      "len 0, empty reader",
+			recordLen: 0,
+			reader:    nil,
+		},
+		{
+			name:      "len 0, reader with data",
+			recordLen: 0,
+			reader:    []byte{0x00, 0x01},
+		},
+		{
+			name:      "len 1, empty reader",
+			recordLen: 1,
+			reader:    nil,
+			err:       ErrLocalNoncesRecordTooShort,
+		},
+		{
+			name:      "len 1, reader with data",
+			recordLen: 1,
+			reader:    []byte{0x00, 0x00},
+			err:       ErrLocalNoncesRecordTooShort,
+		},
+		{
+			name:      "len 2, zero count",
+			recordLen: 2,
+			reader:    []byte{0x00, 0x00},
+			consumed:  2,
+		},
+		{
+			name:      "len 2, zero count with trailing data",
+			recordLen: 2,
+			reader:    []byte{0x00, 0x00, 0x00},
+			consumed:  2,
+		},
+		{
+			name:      "len 2, non-zero count",
+			recordLen: 2,
+			reader:    []byte{0x00, 0x01},
+			err:       ErrLocalNoncesLengthMismatch,
+			consumed:  2,
+		},
+		{
+			name:      "len 2, empty reader",
+			recordLen: 2,
+			reader:    nil,
+			err:       io.EOF,
+		},
+		{
+			name:      "len 2, single byte reader",
+			recordLen: 2,
+			reader:    []byte{0x00},
+			err:       io.ErrUnexpectedEOF,
+			consumed:  1,
+		},
+		{
+			name:      "len 3, zero count",
+			recordLen: 3,
+			reader:    []byte{0x00, 0x00, 0x00},
+			err:       ErrLocalNoncesZeroCountWithData,
+			consumed:  2,
+		},
+		{
+			name:      "len 3, non-zero count",
+			recordLen: 3,
+			reader:    []byte{0x00, 0x01, 0x00},
+			err:       ErrLocalNoncesLengthMismatch,
+			consumed:  2,
+		},
+		{
+			name:      "len 3, single byte reader",
+			recordLen: 3,
+			reader:    []byte{0x00},
+			err:       io.ErrUnexpectedEOF,
+			consumed:  1,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			var (
+				nonces LocalNoncesData
+				buf    [8]byte
+			)
+			r := bytes.NewReader(tc.reader)
+			err := decodeLocalNoncesData(
+				r, &nonces, &buf, tc.recordLen,
+			)
+			require.Equal(t, tc.consumed, len(tc.reader)-r.Len())
+
+			if tc.err != nil {
+				require.ErrorIs(t, err, tc.err)
+				require.Nil(t, nonces.NoncesMap)
+
+				return
+			}
+
+			require.NoError(t, err)
+			require.NotNil(t, nonces.NoncesMap)
+			require.Empty(t, nonces.NoncesMap)
+		})
+	}
+}
+
+// TestLocalNoncesView tests that a set can be handed out as a read-only
+// LocalNoncesView, which reflects its contents.
+func TestLocalNoncesView(t *testing.T) {
+	t.Parallel()
+
+	nonces := makeTestLocalNonces(3)
+
+	var view LocalNoncesView = nonces
+	require.Equal(t, 3, view.Len())
+	require.True(t, view.Contains(makeTestTxId(2)))
+	require.False(t, view.Contains(makeTestTxId(4)))
+
+	nonce, ok := view.Get(makeTestTxId(2))
+	require.True(t, ok)
+	require.Equal(t, makeTestNonce(5), nonce)
+
+	require.Equal(t, nonces.SortedEntries(), view.SortedEntries())
+	require.Equal(t, nonces.EncodedSize(), view.EncodedSize())
+
+	// The view observes later changes made through the set itself.
+	nonces.NoncesMap[makeTestTxId(4)] = makeTestNonce(4)
+	require.True(t, view.Contains(makeTestTxId(4)))
+
+	// A nil set is an empty view.
+	view = (*LocalNoncesData)(nil)
+	require.Zero(t, view.Len())
+	require.Empty(t, view.SortedEntries())
+}
+
+// TestDecodeLocalNoncesWithStats tests that the stats of a canonical record
+// differ from those of a record with its entries out of order.
+func TestDecodeLocalNoncesWithStats(t *testing.T) {
+	t.Parallel()
+
+	nonces := makeTestLocalNonces(3)
+	canonical := encodeTestLocalNonces(t, nonces)
+
+	// Swap the first two entries.
+	const secondEntry = localNoncesCountSize + localNonceEntrySize
+	unsorted := bytes.Clone(canonical)
+	copy(
+		unsorted[localNoncesCountSize:secondEntry],
+		canonical[secondEntry:],
+	)
+	copy(
+		unsorted[secondEntry:],
+		canonical[localNoncesCountSize:secondEntry],
+	)
+
+	decoded, stats, err := DecodeLocalNoncesWithStats(
+		bytes.NewReader(canonical), uint64(len(canonical)),
+	)
+	require.NoError(t, err)
+	require.Equal(t, nonces.NoncesMap, decoded.NoncesMap)
+	require.Equal(t, DecodeStats{
+		NumEntries:     3,
+		Canonical:      true,
+		TotalBytes:     uint64(len(canonical)),
+		CountFieldSize: localNoncesCountSize,
+	}, stats)
+
+	decoded, stats, err = DecodeLocalNoncesWithStats(
+		bytes.NewReader(unsorted), uint64(len(unsorted)),
+	)
+	require.NoError(t, err)
+	require.Equal(t, nonces.NoncesMap, decoded.NoncesMap)
+	require.Equal(t, DecodeStats{
+		NumEntries:     3,
+		Canonical:      false,
+		TotalBytes:     uint64(len(unsorted)),
+		CountFieldSize: localNoncesCountSize,
+	}, stats)
+
+	// A zero length record has no count field at all.
+	_, stats, err = DecodeLocalNoncesWithStats(bytes.NewReader(nil), 0)
+	require.NoError(t, err)
+	require.Equal(t, DecodeStats{Canonical: true}, stats)
+
+	// Malformed records are rejected.
+	_, _, err = DecodeLocalNoncesWithStats(
+		bytes.NewReader(canonical), uint64(len(canonical))-1,
+	)
+	require.ErrorIs(t, err, ErrLocalNoncesLengthMismatch)
+}
+
+// TestLocalNoncesFromSortedEntries tests that a set is built from sorted
+// entries, while unsorted or repeated ones are rejected.
+func TestLocalNoncesFromSortedEntries(t *testing.T) {
+	t.Parallel()
+
+	nonces := makeTestLocalNonces(4)
+	entries := nonces.SortedEntries()
+
+	rebuilt, err := LocalNoncesFromSortedEntries(entries)
+	require.NoError(t, err)
+	require.Equal(t, nonces.NoncesMap, rebuilt.NoncesMap)
+
+	rebuilt, err = LocalNoncesFromSortedEntries(nil)
+	require.NoError(t, err)
+	require.Zero(t, rebuilt.Len())
+
+	unsorted := slices.Clone(entries)
+	unsorted[1], unsorted[2] = unsorted[2], unsorted[1]
+	_, err = LocalNoncesFromSortedEntries(unsorted)
+	require.ErrorIs(t, err, ErrLocalNoncesOutOfOrder)
+
+	// A repeated txid is rejected even if its nonce is the same.
+	duplicate := slices.Insert(slices.Clone(entries), 2, entries[1])
+	_, err = LocalNoncesFromSortedEntries(duplicate)
+	require.ErrorIs(t, err, ErrLocalNoncesDuplicateTxid)
+}
@@ -0,0 +1,92 @@
+package lnwire
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrInvalidLocalNoncesText is returned when a canonical text listing can't be
+// parsed, or isn't in its canonical form.
+var ErrInvalidLocalNoncesText = errors.New("invalid local nonces text")
+
+// CanonicalText returns a deterministic listing of the set that is meant to
+// be hashed or signed, and can be parsed back with ParseCanonicalText. Every
+// entry is written on a line of its own as the string form of the txid and
+// the lower case hex encoded nonce separated by a single space, with each
+// line terminated by a newline. The lines follow the order the entries are
+// written on the wire in. An empty set results in an empty string.
+func (lnd *LocalNoncesData) CanonicalText() string {
+	var sb strings.Builder
+	for _, entry := range lnd.SortedEntries() {
+		sb.WriteString(entry.TXID.String())
+		sb.WriteByte(' ')
+		sb.WriteString(hex.EncodeToString(entry.Nonce[:]))
+		sb.WriteByte('\n')
+	}
+
+	return sb.String()
+}
+
+// ParseCanonicalText parses a listing produced by CanonicalText. As the
+// listing is meant to be signed, only its canonical form is accepted: lower
+// case hex, a single space between txid and nonce, a newline after every
+// entry, and the entries in order without repeated txids. Any other input
+// results in ErrInvalidLocalNoncesText.
+func ParseCanonicalText(s string) (*LocalNoncesData, error) {
+	if s != "" && !strings.HasSuffix(s, "\n") {
+		return nil, fmt.Errorf("%w: missing final newline",
+			ErrInvalidLocalNoncesText)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+	if s == "" {
+		lines = nil
+	}
+	if len(lines) > MaxLocalNonces {
+		return nil, fmt.Errorf("%w: %d entries, max is %d",
+			ErrTooManyLocalNonces, len(lines), MaxLocalNonces)
+	}
+
+	entries := make([]LocalNonceEntry, 0, len(lines))
+	for i, line := range lines {
+		entry, err := parseCanonicalTextLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("%w: line %d: %w",
+				ErrInvalidLocalNoncesText, i+1, err)
+		}
+		entries = append(entries, entry)
+	}
+
+	lnd, err := LocalNoncesFromSortedEntries(entries)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidLocalNoncesText, err)
+	}
+
+	return lnd, nil
+}
+
+// parseCanonicalTextLine parses a single line of a canonical text listing,
+// without its trailing newline.
+func parseCanonicalTextLine(line string) (LocalNonceEntry, error) {
+	fields := strings.Split(line, " ")
+	if len(fields) != 2 {
+		return LocalNonceEntry{}, fmt.Errorf("expected 2 space "+
+			"separated fields, got %d", len(fields))
+	}
+	if line != strings.ToLower(line) {
+		return LocalNonceEntry{}, errors.New("upper case hex")
+	}
+
+	txid, err := parseLocalNonceTxidHex(fields[0])
+	if err != nil {
+		return LocalNonceEntry{}, err
+	}
+	nonce, err := parseLocalNonceHex(fields[1])
+	if err != nil {
+		return LocalNonceEntry{}, err
+	}
+
+	return LocalNonceEntry{TXID: txid, Nonce: nonce}, nil
+}
@@ -0,0 +1,119 @@
+package lnwire
+
+import (
+	"encoding/hex"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// TestLocalNoncesCanonicalTextRoundTrip tests that the canonical text listing
+// of a set is deterministic and parses back into the same set.
+func TestLocalNoncesCanonicalTextRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	r := rand.New(rand.NewSource(8))
+	for _, n := range []int{0, 1, 2, 50} {
+		nonces := makeRandomLocalNonces(t, r, n)
+
+		text := nonces.CanonicalText()
+		require.Equal(t, text, nonces.CanonicalText())
+		require.Equal(t, n, strings.Count(text, "\n"))
+
+		parsed, err := ParseCanonicalText(text)
+		require.NoError(t, err)
+		require.Equal(t, len(nonces.NoncesMap), len(parsed.NoncesMap))
+		for txid, nonce := range nonces.NoncesMap {
+			require.Equal(t, nonce, parsed.NoncesMap[txid])
+		}
+	}
+
+	// The lines follow the wire order of the entries.
+	nonces := makeTestLocalNonces(3)
+	lines := strings.Split(
+		strings.TrimSuffix(nonces.CanonicalText(), "\n"), "\n",
+	)
+	for i, entry := range nonces.SortedEntries() {
+		require.Equal(t, entry.TXID.String()+" "+
+			hex.EncodeToString(entry.Nonce[:]), lines[i])
+	}
+}
+
+// TestParseCanonicalTextMalformed tests that anything but the canonical form
+// of a listing is rejected.
+func TestParseCanonicalTextMalformed(t *testing.T) {
+	t.Parallel()
+
+	entries := makeTestLocalNonces(2).SortedEntries()
+	line := func(i int) string {
+		return entries[i].TXID.String() + " " +
+			hex.EncodeToString(entries[i].Nonce[:])
+	}
+
+	nonceAB := makeTestNonce(0xab)
+
+	tests := []struct {
+		name string
+		text string
+	}{
+		{
+			name: "missing final newline",
+			text: line(0),
+		},
+		{
+			name: "empty line",
+			text: "\n",
+		},
+		{
+			name: "double space",
+			text: strings.Replace(line(0), " ", "  ", 1) + "\n",
+		},
+		{
+			name: "tab separator",
+			text: strings.Replace(line(0), " ", "\t", 1) + "\n",
+		},
+		{
+			name: "missing nonce",
+			text: entries[0].TXID.String() + "\n",
+		},
+		{
+			name: "extra field",
+			text: line(0) + " 00\n",
+		},
+		{
+			name: "upper case",
+			text: strings.ToUpper(makeTestTxId(0xab).String()+
+				" "+hex.EncodeToString(nonceAB[:])) + "\n",
+		},
+		{
+			name: "carriage return",
+			text: line(0) + "\r\n",
+		},
+		{
+			name: "short nonce",
+			text: line(0)[:len(line(0))-2] + "\n",
+		},
+		{
+			name: "invalid hex",
+			text: line(0)[:len(line(0))-2] + "zz\n",
+		},
+		{
+			name: "out of order",
+			text: line(1) + "\n" + line(0) + "\n",
+		},
+		{
+			name: "duplicate txid",
+			text: line(0) + "\n" + line(0) + "\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := ParseCanonicalText(test.text)
+			require.ErrorIs(t, err, ErrInvalidLocalNoncesText)
+		})
+	}
+}
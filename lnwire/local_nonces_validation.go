@@ -0,0 +1,250 @@
+package lnwire
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+
+	"github.com/btcsuite/btcd/btcec/v2/schnorr/musig2"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+)
+
+var (
+	// ErrDegenerateLocalNonce is returned when both of the points that
+	// make up a musig2 public nonce are identical, which points at a bug
+	// in the code that generated it.
+	ErrDegenerateLocalNonce = errors.New("degenerate local nonce")
+
+	// ErrZeroLocalNonce is returned when a musig2 public nonce is all
+	// zeroes, which usually means an entry was added without ever being
+	// assigned a nonce.
+	ErrZeroLocalNonce = errors.New("zero local nonce")
+)
+
+// validateLocalNonce checks a single entry of a LocalNoncesData, returning an
+// error naming the txid of the entry if the nonce is invalid.
+func validateLocalNonce(txid chainhash.Hash, nonce Musig2Nonce) error {
+	// An all zero nonce has two identical points as well, but we report
+	// it separately as it's almost certainly an unset entry.
+	if nonce == (Musig2Nonce{}) {
+		return fmt.Errorf("%w: nonce for txid %v is all zeroes",
+			ErrZeroLocalNonce, txid)
+	}
+
+	const pointSize = musig2.PubNonceSize / 2
+	if bytes.Equal(nonce[:pointSize], nonce[pointSize:]) {
+		return fmt.Errorf("%w: nonce for txid %v has two identical "+
+			"points", ErrDegenerateLocalNonce, txid)
+	}
+
+	return nil
+}
+
+// LocalNonceCheck is a check run against a single entry of a LocalNoncesData.
+// It returns a non-nil error if the entry is deemed invalid.
+type LocalNonceCheck func(txid chainhash.Hash, nonce Musig2Nonce) error
+
+// Validate checks every nonce in the set, returning an error for the first
+// invalid entry in ascending txid order.
+func (lnd *LocalNoncesData) Validate() error {
+	return lnd.ValidateWith(validateLocalNonce)
+}
+
+// ValidateAll checks every nonce in the set like Validate does, but rather
+// than stopping at the first invalid entry, it returns the errors of all of
+// them in ascending txid order. This lets an operator see every bad nonce in a
+// single pass. A nil result means the whole set is valid.
+func (lnd *LocalNoncesData) ValidateAll() []error {
+	var errs []error
+	for _, txid := range lnd.sortedTxids() {
+		err := validateLocalNonce(txid, lnd.NoncesMap[txid])
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errs
+}
+
+// ValidateWith runs each of the given checks against every entry of the set,
+// visiting the entries in ascending txid order and running the checks in the
+// order they're passed in. The first error returned by a check is returned
+// as is. This lets callers compose their own policies, such as rejecting
+// known bad nonces, on top of or instead of Validate.
+func (lnd *LocalNoncesData) ValidateWith(checks ...LocalNonceCheck) error {
+	for _, txid := range lnd.sortedTxids() {
+		nonce := lnd.NoncesMap[txid]
+		for _, check := range checks {
+			if err := check(txid, nonce); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// AllNoncesDistinct returns false if any two txids in the set share the exact
+// same nonce. Reusing a nonce across two signing sessions can leak the secret
+// key, so this acts as a precondition before signing a batch.
+func (lnd *LocalNoncesData) AllNoncesDistinct() bool {
+	seen := make(map[Musig2Nonce]struct{}, len(lnd.NoncesMap))
+	for _, nonce := range lnd.NoncesMap {
+		if _, ok := seen[nonce]; ok {
+			return false
+		}
+		seen[nonce] = struct{}{}
+	}
+
+	return true
+}
+
+// ContainsAny returns the txids, in ascending order, of all entries whose
+// nonce is part of the blacklist, such as the nonces of sessions that were
+// already retired. This guards against accidentally reusing a nonce in a
+// freshly built set.
+func (lnd *LocalNoncesData) ContainsAny(
+	blacklist map[Musig2Nonce]struct{}) []chainhash.Hash {
+
+	var hits []chainhash.Hash
+	for _, txid := range lnd.sortedTxids() {
+		if _, ok := blacklist[lnd.NoncesMap[txid]]; ok {
+			hits = append(hits, txid)
+		}
+	}
+
+	return hits
+}
+
+// WithinLimit returns ErrTooManyLocalNonces if the set holds more than
+// maxEntries entries. This is how a receiver enforces a limit the sender
+// agreed to that's lower than MaxLocalNonces, such as one negotiated when
+// connecting, on top of the checks the decoder already performs.
+func (lnd *LocalNoncesData) WithinLimit(maxEntries int) error {
+	if lnd.Len() > maxEntries {
+		return fmt.Errorf("%w: %d entries, limit is %d",
+			ErrTooManyLocalNonces, lnd.Len(), maxEntries)
+	}
+
+	return nil
+}
+
+// EncodeStrict validates the set before writing its record encoding to w, so
+// that an invalid nonce never makes it onto the wire. The TLV record itself
+// remains lenient and encodes any nonce as is.
+func (lnd *LocalNoncesData) EncodeStrict(w io.Writer) error {
+	if err := lnd.Validate(); err != nil {
+		return err
+	}
+
+	var buf [8]byte
+
+	return encodeLocalNoncesData(w, lnd, &buf)
+}
+
+// IsCanonicalLocalNonces reports whether the encoded record was written by a
+// conforming encoder, that is whether its txids are in strictly ascending
+// order. A record with entries out of order or with duplicate txids results
+// in false, even though the former can still be decoded. Only the txids are
+// inspected, no map is built. An error is returned if the record is
+// malformed altogether.
+func IsCanonicalLocalNonces(encoded []byte) (bool, error) {
+	numEntries, err := readLocalNoncesHeader(
+		bytes.NewReader(encoded), uint64(len(encoded)),
+	)
+	if err != nil {
+		return false, err
+	}
+
+	// A zero length record is an empty set, which is trivially canonical,
+	// as is a single entry.
+	if numEntries < 2 {
+		return true, nil
+	}
+
+	body := encoded[localNoncesCountSize:]
+	for i := 1; i < int(numEntries); i++ {
+		prev := body[(i-1)*localNonceEntrySize:][:chainhash.HashSize]
+		txid := body[i*localNonceEntrySize:][:chainhash.HashSize]
+		if bytes.Compare(prev, txid) >= 0 {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// NeedsCanonicalization decodes the encoded record and re-encodes it the way a
+// conforming encoder would, reporting whether that changes the bytes along
+// with the canonical encoding. This lets a record received from a peer be
+// normalized before it's stored. A record with its entries out of order needs
+// canonicalization, as does a zero length record, which is re-encoded with an
+// explicit zero count. An error is returned if the record can't be decoded.
+func NeedsCanonicalization(encoded []byte) (bool, []byte, error) {
+	var (
+		nonces LocalNoncesData
+		buf    [8]byte
+	)
+	err := decodeLocalNoncesData(
+		bytes.NewReader(encoded), &nonces, &buf, uint64(len(encoded)),
+	)
+	if err != nil {
+		return false, nil, err
+	}
+	defer nonces.ReleaseBudget()
+
+	var canonical bytes.Buffer
+	canonical.Grow(nonces.EncodedSize())
+	if err := encodeLocalNoncesData(&canonical, &nonces, &buf); err != nil {
+		return false, nil, err
+	}
+
+	return !bytes.Equal(encoded, canonical.Bytes()), canonical.Bytes(), nil
+}
+
+// ValidateLocalNoncesFraming checks that a record of recordLen bytes starting
+// with the given header is framed consistently, that is that its length
+// matches the number of entries its count claims, without decoding any of
+// the entries. Only the count field at the start of the header is read, so a
+// transport can reject a malformed record before receiving all of it. The
+// same framing errors are returned as by the full decoder.
+func ValidateLocalNoncesFraming(header []byte, recordLen uint64) error {
+	// Limiting the reader hides its length from the header check, which
+	// would otherwise compare the record length against the header only.
+	r := io.LimitReader(bytes.NewReader(header), localNoncesCountSize)
+	_, err := readLocalNoncesHeader(r, recordLen)
+
+	return err
+}
+
+// EncodedNoncesSemanticEqual reports whether the two encoded records hold the
+// same entries. Unlike comparing the bytes, this tolerates records written by
+// non-conforming encoders with their entries out of order. An error is
+// returned if either record is malformed.
+func EncodedNoncesSemanticEqual(a, b []byte) (bool, error) {
+	var (
+		aNonces, bNonces LocalNoncesData
+		buf              [8]byte
+	)
+	err := decodeLocalNoncesData(
+		bytes.NewReader(a), &aNonces, &buf, uint64(len(a)),
+	)
+	if err != nil {
+		return false, fmt.Errorf("unable to decode first record: %w",
+			err)
+	}
+	defer aNonces.ReleaseBudget()
+
+	err = decodeLocalNoncesData(
+		bytes.NewReader(b), &bNonces, &buf, uint64(len(b)),
+	)
+	if err != nil {
+		return false, fmt.Errorf("unable to decode second record: %w",
+			err)
+	}
+	defer bNonces.ReleaseBudget()
+
+	return maps.Equal(aNonces.NoncesMap, bNonces.NoncesMap), nil
+}
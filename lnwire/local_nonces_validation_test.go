@@ -0,0 +1,481 @@
+package lnwire
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2/schnorr/musig2"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/stretchr/testify/require"
+)
+
+// makeDegenerateNonce returns a nonce whose two points are identical.
+func makeDegenerateNonce() Musig2Nonce {
+	var nonce Musig2Nonce
+	for i := range nonce {
+		nonce[i] = byte(i % (musig2.PubNonceSize / 2))
+	}
+
+	return nonce
+}
+
+// TestLocalNoncesEncodeStrictDegenerate tests that the strict encoder rejects
+// a nonce with two identical points, while the lenient encoder accepts it.
+func TestLocalNoncesEncodeStrictDegenerate(t *testing.T) {
+	t.Parallel()
+
+	nonces := makeTestLocalNonces(3)
+
+	// Uniform test nonces are degenerate too, so give every entry
+	// distinct points first.
+	for txid, nonce := range nonces.NoncesMap {
+		nonce[musig2.PubNonceSize-1]++
+		nonces.NoncesMap[txid] = nonce
+	}
+
+	var b bytes.Buffer
+	require.NoError(t, nonces.EncodeStrict(&b))
+	require.Equal(t, encodeTestLocalNonces(t, nonces), b.Bytes())
+
+	degenerateTxid := makeTestTxId(2)
+	nonces.NoncesMap[degenerateTxid] = makeDegenerateNonce()
+
+	b.Reset()
+	err := nonces.EncodeStrict(&b)
+	require.ErrorIs(t, err, ErrDegenerateLocalNonce)
+	require.ErrorContains(t, err, degenerateTxid.String())
+	require.Zero(t, b.Len())
+
+	// The lenient TLV encoder still writes the degenerate nonce.
+	encoded := encodeTestLocalNonces(t, nonces)
+	require.Len(t, encoded, localNoncesCountSize+3*localNonceEntrySize)
+}
+
+// TestLocalNoncesEncodeStrictZero tests that the strict encoder refuses to
+// write an all zero nonce, naming its txid.
+func TestLocalNoncesEncodeStrictZero(t *testing.T) {
+	t.Parallel()
+
+	zeroTxid := makeTestTxId(1)
+	nonces := &LocalNoncesData{
+		NoncesMap: map[chainhash.Hash]Musig2Nonce{
+			zeroTxid:        {},
+			makeTestTxId(2): makeDegenerateNonce(),
+		},
+	}
+
+	var b bytes.Buffer
+	err := nonces.EncodeStrict(&b)
+	require.ErrorIs(t, err, ErrZeroLocalNonce)
+	require.NotErrorIs(t, err, ErrDegenerateLocalNonce)
+	require.ErrorContains(t, err, zeroTxid.String())
+	require.Zero(t, b.Len())
+
+	// The lenient TLV encoder still writes the zero nonce.
+	encoded := encodeTestLocalNonces(t, nonces)
+	require.Len(t, encoded, localNoncesCountSize+2*localNonceEntrySize)
+}
+
+// TestLocalNoncesValidateAll tests that every invalid entry is reported, not
+// just the first one.
+func TestLocalNoncesValidateAll(t *testing.T) {
+	t.Parallel()
+
+	// Give the test nonces distinct points, so they're valid.
+	nonces := makeTestLocalNonces(5)
+	for txid, nonce := range nonces.NoncesMap {
+		nonce[musig2.PubNonceSize-1]++
+		nonces.NoncesMap[txid] = nonce
+	}
+	require.Nil(t, nonces.ValidateAll())
+
+	nonces.NoncesMap[makeTestTxId(4)] = Musig2Nonce{}
+	nonces.NoncesMap[makeTestTxId(2)] = makeDegenerateNonce()
+	nonces.NoncesMap[makeTestTxId(5)] = makeDegenerateNonce()
+
+	errs := nonces.ValidateAll()
+	require.Len(t, errs, 3)
+
+	// The errors are ordered by txid and each names its entry.
+	require.ErrorIs(t, errs[0], ErrDegenerateLocalNonce)
+	require.ErrorContains(t, errs[0], makeTestTxId(2).String())
+	require.ErrorIs(t, errs[1], ErrZeroLocalNonce)
+	require.ErrorContains(t, errs[1], makeTestTxId(4).String())
+	require.ErrorIs(t, errs[2], ErrDegenerateLocalNonce)
+	require.ErrorContains(t, errs[2], makeTestTxId(5).String())
+
+	// Validate still stops at the first one.
+	require.Equal(t, errs[0], nonces.Validate())
+}
+
+// TestLocalNoncesAllNoncesDistinct tests that a reused nonce is detected
+// across the whole set.
+func TestLocalNoncesAllNoncesDistinct(t *testing.T) {
+	t.Parallel()
+
+	nonces := makeTestLocalNonces(5)
+	require.True(t, nonces.AllNoncesDistinct())
+
+	nonces.NoncesMap[makeTestTxId(9)] = nonces.NoncesMap[makeTestTxId(3)]
+	require.False(t, nonces.AllNoncesDistinct())
+
+	require.True(t, (&LocalNoncesData{}).AllNoncesDistinct())
+}
+
+// TestLocalNoncesContainsAny tests that the txids of all entries with a
+// blacklisted nonce are reported.
+func TestLocalNoncesContainsAny(t *testing.T) {
+	t.Parallel()
+
+	// The set maps the txids 1..5 to the nonces 6..10.
+	nonces := makeTestLocalNonces(5)
+	blacklist := map[Musig2Nonce]struct{}{
+		makeTestNonce(9):    {},
+		makeTestNonce(7):    {},
+		makeTestNonce(0xff): {},
+	}
+	require.Equal(t, []chainhash.Hash{
+		makeTestTxId(2), makeTestTxId(4),
+	}, nonces.ContainsAny(blacklist))
+
+	require.Empty(t, nonces.ContainsAny(nil))
+}
+
+// TestLocalNoncesValidateWith tests that custom checks are run against every
+// entry, and that the first failure is propagated.
+func TestLocalNoncesValidateWith(t *testing.T) {
+	t.Parallel()
+
+	nonces := makeTestLocalNonces(4)
+	errBlacklisted := errors.New("blacklisted nonce")
+	blacklisted := nonces.NoncesMap[makeTestTxId(3)]
+
+	var visited []chainhash.Hash
+	recordVisit := func(txid chainhash.Hash, _ Musig2Nonce) error {
+		visited = append(visited, txid)
+		return nil
+	}
+	rejectBlacklisted := func(txid chainhash.Hash,
+		nonce Musig2Nonce) error {
+
+		if nonce == blacklisted {
+			return fmt.Errorf("%w: %v", errBlacklisted, txid)
+		}
+
+		return nil
+	}
+
+	err := nonces.ValidateWith(recordVisit, rejectBlacklisted)
+	require.ErrorIs(t, err, errBlacklisted)
+	require.ErrorContains(t, err, makeTestTxId(3).String())
+
+	// The first check ran for every entry up to and including the one
+	// that failed the second check.
+	require.Equal(t, []chainhash.Hash{
+		makeTestTxId(1), makeTestTxId(2), makeTestTxId(3),
+	}, visited)
+
+	// Without the failing check, every entry is visited.
+	visited = nil
+	require.NoError(t, nonces.ValidateWith(recordVisit))
+	require.Equal(t, nonces.sortedTxids(), visited)
+
+	// No checks means nothing can fail.
+	require.NoError(t, nonces.ValidateWith())
+}
+
+// TestIsCanonicalLocalNonces tests that only records with strictly ascending
+// txids are considered canonical.
+func TestIsCanonicalLocalNonces(t *testing.T) {
+	t.Parallel()
+
+	canonical := encodeTestLocalNonces(t, makeTestLocalNonces(3))
+
+	// entryTxid returns the txid of the i-th entry of a record.
+	entryTxid := func(b []byte, i int) []byte {
+		offset := localNoncesCountSize + i*localNonceEntrySize
+		return b[offset : offset+chainhash.HashSize]
+	}
+
+	outOfOrder := bytes.Clone(canonical)
+	copy(entryTxid(outOfOrder, 0), entryTxid(canonical, 1))
+	copy(entryTxid(outOfOrder, 1), entryTxid(canonical, 0))
+
+	duplicate := bytes.Clone(canonical)
+	copy(entryTxid(duplicate, 2), entryTxid(canonical, 1))
+
+	testCases := []struct {
+		name      string
+		encoded   []byte
+		canonical bool
+	}{
+		{
+			name:      "canonical",
+			encoded:   canonical,
+			canonical: true,
+		},
+		{
+			name:      "empty",
+			encoded:   []byte{0x00, 0x00},
+			canonical: true,
+		},
+		{
+			name:      "zero length",
+			encoded:   nil,
+			canonical: true,
+		},
+		{
+			name:      "out of order",
+			encoded:   outOfOrder,
+			canonical: false,
+		},
+		{
+			name:      "duplicate txid",
+			encoded:   duplicate,
+			canonical: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			ok, err := IsCanonicalLocalNonces(tc.encoded)
+			require.NoError(t, err)
+			require.Equal(t, tc.canonical, ok)
+		})
+	}
+
+	// A malformed record isn't merely non-canonical.
+	_, err := IsCanonicalLocalNonces(canonical[:len(canonical)-1])
+	require.ErrorIs(t, err, ErrLocalNoncesLengthMismatch)
+}
+
+// TestEncodedNoncesSemanticEqual tests that records holding the same entries
+// are equal no matter the order of their entries, while records holding
+// different entries aren't.
+func TestEncodedNoncesSemanticEqual(t *testing.T) {
+	t.Parallel()
+
+	nonces := makeTestLocalNonces(4)
+	sorted := encodeTestLocalNonces(t, nonces)
+
+	// Write the same entries in descending txid order, as a
+	// non-conforming peer might.
+	unsorted := binary.BigEndian.AppendUint16(nil, uint16(nonces.Len()))
+	entries := nonces.SortedEntries()
+	for i := len(entries) - 1; i >= 0; i-- {
+		unsorted = append(unsorted, entries[i].TXID[:]...)
+		unsorted = append(unsorted, entries[i].Nonce[:]...)
+	}
+	require.NotEqual(t, sorted, unsorted)
+
+	equal, err := EncodedNoncesSemanticEqual(sorted, unsorted)
+	require.NoError(t, err)
+	require.True(t, equal)
+
+	equal, err = EncodedNoncesSemanticEqual(unsorted, sorted)
+	require.NoError(t, err)
+	require.True(t, equal)
+
+	// A different nonce for one of the txids isn't equal.
+	changed := makeTestLocalNonces(4)
+	changed.NoncesMap[makeTestTxId(2)] = makeTestNonce(0xff)
+	equal, err = EncodedNoncesSemanticEqual(
+		unsorted, encodeTestLocalNonces(t, changed),
+	)
+	require.NoError(t, err)
+	require.False(t, equal)
+
+	// Neither is a subset.
+	equal, err = EncodedNoncesSemanticEqual(
+		unsorted, encodeTestLocalNonces(t, makeTestLocalNonces(3)),
+	)
+	require.NoError(t, err)
+	require.False(t, equal)
+
+	// A malformed record on either side results in an error.
+	_, err = EncodedNoncesSemanticEqual(sorted, unsorted[:len(unsorted)-1])
+	require.ErrorIs(t, err, ErrLocalNoncesLengthMismatch)
+	_, err = EncodedNoncesSemanticEqual(sorted[:1], unsorted)
+	require.ErrorIs(t, err, ErrLocalNoncesRecordTooShort)
+}
+
+// TestValidateLocalNoncesFraming tests that the framing of a record is checked
+// using nothing but its header.
+func TestValidateLocalNoncesFraming(t *testing.T) {
+	t.Parallel()
+
+	encoded := encodeTestLocalNonces(t, makeTestLocalNonces(3))
+	recordLen := uint64(len(encoded))
+
+	tests := []struct {
+		name      string
+		header    []byte
+		recordLen uint64
+		err       error
+	}{
+		{
+			name:      "consistent count only",
+			header:    encoded[:localNoncesCountSize],
+			recordLen: recordLen,
+		},
+		{
+			name:      "consistent with part of the body",
+			header:    encoded[:localNoncesCountSize+10],
+			recordLen: recordLen,
+		},
+		{
+			name:      "zero length",
+			header:    nil,
+			recordLen: 0,
+		},
+		{
+			name:      "empty set",
+			header:    []byte{0x00, 0x00},
+			recordLen: localNoncesCountSize,
+		},
+		{
+			name:      "length one entry short",
+			header:    encoded[:localNoncesCountSize],
+			recordLen: recordLen - localNonceEntrySize,
+			err:       ErrLocalNoncesLengthMismatch,
+		},
+		{
+			name:      "length not a multiple of entries",
+			header:    encoded[:localNoncesCountSize],
+			recordLen: recordLen + 1,
+			err:       ErrLocalNoncesLengthMismatch,
+		},
+		{
+			name:      "zero count with data",
+			header:    []byte{0x00, 0x00},
+			recordLen: recordLen,
+			err:       ErrLocalNoncesZeroCountWithData,
+		},
+		{
+			name:      "too many entries",
+			header:    []byte{0xff, 0xff},
+			recordLen: recordLen,
+			err:       ErrTooManyLocalNonces,
+		},
+		{
+			name:      "stray byte",
+			header:    encoded[:1],
+			recordLen: 1,
+			err:       ErrLocalNoncesRecordTooShort,
+		},
+		{
+			name:      "truncated header",
+			header:    encoded[:1],
+			recordLen: recordLen,
+			err:       io.ErrUnexpectedEOF,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := ValidateLocalNoncesFraming(
+				tc.header, tc.recordLen,
+			)
+			if tc.err == nil {
+				require.NoError(t, err)
+				return
+			}
+
+			require.ErrorIs(t, err, tc.err)
+		})
+	}
+}
+
+// TestNeedsCanonicalization tests that only records that differ from their
+// canonical encoding need canonicalization, and that the canonical encoding is
+// returned either way.
+func TestNeedsCanonicalization(t *testing.T) {
+	t.Parallel()
+
+	nonces := makeTestLocalNonces(4)
+	canonical := encodeTestLocalNonces(t, nonces)
+
+	// A canonical record is left as is.
+	needed, normalized, err := NeedsCanonicalization(canonical)
+	require.NoError(t, err)
+	require.False(t, needed)
+	require.Equal(t, canonical, normalized)
+
+	// Write the same entries in descending txid order, as a
+	// non-conforming peer might.
+	unsorted := binary.BigEndian.AppendUint16(nil, uint16(nonces.Len()))
+	entries := nonces.SortedEntries()
+	for i := len(entries) - 1; i >= 0; i-- {
+		unsorted = append(unsorted, entries[i].TXID[:]...)
+		unsorted = append(unsorted, entries[i].Nonce[:]...)
+	}
+
+	needed, normalized, err = NeedsCanonicalization(unsorted)
+	require.NoError(t, err)
+	require.True(t, needed)
+	require.Equal(t, canonical, normalized)
+
+	// A zero length record gains an explicit zero count.
+	needed, normalized, err = NeedsCanonicalization(nil)
+	require.NoError(t, err)
+	require.True(t, needed)
+	require.Equal(t, []byte{0x00, 0x00}, normalized)
+
+	// A malformed record can't be canonicalized.
+	_, _, err = NeedsCanonicalization(canonical[:len(canonical)-1])
+	require.ErrorIs(t, err, ErrLocalNoncesLengthMismatch)
+}
+
+// TestLocalNoncesWithinLimit tests that only sets holding more entries than
+// the limit are rejected.
+func TestLocalNoncesWithinLimit(t *testing.T) {
+	t.Parallel()
+
+	nonces := makeTestLocalNonces(3)
+
+	testCases := []struct {
+		name  string
+		limit int
+		valid bool
+	}{
+		{
+			name:  "within",
+			limit: 4,
+			valid: true,
+		},
+		{
+			name:  "at",
+			limit: 3,
+			valid: true,
+		},
+		{
+			name:  "over",
+			limit: 2,
+			valid: false,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := nonces.WithinLimit(tc.limit)
+			if tc.valid {
+				require.NoError(t, err)
+				return
+			}
+			require.ErrorIs(t, err, ErrTooManyLocalNonces)
+		})
+	}
+
+	// An empty set is within any limit.
+	var empty LocalNoncesData
+	require.NoError(t, empty.WithinLimit(0))
+}